@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/controllers"
+	"github.com/vantutran2k1/social-network-auth/events"
+	"github.com/vantutran2k1/social-network-auth/jobs"
+	"github.com/vantutran2k1/social-network-auth/metrics"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/routes"
+	"github.com/vantutran2k1/social-network-auth/sso"
+	"github.com/vantutran2k1/social-network-auth/storage"
+	"github.com/vantutran2k1/social-network-auth/tokencache"
+	"github.com/vantutran2k1/social-network-auth/tracing"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"github.com/vantutran2k1/social-network-auth/validators"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"gorm.io/gorm"
+)
+
+const tracerShutdownTimeout = 5 * time.Second
+
+// App owns everything a running instance of the service depends on. Apps
+// share no state but the metrics of the process and the tracer provider, so
+// several of them can run in the same process.
+type App struct {
+	Config *config.Config
+	// DB is nil with in-memory storage.
+	DB *gorm.DB
+	// DBStats holds the statistics of DB's connection pool, served along
+	// with the metrics of the process. It is nil with in-memory storage.
+	DBStats            *prometheus.Registry
+	Store              models.Store
+	Mailer             utils.Mailer
+	Authenticator      models.Authenticator
+	RegistrationPolicy *validators.RegistrationPolicy
+	IdentityProviders  *sso.Registry
+	Publisher          events.Publisher
+	TokenCache         *tokencache.Cache
+	// TracerProvider is nil when tracing is disabled.
+	TracerProvider *sdktrace.TracerProvider
+	Router         *gin.Engine
+	// Scheduler runs the cleanup jobs once StartJobs is called.
+	Scheduler *jobs.Scheduler
+	// Workers process the outbox, the webhook deliveries and the data
+	// exports once StartJobs is called.
+	Workers []*jobs.Worker
+}
+
+// New builds an app from cfg, which must be valid.
+func New(cfg *config.Config) (*App, error) {
+	validators.RegisterCustomValidators()
+
+	a := &App{Config: cfg, Mailer: utils.NewSMTPMailer(&cfg.SMTP)}
+
+	var err error
+	if a.TracerProvider, err = tracing.Setup(&cfg.Tracing); err != nil {
+		return nil, err
+	}
+
+	if a.RegistrationPolicy, err = validators.NewRegistrationPolicy(&cfg.Registration); err != nil {
+		return nil, err
+	}
+
+	if a.Store, a.DB, err = storage.NewStore(cfg); err != nil {
+		return nil, err
+	}
+
+	if a.UsesPostgres() {
+		sqlDB, err := a.DB.DB()
+		if err != nil {
+			return nil, err
+		}
+		if a.DBStats, err = metrics.NewDBStatsRegistry(sqlDB); err != nil {
+			return nil, err
+		}
+
+		u := models.User{}
+		if err := u.BackfillCanonicalIdentities(a.DB); err != nil {
+			return nil, fmt.Errorf("can not backfill canonical identities: %w", err)
+		}
+	}
+
+	if a.Authenticator, err = models.NewAuthenticator(cfg, a.RegistrationPolicy); err != nil {
+		return nil, err
+	}
+
+	if a.IdentityProviders, err = sso.NewRegistry(&cfg.SAML); err != nil {
+		return nil, err
+	}
+
+	if a.Publisher, err = events.NewPublisher(&cfg.Events); err != nil {
+		return nil, err
+	}
+
+	if a.TokenCache, err = tokencache.New(&cfg.TokenCache); err != nil {
+		return nil, err
+	}
+
+	a.Router = routes.SetupRouter(a.handlers(), a.Store, a.TokenCache, cfg)
+
+	return a, nil
+}
+
+// UsesPostgres tells whether data is stored in Postgres, which the database
+// metrics and the leader election of the cleanup jobs require.
+func (a *App) UsesPostgres() bool {
+	return a.DB != nil
+}
+
+// StartJobs starts the background jobs, which run until Close. The cleanup
+// jobs only run on the replica elected as leader.
+func (a *App) StartJobs() error {
+	a.Workers = []*jobs.Worker{
+		jobs.StartOutboxRelay(a.Store, a.Publisher, &a.Config.Events),
+		jobs.StartWebhookDeliveryJob(a.Store, &a.Config.Webhooks),
+		jobs.StartDataExportJob(a.Store, &a.Config.DataExports),
+	}
+
+	var leader jobs.Leader = jobs.AlwaysLeader{}
+	if a.UsesPostgres() {
+		sqlDB, err := a.DB.DB()
+		if err != nil {
+			return err
+		}
+		leader = jobs.NewAdvisoryLockLeader(sqlDB)
+	}
+
+	a.Scheduler = jobs.NewScheduler(leader, jobs.Reporters{jobs.LogReporter{}, jobs.MetricsReporter{}})
+	a.Scheduler.Add(jobs.ExpiredTokenPurgeJob(a.Store, &a.Config.Jobs))
+	a.Scheduler.Add(jobs.ExpiredResetTokenPurgeJob(a.Store, &a.Config.Jobs))
+	a.Scheduler.Add(jobs.ExpiredDataPurgeJob(a.Store, &a.Config.Jobs))
+	a.Scheduler.Add(jobs.DataExportReclaimJob(a.Store, &a.Config.DataExports))
+	a.Scheduler.Add(jobs.AccountPurgeJob(a.Store, &a.Config.Accounts))
+	a.Scheduler.Start()
+
+	return nil
+}
+
+// Run serves the API on the configured port until it fails.
+func (a *App) Run() error {
+	return a.Router.Run(":" + a.Config.App.Port)
+}
+
+// Close stops the background jobs and waits for them to return, then
+// releases the event publisher, the token cache and the database connection,
+// and flushes the last spans.
+func (a *App) Close() error {
+	for _, worker := range a.Workers {
+		worker.Stop()
+	}
+	if a.Scheduler != nil {
+		a.Scheduler.Stop()
+	}
+
+	err := errors.Join(a.Publisher.Close(), a.TokenCache.Close())
+
+	if a.UsesPostgres() {
+		sqlDB, dbErr := a.DB.DB()
+		if dbErr == nil {
+			dbErr = sqlDB.Close()
+		}
+		err = errors.Join(err, dbErr)
+	}
+
+	if a.TracerProvider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+		defer cancel()
+		err = errors.Join(err, a.TracerProvider.Shutdown(ctx))
+	}
+
+	return err
+}
+
+func (a *App) handlers() *routes.Handlers {
+	return &routes.Handlers{
+		Auth: &controllers.AuthHandler{
+			Store:              a.Store,
+			Config:             a.Config,
+			Mailer:             a.Mailer,
+			Authenticator:      a.Authenticator,
+			RegistrationPolicy: a.RegistrationPolicy,
+			TokenCache:         a.TokenCache,
+		},
+		Admin: &controllers.AdminHandler{
+			Store:      a.Store,
+			Config:     a.Config,
+			Mailer:     a.Mailer,
+			TokenCache: a.TokenCache,
+		},
+		Audit:      &controllers.AuditHandler{Store: a.Store},
+		DataExport: &controllers.DataExportHandler{Store: a.Store, Config: a.Config},
+		InviteCode: &controllers.InviteCodeHandler{Store: a.Store, Config: a.Config},
+		Profile:    &controllers.ProfileHandler{Store: a.Store},
+		SAML: &controllers.SAMLHandler{
+			Store:              a.Store,
+			Config:             a.Config,
+			IdentityProviders:  a.IdentityProviders,
+			RegistrationPolicy: a.RegistrationPolicy,
+		},
+		SCIM: &controllers.SCIMHandler{
+			Store:              a.Store,
+			TokenCache:         a.TokenCache,
+			RegistrationPolicy: a.RegistrationPolicy,
+		},
+		Webhook: &controllers.WebhookHandler{Store: a.Store},
+		Metrics: a.metricsHandler(),
+	}
+}
+
+func (a *App) metricsHandler() http.Handler {
+	if a.DBStats == nil {
+		return metrics.Handler()
+	}
+
+	return metrics.Handler(a.DBStats)
+}
@@ -0,0 +1,213 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/app"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/events"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+func newTestApp(t *testing.T) (*app.App, *events.MemoryPublisher) {
+	t.Helper()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Events.RelayInterval = 10 * time.Millisecond
+
+	a, err := app.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broker, ok := a.Publisher.(*events.MemoryPublisher)
+	if !ok {
+		t.Fatalf("publisher = %T, want the in-process broker", a.Publisher)
+	}
+
+	return a, broker
+}
+
+// serve sends the JSON body to the app as the holder of token, if any.
+func serve(a *app.App, method string, path string, token string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	a.Router.ServeHTTP(w, req)
+
+	return w
+}
+
+// registerAndLogin registers the user and returns them and their token.
+func registerAndLogin(t *testing.T, a *app.App, username string) (*models.User, string) {
+	t.Helper()
+
+	body := `{"username": "` + username + `", "password": "password123", "email": "` + username + `@example.com"}`
+	if w := serve(a, http.MethodPost, "/api/auth/register", "", body); w.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, body = %s", w.Code, w.Body)
+	}
+
+	w := serve(a, http.MethodPost, "/api/auth/login", "", `{"username": "`+username+`", "password": "password123"}`)
+	var login struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &login); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("login status = %d, body = %s", w.Code, w.Body)
+	}
+
+	user, err := a.Store.Users().FindByUsername(context.Background(), utils.CanonicalizeUsername(username))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, login.Data
+}
+
+func TestUpdateUserLevelRequiresAdmin(t *testing.T) {
+	t.Setenv("STORAGE", "memory")
+	t.Setenv("JWT_KEY", "test")
+	t.Setenv("EVENT_PUBLISHER", "memory")
+
+	a, _ := newTestApp(t)
+	defer a.Close()
+
+	admin, adminToken := registerAndLogin(t, a, "alice")
+	user, userToken := registerAndLogin(t, a, "bob")
+	admin.IsAdmin = true
+	if err := a.Store.Users().Save(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"user_id": "` + user.ID.String() + `", "level_name": "GOLD"}`
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{name: "anonymous", token: "", want: http.StatusUnauthorized},
+		{name: "user", token: userToken, want: http.StatusForbidden},
+		{name: "admin", token: adminToken, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(a, http.MethodPatch, "/api/auth/level", tt.token, body); w.Code != tt.want {
+				t.Errorf("update level status = %d, want %d, body = %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	events, err := a.Store.AuditEvents().Find(context.Background(), models.AuditEventFilter{Action: models.AuditLevelUpdated}, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ActorID == nil || *events[0].ActorID != admin.ID {
+		t.Errorf("level audit events = %+v, want one by the admin", events)
+	}
+}
+
+func TestDataExportIsHiddenFromImpersonators(t *testing.T) {
+	t.Setenv("STORAGE", "memory")
+	t.Setenv("JWT_KEY", "test")
+	t.Setenv("EVENT_PUBLISHER", "memory")
+
+	a, _ := newTestApp(t)
+	defer a.Close()
+
+	admin, adminToken := registerAndLogin(t, a, "alice")
+	user, userToken := registerAndLogin(t, a, "bob")
+	admin.IsAdmin = true
+	if err := a.Store.Users().Save(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	var export struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	w := serve(a, http.MethodPost, "/api/auth/data-export", userToken, `{}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil || w.Code != http.StatusAccepted {
+		t.Fatalf("request export status = %d, body = %s", w.Code, w.Body)
+	}
+
+	var impersonation struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	w = serve(a, http.MethodPost, "/api/admin/users/"+user.ID.String()+"/impersonate", adminToken, `{}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &impersonation); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("impersonate status = %d, body = %s", w.Code, w.Body)
+	}
+
+	path := "/api/auth/data-export/" + export.Data.ID
+	if w := serve(a, http.MethodGet, path, impersonation.Data.Token, ""); w.Code != http.StatusForbidden {
+		t.Errorf("impersonated get export status = %d, want %d, body = %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	if w := serve(a, http.MethodGet, path, userToken, ""); w.Code != http.StatusOK {
+		t.Errorf("get export status = %d, want %d, body = %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestAppsRunIsolatedAndStopOnClose(t *testing.T) {
+	t.Setenv("STORAGE", "memory")
+	t.Setenv("JWT_KEY", "test")
+	t.Setenv("EVENT_PUBLISHER", "memory")
+
+	first, firstBroker := newTestApp(t)
+	second, secondBroker := newTestApp(t)
+	for _, a := range []*app.App{first, second} {
+		if err := a.StartJobs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := `{"username": "alice", "password": "password123", "email": "alice@example.com"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	first.Router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+		t.Fatalf("register status = %d, body = %s", w.Code, w.Body)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); len(firstBroker.Events()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the registration event was not relayed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if e := firstBroker.Events()[0]; e.Type != models.UserRegisteredEvent {
+		t.Errorf("relayed event type = %s, want %s", e.Type, models.UserRegisteredEvent)
+	}
+
+	for _, a := range []*app.App{first, second} {
+		closed := make(chan error)
+		go func() { closed <- a.Close() }()
+		select {
+		case err := <-closed:
+			if err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close() did not return")
+		}
+	}
+
+	if events := secondBroker.Events(); len(events) != 0 {
+		t.Errorf("second app relayed %d events of the first one", len(events))
+	}
+}
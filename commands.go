@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/app"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/db/migrations"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+	"github.com/vantutran2k1/social-network-auth/tokencache"
+	"gorm.io/gorm"
+)
+
+func serve(cfg *config.Config, args []string) error {
+	if err := flag.NewFlagSet("serve", flag.ExitOnError).Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.Storage == storage.PostgresStorage && cfg.DB.MigrateOnStartup {
+		if err := migrateOnStartup(cfg); err != nil {
+			return err
+		}
+	}
+
+	a, err := app.New(cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.StartJobs(); err != nil {
+		return err
+	}
+
+	return a.Run()
+}
+
+func migrateOnStartup(cfg *config.Config) error {
+	m, err := migrations.New(&cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := m.UpWithLock(context.Background()); err != nil {
+		return fmt.Errorf("migration on startup failed: %w", err)
+	}
+
+	return nil
+}
+
+func migrateCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up|down|status|force")
+	}
+	if cfg.Storage != storage.PostgresStorage {
+		return errors.New("migrate needs postgres storage")
+	}
+
+	m, err := migrations.New(&cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	action, args := args[0], args[1:]
+	switch action {
+	case "up":
+		if len(args) == 0 {
+			err = m.Up()
+		} else {
+			var steps int
+			if steps, err = parseSteps(args[0]); err == nil {
+				err = m.Steps(steps)
+			}
+		}
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		all := flags.Bool("all", false, "revert all migrations")
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+
+		steps := 1
+		switch {
+		case *all:
+			steps = 0
+		case flags.NArg() > 0:
+			if steps, err = parseSteps(flags.Arg(0)); err != nil {
+				return err
+			}
+		}
+		err = m.Down(steps)
+	case "status":
+		return printMigrationStatus(m)
+	case "force":
+		if len(args) != 1 {
+			return errors.New("usage: migrate force VERSION")
+		}
+		version, convErr := strconv.Atoi(args[0])
+		if convErr != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		err = m.Force(version)
+	default:
+		return fmt.Errorf("unknown migrate command %q", action)
+	}
+	if err != nil {
+		return err
+	}
+
+	return printMigrationStatus(m)
+}
+
+func parseSteps(value string) (int, error) {
+	steps, err := strconv.Atoi(value)
+	if err != nil || steps <= 0 {
+		return 0, fmt.Errorf("invalid number of migrations %q", value)
+	}
+
+	return steps, nil
+}
+
+func printMigrationStatus(m *migrations.Migrator) error {
+	status, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, migration := range status.Migrations {
+		state := "pending"
+		if migration.Applied {
+			state = "applied"
+		}
+		fmt.Fprintf(w, "%06d\t%s\t%s\n", migration.Version, migration.Name, state)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if status.Dirty {
+		fmt.Printf("version %d is dirty: fix the schema by hand, then run migrate force\n", status.Version)
+	} else {
+		fmt.Printf("version %d\n", status.Version)
+	}
+
+	return nil
+}
+
+func createAdmin(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	username := flags.String("username", "", "username of the admin")
+	email := flags.String("email", "", "email of the admin")
+	password := flags.String("password", "", "password of the admin, read from standard input if not set")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" || *email == "" {
+		return errors.New("usage: create-admin -username USERNAME -email EMAIL [-password PASSWORD]")
+	}
+
+	if *password == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("can not read password: %w", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+	if *password == "" {
+		return errors.New("password can not be empty")
+	}
+
+	store, db, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	user := models.User{}
+	if err := user.CreateAdmin(context.Background(), store, *username, *password, *email); err != nil {
+		return err
+	}
+
+	fmt.Printf("created admin %s (%v)\n", user.Username, user.ID)
+	return nil
+}
+
+func revokeUserTokens(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: revoke-user-tokens USER")
+	}
+
+	store, db, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	// Publishing the revocation to the shared token cache, if any, makes it
+	// take effect on the running replicas within the revocation poll
+	// interval rather than the local cache TTL.
+	cache, err := tokencache.New(&cfg.TokenCache)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+
+	ctx := context.Background()
+
+	var user *models.User
+	if id, parseErr := uuid.Parse(args[0]); parseErr == nil {
+		if user, err = store.Users().FindByIDWithDeleted(ctx, id); err != nil {
+			return fmt.Errorf("user %v not found: %w", id, err)
+		}
+	} else {
+		u := models.User{}
+		found, apiErr := u.GetUserByUsernameOrEmail(ctx, store, args[0])
+		if apiErr != nil {
+			return apiErr
+		}
+		user = found
+	}
+
+	token := models.Token{}
+	if err := token.RevokeUserActiveTokens(ctx, store, cache, user.ID); err != nil {
+		return err
+	}
+
+	fmt.Printf("revoked the tokens of %s (%v)\n", user.Username, user.ID)
+	return nil
+}
+
+func purgeExpired(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("purge-expired", flag.ExitOnError)
+	olderThan := flags.String("older-than", "7d", "only purge what expired longer ago than this")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	retention, err := config.ParseDuration(*olderThan)
+	if err != nil {
+		return fmt.Errorf("invalid -older-than: %w", err)
+	}
+
+	store, db, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+
+	ctx := context.Background()
+	before := time.Now().UTC().Add(-retention)
+
+	tokens, err := models.PurgeExpiredTokens(ctx, store, before, cfg.Jobs.TokenPurgeBatchSize)
+	if err != nil {
+		return err
+	}
+	resetTokens, err := models.PurgeExpiredPasswordResetTokens(ctx, store, before, cfg.Jobs.ResetTokenPurgeBatchSize)
+	if err != nil {
+		return err
+	}
+	purge, err := models.PurgeExpiredData(ctx, store, before)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(
+		"purged %d tokens, %d password reset tokens, %d email change requests, %d data exports and %d SAML assertions\n",
+		tokens, resetTokens, purge.EmailChangeRequests, purge.DataExports, purge.SAMLAssertions,
+	)
+
+	u := models.User{}
+	purged, err := u.PurgeDeletedAccounts(ctx, store, cfg.Accounts.PurgeBatchSize)
+	fmt.Printf("purged %d deleted accounts\n", purged)
+	return err
+}
+
+func printConfig(cfg *config.Config, args []string) error {
+	return cfg.Dump(os.Stdout)
+}
+
+// openStore connects to the database the management commands work on,
+// which only makes sense with Postgres storage.
+func openStore(cfg *config.Config) (models.Store, *gorm.DB, error) {
+	if cfg.Storage != storage.PostgresStorage {
+		return nil, nil, errors.New("this command needs postgres storage")
+	}
+
+	return storage.NewStore(cfg)
+}
+
+func closeDB(db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		log.Printf("failed to close database: %v", err)
+	}
+}
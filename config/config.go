@@ -1,26 +1,371 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"os"
 )
 
-var DB *gorm.DB
+// Config holds the settings an App is built from. Building it by hand
+// instead of loading it lets several apps with different settings run in
+// the same process.
+//
+// Every setting has a key in configuration files, built from the key tags
+// of its field and the sections it is in, and an environment variable that
+// overrides it. Durations are given as Go durations ("90m"), in days
+// ("30d"), or as bare numbers counted in the unit of the setting, which
+// keeps the historical environment variables working.
+type Config struct {
+	App          AppConfig          `key:"app"`
+	Storage      string             `key:"storage" env:"STORAGE" default:"postgres"`
+	DB           DBConfig           `key:"db"`
+	Tokens       TokenConfig        `key:"tokens"`
+	TokenCache   TokenCacheConfig   `key:"token_cache"`
+	SMTP         SMTPConfig         `key:"smtp"`
+	Registration RegistrationConfig `key:"registration"`
+	InviteCodes  InviteCodeConfig   `key:"invite_codes"`
+	Usernames    UsernameConfig     `key:"usernames"`
+	Accounts     AccountConfig      `key:"accounts"`
+	Jobs         JobConfig          `key:"jobs"`
+	DataExports  DataExportConfig   `key:"data_exports"`
+	Auth         AuthConfig         `key:"auth"`
+	LDAP         LDAPConfig         `key:"ldap"`
+	SAML         SAMLConfig         `key:"saml"`
+	SCIM         SCIMConfig         `key:"scim"`
+	Events       EventConfig        `key:"events"`
+	Webhooks     WebhookConfig      `key:"webhooks"`
+	Tracing      TracingConfig      `key:"tracing"`
+}
+
+type AppConfig struct {
+	Port string `key:"port" env:"APP_PORT" default:"8080"`
+	// BaseURL is the public URL of the application, used to build links
+	// sent to users.
+	BaseURL string `key:"base_url" env:"APP_BASE_URL" default:"http://localhost:8080"`
+}
+
+type DBConfig struct {
+	Host            string        `key:"host" env:"DB_HOST" default:"localhost"`
+	Port            int           `key:"port" env:"DB_PORT" default:"5432"`
+	User            string        `key:"user" env:"DB_USER" default:"postgres"`
+	Password        string        `key:"password" env:"DB_PASSWORD" secret:"true"`
+	Name            string        `key:"name" env:"DB_NAME"`
+	SSLMode         string        `key:"sslmode" env:"DB_SSLMODE" default:"disable"`
+	MaxOpenConns    int           `key:"max_open_conns" env:"DB_MAX_OPEN_CONNS" default:"25"`
+	MaxIdleConns    int           `key:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" default:"5"`
+	ConnMaxLifetime time.Duration `key:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" default:"30m" unit:"s"`
+	ConnMaxIdleTime time.Duration `key:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" default:"5m" unit:"s"`
+	// MigrateOnStartup applies pending migrations before serving.
+	MigrateOnStartup bool `key:"migrate_on_startup" env:"DB_MIGRATE_ON_STARTUP"`
+}
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+func (c *DBConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s", c.Host, c.Port, c.User, c.Password, c.Name, c.SSLMode)
+}
 
-func InitDB() {
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
+// OpenDB connects to the database described by cfg and sizes its
+// connection pool.
+func OpenDB(cfg *DBConfig) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
 
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", dbHost, dbPort, dbUser, dbPassword, dbName)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	sqlDB, err := db.DB()
 	if err != nil {
-		panic("failed to connect database")
+		return nil, err
 	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	DB = db
+	return db, nil
+}
+
+type TokenConfig struct {
+	JWTKey                  string        `key:"jwt_key" env:"JWT_KEY" secret:"true"`
+	Expiration              time.Duration `key:"expiration" env:"JWT_EXPIRATION_MINUTES" default:"10h" unit:"m"`
+	ImpersonationExpiration time.Duration `key:"impersonation_expiration" env:"IMPERSONATION_TOKEN_EXPIRATION_MINUTES" default:"15m" unit:"m"`
+	PasswordResetExpiration time.Duration `key:"password_reset_expiration" env:"RESET_PASSWORD_TOKEN_EXPIRATION_MINUTES" default:"1h" unit:"m"`
+	EmailChangeExpiration   time.Duration `key:"email_change_expiration" env:"EMAIL_CHANGE_TOKEN_EXPIRATION_MINUTES" default:"1h" unit:"m"`
+}
+
+// TokenCacheConfig sets up the cache of valid tokens that spares
+// authenticated requests a database lookup. Without a shared cache, a token
+// revoked on another replica stays usable here for up to LocalTTL.
+type TokenCacheConfig struct {
+	// Size is how many tokens each replica keeps in memory.
+	Size     int           `key:"size" env:"TOKEN_CACHE_SIZE" default:"10000"`
+	LocalTTL time.Duration `key:"local_ttl" env:"TOKEN_CACHE_LOCAL_TTL_SECONDS" default:"30s" unit:"s"`
+	// RedisURL points to a Redis-compatible server shared by the replicas,
+	// such as redis://:password@host:6379/0. It is optional.
+	RedisURL  string        `key:"redis_url" env:"TOKEN_CACHE_REDIS_URL" secret:"true"`
+	SharedTTL time.Duration `key:"shared_ttl" env:"TOKEN_CACHE_SHARED_TTL_SECONDS" default:"5m" unit:"s"`
+	KeyPrefix string        `key:"key_prefix" env:"TOKEN_CACHE_KEY_PREFIX" default:"auth:tokens:"`
+	// RevocationPollInterval is how often replicas read the revocations
+	// published to the shared cache, which bounds how long a revoked token
+	// stays usable on another replica.
+	RevocationPollInterval time.Duration `key:"revocation_poll_interval" env:"TOKEN_CACHE_REVOCATION_POLL_INTERVAL_SECONDS" default:"2s" unit:"s"`
+}
+
+type SMTPConfig struct {
+	Host      string `key:"host" env:"SMTP_HOST" default:"localhost"`
+	Port      int    `key:"port" env:"SMTP_PORT" default:"25"`
+	User      string `key:"user" env:"SMTP_USER"`
+	Password  string `key:"password" env:"SMTP_PASSWORD" secret:"true"`
+	FromEmail string `key:"from_email" env:"SMTP_FROM_EMAIL"`
+}
+
+type RegistrationConfig struct {
+	// ReservedUsernames are reserved on top of the built-in ones.
+	ReservedUsernames     []string `key:"reserved_usernames" env:"REGISTRATION_RESERVED_USERNAMES"`
+	BlockedWords          []string `key:"blocked_words" env:"REGISTRATION_BLOCKED_WORDS"`
+	UsernamePattern       string   `key:"username_pattern" env:"REGISTRATION_USERNAME_PATTERN" default:"^[a-zA-Z0-9_.-]+$"`
+	UsernameMinLength     int      `key:"username_min_length" env:"REGISTRATION_USERNAME_MIN_LENGTH" default:"3"`
+	UsernameMaxLength     int      `key:"username_max_length" env:"REGISTRATION_USERNAME_MAX_LENGTH" default:"32"`
+	EmailDomainAllowlist  []string `key:"email_domain_allowlist" env:"REGISTRATION_EMAIL_DOMAIN_ALLOWLIST"`
+	EmailDomainDenylist   []string `key:"email_domain_denylist" env:"REGISTRATION_EMAIL_DOMAIN_DENYLIST"`
+	BlockDisposableEmails bool     `key:"block_disposable_emails" env:"REGISTRATION_BLOCK_DISPOSABLE_EMAILS" default:"true"`
+	InviteOnly            bool     `key:"invite_only" env:"REGISTRATION_INVITE_ONLY"`
+}
+
+type InviteCodeConfig struct {
+	Expiration  time.Duration `key:"expiration" env:"INVITE_CODE_EXPIRATION_DAYS" default:"14d" unit:"d"`
+	MaxUses     int           `key:"max_uses" env:"INVITE_CODE_MAX_USES" default:"5"`
+	BronzeQuota int           `key:"bronze_quota" env:"INVITE_CODE_QUOTA_BRONZE"`
+	SilverQuota int           `key:"silver_quota" env:"INVITE_CODE_QUOTA_SILVER" default:"3"`
+	GoldQuota   int           `key:"gold_quota" env:"INVITE_CODE_QUOTA_GOLD" default:"10"`
+	// QuotaPeriod is the rolling window the quotas count codes over, so
+	// that revoking or using up codes does not free up quota.
+	QuotaPeriod time.Duration `key:"quota_period" env:"INVITE_CODE_QUOTA_PERIOD_DAYS" default:"30d" unit:"d"`
+}
+
+// Quota returns how many invite codes users of the level may create per
+// QuotaPeriod. Unknown levels get none.
+func (c *InviteCodeConfig) Quota(level string) int {
+	switch level {
+	case "BRONZE":
+		return c.BronzeQuota
+	case "SILVER":
+		return c.SilverQuota
+	case "GOLD":
+		return c.GoldQuota
+	default:
+		return 0
+	}
+}
+
+type UsernameConfig struct {
+	ChangeCooldown time.Duration `key:"change_cooldown" env:"USERNAME_CHANGE_COOLDOWN_DAYS" default:"30d" unit:"d"`
+	// Hold is how long an old username stays reserved for its previous owner.
+	Hold time.Duration `key:"hold" env:"USERNAME_HOLD_DAYS" default:"90d" unit:"d"`
+}
+
+type AccountConfig struct {
+	DeletionGracePeriod time.Duration `key:"deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD_DAYS" default:"30d" unit:"d"`
+	PurgeInterval       time.Duration `key:"purge_interval" env:"ACCOUNT_PURGE_INTERVAL_MINUTES" default:"1h" unit:"m"`
+	PurgeBatchSize      int           `key:"purge_batch_size" env:"ACCOUNT_PURGE_BATCH_SIZE" default:"100"`
+}
+
+// JobConfig sets how often the cleanup jobs run and how much they delete
+// at once. Expired tokens are kept for a while before being purged, as they
+// still show up in the sessions users see in their data exports.
+type JobConfig struct {
+	TokenPurgeInterval         time.Duration `key:"token_purge_interval" env:"TOKEN_PURGE_INTERVAL_MINUTES" default:"1h" unit:"m"`
+	TokenPurgeBatchSize        int           `key:"token_purge_batch_size" env:"TOKEN_PURGE_BATCH_SIZE" default:"1000"`
+	ExpiredTokenRetention      time.Duration `key:"expired_token_retention" env:"EXPIRED_TOKEN_RETENTION_DAYS" default:"7d" unit:"d"`
+	ResetTokenPurgeInterval    time.Duration `key:"reset_token_purge_interval" env:"RESET_TOKEN_PURGE_INTERVAL_MINUTES" default:"1h" unit:"m"`
+	ResetTokenPurgeBatchSize   int           `key:"reset_token_purge_batch_size" env:"RESET_TOKEN_PURGE_BATCH_SIZE" default:"1000"`
+	ExpiredResetTokenRetention time.Duration `key:"expired_reset_token_retention" env:"EXPIRED_RESET_TOKEN_RETENTION_DAYS" default:"1d" unit:"d"`
+	// ExpiredDataPurgeInterval is how often the email changes and data
+	// exports that expired are deleted.
+	ExpiredDataPurgeInterval time.Duration `key:"expired_data_purge_interval" env:"EXPIRED_DATA_PURGE_INTERVAL_MINUTES" default:"1h" unit:"m"`
+}
+
+// DataExportConfig sets how long exports can be downloaded and how they are
+// generated. Pending exports are generated by every replica, at most
+// ProcessingBatchSize at a time, and handed to another replica if they are
+// still processing after ProcessingTimeout.
+type DataExportConfig struct {
+	Expiration          time.Duration `key:"expiration" env:"DATA_EXPORT_EXPIRATION_HOURS" default:"24h" unit:"h"`
+	ProcessingInterval  time.Duration `key:"processing_interval" env:"DATA_EXPORT_PROCESSING_INTERVAL_SECONDS" default:"5s" unit:"s"`
+	ProcessingBatchSize int           `key:"processing_batch_size" env:"DATA_EXPORT_PROCESSING_BATCH_SIZE" default:"5"`
+	ProcessingTimeout   time.Duration `key:"processing_timeout" env:"DATA_EXPORT_PROCESSING_TIMEOUT_MINUTES" default:"30m" unit:"m"`
+	ReclaimInterval     time.Duration `key:"reclaim_interval" env:"DATA_EXPORT_RECLAIM_INTERVAL_MINUTES" default:"5m" unit:"m"`
+}
+
+type AuthConfig struct {
+	// Backends are the authentication backends, in the order they are tried.
+	Backends []string `key:"backends" env:"AUTH_BACKENDS" default:"database"`
+}
+
+var redisSchemes = []string{"redis", "rediss", "unix"}
+
+var authBackends = []string{"database", "ldap"}
+
+type SCIMConfig struct {
+	// BearerToken authenticates identity providers calling the SCIM API.
+	// The API refuses every request when it is empty.
+	BearerToken string `key:"bearer_token" env:"SCIM_BEARER_TOKEN" secret:"true"`
+}
+
+type EventConfig struct {
+	// Publisher is "log", "webhook", "nats", "kafka" or "memory".
+	Publisher         string        `key:"publisher" env:"EVENT_PUBLISHER" default:"log"`
+	WebhookURL        string        `key:"webhook_url" env:"EVENT_WEBHOOK_URL"`
+	WebhookSecret     string        `key:"webhook_secret" env:"EVENT_WEBHOOK_SECRET" secret:"true"`
+	NATSURL           string        `key:"nats_url" env:"EVENT_NATS_URL"`
+	NATSSubjectPrefix string        `key:"nats_subject_prefix" env:"EVENT_NATS_SUBJECT_PREFIX" default:"users."`
+	KafkaBrokers      string        `key:"kafka_brokers" env:"EVENT_KAFKA_BROKERS"`
+	KafkaTopic        string        `key:"kafka_topic" env:"EVENT_KAFKA_TOPIC" default:"user-events"`
+	RelayInterval     time.Duration `key:"relay_interval" env:"OUTBOX_RELAY_INTERVAL_SECONDS" default:"5s" unit:"s"`
+	RelayBatchSize    int           `key:"relay_batch_size" env:"OUTBOX_RELAY_BATCH_SIZE" default:"100"`
+	RelayMaxAttempts  int           `key:"relay_max_attempts" env:"OUTBOX_RELAY_MAX_ATTEMPTS" default:"10"`
+}
+
+var eventPublishers = []string{"log", "webhook", "nats", "kafka", "memory"}
+
+type WebhookConfig struct {
+	DeliveryInterval  time.Duration `key:"delivery_interval" env:"WEBHOOK_DELIVERY_INTERVAL_SECONDS" default:"5s" unit:"s"`
+	DeliveryBatchSize int           `key:"delivery_batch_size" env:"WEBHOOK_DELIVERY_BATCH_SIZE" default:"50"`
+	MaxAttempts       int           `key:"max_attempts" env:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	// RetryBase is the delay before the first retry, doubled for every
+	// later one.
+	RetryBase time.Duration `key:"retry_base" env:"WEBHOOK_RETRY_BASE_SECONDS" default:"30s" unit:"s"`
+}
+
+type TracingConfig struct {
+	// Exporter is "none", "stdout" for local use, or "otlp".
+	Exporter    string `key:"exporter" env:"TRACING_EXPORTER" default:"none"`
+	ServiceName string `key:"service_name" env:"TRACING_SERVICE_NAME" default:"social-network-auth"`
+	// OTLPEndpoint is the host and port of the OTLP/HTTP collector. The
+	// standard OTEL_EXPORTER_OTLP_* variables apply when it is empty.
+	OTLPEndpoint string `key:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool   `key:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+	// SamplePercent is the share of the traces started here that are
+	// recorded. Traces started by callers follow their sampling decision.
+	SamplePercent int `key:"sample_percent" env:"TRACING_SAMPLE_PERCENT" default:"100"`
+}
+
+var tracingExporters = []string{"none", "stdout", "otlp"}
+
+// Validate checks that the settings are usable, so that mistakes are
+// reported at startup rather than on the first request that needs them.
+// All problems found are returned together.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.App.Port != "", "app.port is required")
+	baseURL, err := url.Parse(c.App.BaseURL)
+	check(err == nil && baseURL.IsAbs(), "app.base_url must be an absolute URL")
+
+	check(c.Storage == "postgres" || c.Storage == "memory", "storage must be postgres or memory, not %q", c.Storage)
+	if c.Storage == "postgres" {
+		check(c.DB.Host != "", "db.host is required")
+		check(c.DB.Name != "", "db.name is required")
+		check(slices.Contains(sslModes, c.DB.SSLMode), "db.sslmode must be one of %s", strings.Join(sslModes, ", "))
+		check(c.DB.MaxOpenConns >= 0, "db.max_open_conns can not be negative")
+		check(c.DB.MaxIdleConns >= 0, "db.max_idle_conns can not be negative")
+	}
+
+	check(c.Tokens.JWTKey != "", "tokens.jwt_key is required")
+	check(c.Tokens.Expiration > 0, "tokens.expiration must be positive")
+	check(c.Tokens.ImpersonationExpiration > 0, "tokens.impersonation_expiration must be positive")
+	check(c.Tokens.PasswordResetExpiration > 0, "tokens.password_reset_expiration must be positive")
+	check(c.Tokens.EmailChangeExpiration > 0, "tokens.email_change_expiration must be positive")
+
+	check(c.TokenCache.Size > 0, "token_cache.size must be positive")
+	check(c.TokenCache.LocalTTL > 0, "token_cache.local_ttl must be positive")
+	if c.TokenCache.RedisURL != "" {
+		redisURL, err := url.Parse(c.TokenCache.RedisURL)
+		check(
+			err == nil && slices.Contains(redisSchemes, redisURL.Scheme),
+			"token_cache.redis_url must be a URL with scheme %s", strings.Join(redisSchemes, ", "),
+		)
+		check(c.TokenCache.SharedTTL > 0, "token_cache.shared_ttl must be positive")
+		check(c.TokenCache.RevocationPollInterval > 0, "token_cache.revocation_poll_interval must be positive")
+	}
+
+	_, err = regexp.Compile(c.Registration.UsernamePattern)
+	check(err == nil, "registration.username_pattern is not a valid regular expression: %v", err)
+	check(c.Registration.UsernameMinLength > 0, "registration.username_min_length must be positive")
+	check(
+		c.Registration.UsernameMaxLength >= c.Registration.UsernameMinLength,
+		"registration.username_max_length can not be less than registration.username_min_length",
+	)
+
+	check(c.InviteCodes.Expiration > 0, "invite_codes.expiration must be positive")
+	check(c.InviteCodes.MaxUses > 0, "invite_codes.max_uses must be positive")
+	check(c.InviteCodes.QuotaPeriod > 0, "invite_codes.quota_period must be positive")
+	check(c.Accounts.PurgeInterval > 0, "accounts.purge_interval must be positive")
+	check(c.Accounts.PurgeBatchSize > 0, "accounts.purge_batch_size must be positive")
+	check(c.Jobs.TokenPurgeInterval > 0, "jobs.token_purge_interval must be positive")
+	check(c.Jobs.TokenPurgeBatchSize > 0, "jobs.token_purge_batch_size must be positive")
+	check(c.Jobs.ExpiredTokenRetention >= 0, "jobs.expired_token_retention can not be negative")
+	check(c.Jobs.ResetTokenPurgeInterval > 0, "jobs.reset_token_purge_interval must be positive")
+	check(c.Jobs.ResetTokenPurgeBatchSize > 0, "jobs.reset_token_purge_batch_size must be positive")
+	check(c.Jobs.ExpiredResetTokenRetention >= 0, "jobs.expired_reset_token_retention can not be negative")
+	check(c.Jobs.ExpiredDataPurgeInterval > 0, "jobs.expired_data_purge_interval must be positive")
+	check(c.DataExports.Expiration > 0, "data_exports.expiration must be positive")
+	check(c.DataExports.ProcessingInterval > 0, "data_exports.processing_interval must be positive")
+	check(c.DataExports.ProcessingBatchSize > 0, "data_exports.processing_batch_size must be positive")
+	check(c.DataExports.ProcessingTimeout > 0, "data_exports.processing_timeout must be positive")
+	check(c.DataExports.ReclaimInterval > 0, "data_exports.reclaim_interval must be positive")
+
+	check(len(c.Auth.Backends) > 0, "auth.backends can not be empty")
+	for _, backend := range c.Auth.Backends {
+		check(slices.Contains(authBackends, backend), "unknown authentication backend %q", backend)
+	}
+	if slices.Contains(c.Auth.Backends, "ldap") {
+		check(c.LDAP.URL != "", "ldap.url is required by the ldap authentication backend")
+		check(c.LDAP.Timeout > 0, "ldap.timeout must be positive")
+	}
+
+	if c.SAML.Enabled() {
+		check(c.SAML.SPBaseURL != "", "saml.sp_base_url is required when saml.idp_config_file is set")
+		check(c.SAML.SPCertFile != "" && c.SAML.SPKeyFile != "", "saml.sp_cert_file and saml.sp_key_file are required when saml.idp_config_file is set")
+		check(c.SAML.MetadataTimeout > 0, "saml.metadata_timeout must be positive")
+	}
+
+	check(slices.Contains(eventPublishers, c.Events.Publisher), "events.publisher must be one of %s", strings.Join(eventPublishers, ", "))
+	check(c.Events.RelayInterval > 0, "events.relay_interval must be positive")
+	check(c.Events.RelayBatchSize > 0, "events.relay_batch_size must be positive")
+	check(c.Events.RelayMaxAttempts > 0, "events.relay_max_attempts must be positive")
+
+	check(c.Webhooks.DeliveryInterval > 0, "webhooks.delivery_interval must be positive")
+	check(c.Webhooks.DeliveryBatchSize > 0, "webhooks.delivery_batch_size must be positive")
+	check(c.Webhooks.MaxAttempts > 0, "webhooks.max_attempts must be positive")
+	check(c.Webhooks.RetryBase > 0, "webhooks.retry_base must be positive")
+
+	check(slices.Contains(tracingExporters, c.Tracing.Exporter), "tracing.exporter must be one of %s", strings.Join(tracingExporters, ", "))
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+	check(c.Tracing.SamplePercent >= 0 && c.Tracing.SamplePercent <= 100, "tracing.sample_percent must be between 0 and 100")
+
+	return errors.Join(errs...)
+}
+
+// normalize puts the settings that are matched case-insensitively in
+// lower case.
+func (c *Config) normalize() {
+	c.Storage = strings.ToLower(c.Storage)
+	c.Events.Publisher = strings.ToLower(c.Events.Publisher)
+	c.Tracing.Exporter = strings.ToLower(c.Tracing.Exporter)
+	for i, backend := range c.Auth.Backends {
+		c.Auth.Backends[i] = strings.ToLower(backend)
+	}
 }
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[redacted]"
+
+// Dump writes the configuration as a YAML configuration file, with the
+// secrets that are set replaced by a placeholder, so that it can be logged
+// or shared when troubleshooting.
+func (c *Config) Dump(w io.Writer) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{"": root}
+
+	for _, s := range settingsOf(c) {
+		parent := root
+		path, name := "", s.key
+		if i := strings.LastIndex(s.key, "."); i >= 0 {
+			path, name = s.key[:i], s.key[i+1:]
+			parent = section(sections, path)
+		}
+
+		parent.Content = append(parent.Content, scalarNode(name), s.node())
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// section returns the mapping node of the section at the dotted path,
+// adding it and its parents on first use.
+func section(sections map[string]*yaml.Node, path string) *yaml.Node {
+	if node, ok := sections[path]; ok {
+		return node
+	}
+
+	parentPath, name := "", path
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		parentPath, name = path[:i], path[i+1:]
+	}
+	parent := section(sections, parentPath)
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, scalarNode(name), node)
+	sections[path] = node
+	return node
+}
+
+func (s *setting) node() *yaml.Node {
+	v := s.value.Interface()
+	if s.secret && !s.value.IsZero() {
+		return scalarNode(redacted)
+	}
+
+	switch v := v.(type) {
+	case time.Duration:
+		return scalarNode(formatDuration(v))
+	case []string:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, item := range v {
+			node.Content = append(node.Content, scalarNode(item))
+		}
+		return node
+	default:
+		node := &yaml.Node{}
+		if err := node.Encode(v); err != nil {
+			return scalarNode(fmt.Sprint(v))
+		}
+		return node
+	}
+}
+
+func scalarNode(value string) *yaml.Node {
+	node := &yaml.Node{}
+	node.SetString(value)
+	return node
+}
+
+// formatDuration prints durations the way they are usually configured,
+// "1h" rather than "1h0m0s" and whole days in days.
+func formatDuration(d time.Duration) string {
+	if d != 0 && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
@@ -0,0 +1,21 @@
+package config
+
+import "time"
+
+type LDAPConfig struct {
+	URL          string `key:"url" env:"LDAP_URL"`
+	BindDN       string `key:"bind_dn" env:"LDAP_BIND_DN"`
+	BindPassword string `key:"bind_password" env:"LDAP_BIND_PASSWORD" secret:"true"`
+	SearchBase   string `key:"search_base" env:"LDAP_SEARCH_BASE"`
+	// UserFilter finds the user; %s is replaced by the escaped username.
+	UserFilter         string `key:"user_filter" env:"LDAP_USER_FILTER" default:"(&(objectClass=person)(uid=%s))"`
+	UsernameAttribute  string `key:"username_attribute" env:"LDAP_USERNAME_ATTRIBUTE" default:"uid"`
+	EmailAttribute     string `key:"email_attribute" env:"LDAP_EMAIL_ATTRIBUTE" default:"mail"`
+	FirstNameAttribute string `key:"first_name_attribute" env:"LDAP_FIRST_NAME_ATTRIBUTE" default:"givenName"`
+	LastNameAttribute  string `key:"last_name_attribute" env:"LDAP_LAST_NAME_ATTRIBUTE" default:"sn"`
+	StartTLS           bool   `key:"start_tls" env:"LDAP_START_TLS"`
+	InsecureSkipVerify bool   `key:"insecure_skip_verify" env:"LDAP_INSECURE_SKIP_VERIFY"`
+	// Timeout bounds connecting to the server and each request made to it,
+	// so that an unresponsive server does not hold logins forever.
+	Timeout time.Duration `key:"timeout" env:"LDAP_TIMEOUT_SECONDS" default:"10s" unit:"s"`
+}
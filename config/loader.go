@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a leaf field of Config, described by its struct tags.
+type setting struct {
+	// key is the dotted path of the setting in configuration files.
+	key    string
+	env    string
+	def    string
+	secret bool
+	unit   time.Duration
+	value  reflect.Value
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load builds the configuration from, in increasing order of precedence:
+// the defaults, the YAML or TOML file at path if path is not empty, the
+// environment variables, and the files named by <VARIABLE>_FILE, which
+// lets secrets be mounted as files. The result is validated.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	settings := settingsOf(cfg)
+
+	for _, s := range settings {
+		if err := s.set(s.def); err != nil {
+			return nil, err
+		}
+	}
+
+	if path != "" {
+		if err := loadFile(path, settings); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if err := s.loadEnv(); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// settingsOf lists the settings of cfg in the order they are declared.
+func settingsOf(cfg *Config) []*setting {
+	var settings []*setting
+	collectSettings(reflect.ValueOf(cfg).Elem(), "", &settings)
+	return settings
+}
+
+func collectSettings(v reflect.Value, prefix string, settings *[]*setting) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, ok := field.Tag.Lookup("key")
+		if !ok {
+			continue
+		}
+		key = prefix + key
+
+		if field.Type.Kind() == reflect.Struct {
+			collectSettings(v.Field(i), key+".", settings)
+			continue
+		}
+
+		*settings = append(*settings, &setting{
+			key:    key,
+			env:    field.Tag.Get("env"),
+			def:    field.Tag.Get("default"),
+			secret: field.Tag.Get("secret") == "true",
+			unit:   units[field.Tag.Get("unit")],
+			value:  v.Field(i),
+		})
+	}
+}
+
+func (s *setting) loadEnv() error {
+	if s.env == "" {
+		return nil
+	}
+
+	value := os.Getenv(s.env)
+	if file := os.Getenv(s.env + "_FILE"); file != "" {
+		if value != "" {
+			return fmt.Errorf("%s and %s_FILE can not both be set", s.env, s.env)
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("can not read %s_FILE: %w", s.env, err)
+		}
+		value = strings.TrimRight(string(content), "\r\n")
+	}
+
+	// Empty variables are treated as unset, as .env files tend to list
+	// every variable whether it is used or not.
+	if value == "" {
+		return nil
+	}
+
+	if err := s.set(value); err != nil {
+		return fmt.Errorf("%w (from %s)", err, s.env)
+	}
+
+	return nil
+}
+
+// set parses value into the setting. Lists are comma-separated.
+func (s *setting) set(value string) error {
+	v := s.value
+	switch {
+	case v.Type() == durationType:
+		if value == "" {
+			v.SetInt(0)
+			return nil
+		}
+		d, err := parseDuration(value, s.unit)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", s.key, err)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Int:
+		if value == "" {
+			v.SetInt(0)
+			return nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", s.key, err)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		if value == "" {
+			v.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", s.key, err)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(value)))
+	default:
+		panic(fmt.Sprintf("config: unsupported type %v of %s", v.Type(), s.key))
+	}
+
+	return nil
+}
+
+// ParseDuration parses a duration given as a Go duration or in whole days,
+// such as "30d".
+func ParseDuration(value string) (time.Duration, error) {
+	return parseDuration(value, 0)
+}
+
+// parseDuration accepts Go durations, whole days such as "30d", and bare
+// numbers counted in unit.
+func parseDuration(value string, unit time.Duration) (time.Duration, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		if unit == 0 {
+			return 0, fmt.Errorf("missing unit in duration %q", value)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+
+	return time.ParseDuration(value)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// loadFile reads a YAML or TOML file, chosen by its extension, into the
+// settings. Unknown keys are rejected so that typos do not go unnoticed.
+func loadFile(path string, settings []*setting) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can not read configuration file: %w", err)
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &tree)
+	case ".toml":
+		err = toml.Unmarshal(content, &tree)
+	default:
+		return fmt.Errorf("unsupported configuration file type %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("can not parse configuration file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	if err := flatten(tree, "", values); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	byKey := make(map[string]*setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	var errs []error
+	for key, value := range values {
+		s, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown setting %q", key))
+			continue
+		}
+		if err := s.set(value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// flatten turns the nested sections of a parsed file into values keyed by
+// dotted paths, in the same string form as environment variables.
+func flatten(tree map[string]any, prefix string, values map[string]string) error {
+	for key, value := range tree {
+		key = prefix + key
+		switch value := value.(type) {
+		case map[string]any:
+			if err := flatten(value, key+".", values); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				s, err := scalarString(key, item)
+				if err != nil {
+					return err
+				}
+				items = append(items, s)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			s, err := scalarString(key, value)
+			if err != nil {
+				return err
+			}
+			values[key] = s
+		}
+	}
+
+	return nil
+}
+
+func scalarString(key string, value any) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("unsupported value for %s", key)
+	}
+}
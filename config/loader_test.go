@@ -0,0 +1,227 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+)
+
+// setRequiredEnv sets the settings that have no usable default.
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("STORAGE", "memory")
+	t.Setenv("JWT_KEY", "secret")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAppliesDefaults(t *testing.T) {
+	setRequiredEnv(t)
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.App.Port != "8080" || cfg.Tokens.Expiration != 10*time.Hour || cfg.Usernames.Hold != 90*24*time.Hour {
+		t.Errorf("Load() = port %q, token expiration %v, username hold %v, want the defaults",
+			cfg.App.Port, cfg.Tokens.Expiration, cfg.Usernames.Hold)
+	}
+	if !cfg.Registration.BlockDisposableEmails {
+		t.Error("Load() did not default registration.block_disposable_emails to true")
+	}
+}
+
+func TestLoadReadsFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `
+storage: memory
+tokens:
+  jwt_key: secret
+  expiration: 90m
+registration:
+  reserved_usernames: [root, staff]
+  invite_only: true
+`,
+		},
+		{
+			name: "toml",
+			file: "config.toml",
+			content: `
+storage = "memory"
+
+[tokens]
+jwt_key = "secret"
+expiration = "90m"
+
+[registration]
+reserved_usernames = ["root", "staff"]
+invite_only = true
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Empty variables are ignored, so the file's values stand.
+			t.Setenv("STORAGE", "")
+			t.Setenv("JWT_KEY", "")
+
+			cfg, err := config.Load(writeFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if cfg.Tokens.JWTKey != "secret" || cfg.Tokens.Expiration != 90*time.Minute {
+				t.Errorf("Load() tokens = %+v, want the file's", cfg.Tokens)
+			}
+			if !reflect.DeepEqual(cfg.Registration.ReservedUsernames, []string{"root", "staff"}) || !cfg.Registration.InviteOnly {
+				t.Errorf("Load() registration = %+v, want the file's", cfg.Registration)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	setRequiredEnv(t)
+	path := writeFile(t, "config.yaml", "tokens:\n  jwt_kee: secret\n")
+
+	if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), `unknown setting "tokens.jwt_kee"`) {
+		t.Errorf("Load() error = %v, want the unknown setting", err)
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	path := writeFile(t, "config.yaml", "storage: memory\ntokens:\n  jwt_key: from-file\n")
+	t.Setenv("JWT_KEY", "from-env")
+	// Empty variables are ignored.
+	t.Setenv("STORAGE", "")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Tokens.JWTKey != "from-env" || cfg.Storage != "memory" {
+		t.Errorf("Load() jwt key = %q, storage = %q, want from-env and memory", cfg.Tokens.JWTKey, cfg.Storage)
+	}
+}
+
+func TestLoadReadsVariableFiles(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("JWT_KEY", "")
+	t.Setenv("JWT_KEY_FILE", writeFile(t, "jwt_key", "from-file\n"))
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Tokens.JWTKey != "from-file" {
+		t.Errorf("Load() jwt key = %q, want from-file", cfg.Tokens.JWTKey)
+	}
+
+	t.Setenv("JWT_KEY", "from-env")
+	if _, err := config.Load(""); err == nil || !strings.Contains(err.Error(), "JWT_KEY and JWT_KEY_FILE can not both be set") {
+		t.Errorf("Load() error = %v, want JWT_KEY and JWT_KEY_FILE to conflict", err)
+	}
+}
+
+func TestLoadParsesDurations(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "go duration", value: "90m", want: 90 * time.Minute},
+		{name: "days", value: "2d", want: 48 * time.Hour},
+		// JWT_EXPIRATION_MINUTES counts bare numbers in minutes.
+		{name: "bare number", value: "45", want: 45 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			t.Setenv("JWT_EXPIRATION_MINUTES", tt.value)
+
+			cfg, err := config.Load("")
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Tokens.Expiration != tt.want {
+				t.Errorf("Load() token expiration = %v, want %v", cfg.Tokens.Expiration, tt.want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		setRequiredEnv(t)
+		t.Setenv("JWT_EXPIRATION_MINUTES", "soon")
+
+		if _, err := config.Load(""); err == nil || !strings.Contains(err.Error(), "JWT_EXPIRATION_MINUTES") {
+			t.Errorf("Load() error = %v, want the invalid variable named", err)
+		}
+	})
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "30d", want: 30 * 24 * time.Hour},
+		{value: "30", wantErr: true},
+		{value: "d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := config.ParseDuration(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseDuration() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadValidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr string
+	}{
+		{name: "missing jwt key", env: map[string]string{"JWT_KEY": ""}, wantErr: "tokens.jwt_key is required"},
+		{name: "unknown storage", env: map[string]string{"STORAGE": "sqlite"}, wantErr: "storage must be postgres or memory"},
+		{name: "relative base url", env: map[string]string{"APP_BASE_URL": "example.com"}, wantErr: "app.base_url must be an absolute URL"},
+		{name: "zero batch size", env: map[string]string{"OUTBOX_RELAY_BATCH_SIZE": "0"}, wantErr: "events.relay_batch_size must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequiredEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := config.Load("")
+			if err == nil || !strings.Contains(err.Error(), "invalid configuration") || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
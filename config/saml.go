@@ -0,0 +1,71 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+type SAMLConfig struct {
+	SPBaseURL  string `key:"sp_base_url" env:"SAML_SP_BASE_URL"`
+	SPCertFile string `key:"sp_cert_file" env:"SAML_SP_CERT_FILE"`
+	SPKeyFile  string `key:"sp_key_file" env:"SAML_SP_KEY_FILE"`
+	// IdPConfigFile lists the identity providers as JSON. SAML is disabled
+	// when it is not set.
+	IdPConfigFile string `key:"idp_config_file" env:"SAML_IDP_CONFIG_FILE"`
+	// MetadataTimeout bounds fetching the metadata of the identity providers
+	// configured by URL.
+	MetadataTimeout time.Duration `key:"metadata_timeout" env:"SAML_METADATA_TIMEOUT_SECONDS" default:"30s" unit:"s"`
+}
+
+// SAMLIdentityProviderConfig describes one tenant's identity provider. Users
+// whose email belongs to one of EmailDomains are sent to this provider.
+type SAMLIdentityProviderConfig struct {
+	Name              string               `json:"name"`
+	EmailDomains      []string             `json:"email_domains"`
+	MetadataURL       string               `json:"metadata_url"`
+	MetadataFile      string               `json:"metadata_file"`
+	AllowIDPInitiated bool                 `json:"allow_idp_initiated"`
+	Attributes        SAMLAttributeMapping `json:"attributes"`
+}
+
+type SAMLAttributeMapping struct {
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func (c *SAMLConfig) Enabled() bool {
+	return c.IdPConfigFile != ""
+}
+
+func (c *SAMLConfig) LoadIdentityProviders() ([]SAMLIdentityProviderConfig, error) {
+	content, err := os.ReadFile(c.IdPConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var idps []SAMLIdentityProviderConfig
+	if err := json.Unmarshal(content, &idps); err != nil {
+		return nil, err
+	}
+
+	for i := range idps {
+		m := &idps[i].Attributes
+		if m.Username == "" {
+			m.Username = "uid"
+		}
+		if m.Email == "" {
+			m.Email = "email"
+		}
+		if m.FirstName == "" {
+			m.FirstName = "givenName"
+		}
+		if m.LastName == "" {
+			m.LastName = "sn"
+		}
+	}
+
+	return idps, nil
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/middlewares"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+type SuspendUserRequest struct {
+	Reason         string     `json:"reason" binding:"required"`
+	SuspendedUntil *time.Time `json:"suspended_until"`
+}
+
+type BanUserRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type ReinstateUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// AdminHandler serves the endpoints admins manage users with.
+type AdminHandler struct {
+	Store      models.Store
+	Config     *config.Config
+	Mailer     utils.Mailer
+	TokenCache models.TokenCache
+}
+
+func (h *AdminHandler) AdminSuspendUser(c *gin.Context) {
+	actorID, userID, ok := getAdminActionIDs(c)
+	if !ok {
+		return
+	}
+
+	var request SuspendUserRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	user := models.User{}
+	if err := user.SuspendUser(c.Request.Context(), h.Store, h.TokenCache, userID, actorID, request.Reason, request.SuspendedUntil); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getAdminUserResponseData(&user)})
+}
+
+func (h *AdminHandler) AdminBanUser(c *gin.Context) {
+	actorID, userID, ok := getAdminActionIDs(c)
+	if !ok {
+		return
+	}
+
+	var request BanUserRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	user := models.User{}
+	if err := user.BanUser(c.Request.Context(), h.Store, h.TokenCache, userID, actorID, request.Reason); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getAdminUserResponseData(&user)})
+}
+
+func (h *AdminHandler) AdminReinstateUser(c *gin.Context) {
+	actorID, userID, ok := getAdminActionIDs(c)
+	if !ok {
+		return
+	}
+
+	var request ReinstateUserRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	user := models.User{}
+	if err := user.ReinstateUser(c.Request.Context(), h.Store, userID, actorID, request.Reason); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getAdminUserResponseData(&user)})
+}
+
+func (h *AdminHandler) AdminGetUserStatusHistory(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	user := models.User{}
+	changes, e := user.GetStatusHistory(c.Request.Context(), h.Store, userID)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": changes})
+}
+
+// getAdminActionIDs returns the ID of the admin making the request and of the
+// user in the path, responding with an error if either can not be read.
+func getAdminActionIDs(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	actorID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return actorID, userID, true
+}
+
+func (h *AdminHandler) AdminImpersonateUser(c *gin.Context) {
+	actorID, userID, ok := getAdminActionIDs(c)
+	if !ok {
+		return
+	}
+
+	t := models.Token{}
+	token, err := t.CreateImpersonationToken(c.Request.Context(), h.Store, &h.Config.Tokens, userID, actorID)
+
+	// Unlike other events, impersonation is not allowed unless audited.
+	event := newAuditEvent(c, models.AuditImpersonationStarted, &actorID, &userID, err)
+	if e := models.RecordAuditEvent(c.Request.Context(), h.Store, event); e != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": e.Error()})
+		return
+	}
+
+	if err != nil {
+		c.JSON(err.Code, apiErrorResponse(err))
+		return
+	}
+
+	data := map[string]any{
+		"token":      token.Token,
+		"expires_at": token.ExpiresAt,
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+func (h *AdminHandler) AdminListUsers(c *gin.Context) {
+	filter := models.UserFilter{
+		Username: c.Query("username"),
+		Email:    c.Query("email"),
+		Status:   models.AccountStatus(c.Query("status")),
+	}
+
+	switch filter.Status {
+	case "", models.AccountPending, models.AccountActive, models.AccountSuspended, models.AccountBanned:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	if level := c.Query("level"); level != "" {
+		filter.Level = models.GetLevelFromName(level)
+		if filter.Level == models.UNKNOWN {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid level"})
+			return
+		}
+	}
+
+	for name, target := range map[string]**time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for " + name})
+			return
+		}
+		*target = &t
+	}
+
+	if value := c.Query("deleted"); value != "" {
+		deleted, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for deleted"})
+			return
+		}
+		filter.Deleted = &deleted
+	}
+
+	_, limit := getPagination(c)
+
+	u := models.User{}
+	users, nextCursor, e := u.ListUsers(c.Request.Context(), h.Store, filter, c.Query("cursor"), limit)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	data := make([]map[string]any, 0, len(users))
+	for i := range users {
+		data = append(data, getAdminUserResponseData(&users[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data, "next_cursor": nextCursor})
+}
+
+func (h *AdminHandler) AdminGetUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	u := models.User{}
+	user, profile, e := u.GetUserWithProfile(c.Request.Context(), h.Store, userID)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	data := getAdminUserResponseData(user)
+	data["profile"] = profile
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h *AdminHandler) AdminForceLogout(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	user := models.User{}
+	if err := user.ForceLogout(c.Request.Context(), h.Store, h.TokenCache, userID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "all tokens of the user revoked successfully"})
+}
+
+func (h *AdminHandler) AdminSendPasswordResetEmail(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	user := models.User{}
+	if err := user.SendPasswordResetForUser(c.Request.Context(), h.Store, h.Mailer, &h.Config.Tokens, userID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password reset email sent successfully"})
+}
+
+func (h *AdminHandler) AdminRestoreUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	user := models.User{}
+	if err := user.RestoreUser(c.Request.Context(), h.Store, userID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getAdminUserResponseData(&user)})
+}
+
+func getAdminUserResponseData(user *models.User) map[string]any {
+	var deletedAt *time.Time
+	if user.DeletedAt.Valid {
+		deletedAt = &user.DeletedAt.Time
+	}
+
+	return map[string]any{
+		"id":                 user.ID,
+		"username":           user.Username,
+		"email":              user.Email,
+		"level":              user.Level,
+		"status":             user.Status,
+		"status_reason":      user.StatusReason,
+		"suspended_until":    user.SuspendedUntil,
+		"auth_provider":      user.AuthProvider,
+		"is_admin":           user.IsAdmin,
+		"referred_by":        user.ReferredBy,
+		"scheduled_purge_at": user.ScheduledPurgeAt,
+		"created_at":         user.CreatedAt,
+		"updated_at":         user.UpdatedAt,
+		"deleted_at":         deletedAt,
+	}
+}
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/middlewares"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+type AuditHandler struct {
+	Store models.Store
+}
+
+func (h *AuditHandler) AdminListAuditEvents(c *gin.Context) {
+	filter := models.AuditEventFilter{
+		Action:  c.Query("action"),
+		Outcome: c.Query("outcome"),
+	}
+
+	for name, target := range map[string]**uuid.UUID{"actor_id": &filter.ActorID, "target_user_id": &filter.TargetUserID} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for " + name})
+			return
+		}
+		*target = &id
+	}
+
+	for name, target := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for " + name})
+			return
+		}
+		*target = &t
+	}
+
+	h.listAuditEvents(c, filter)
+}
+
+// GetSecurityActivity lists the audit events concerning the current user.
+func (h *AuditHandler) GetSecurityActivity(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.listAuditEvents(c, models.AuditEventFilter{TargetUserID: &userID, Action: c.Query("action")})
+}
+
+func (h *AuditHandler) listAuditEvents(c *gin.Context, filter models.AuditEventFilter) {
+	_, limit := getPagination(c)
+
+	e := models.AuditEvent{}
+	events, nextCursor, err := e.ListAuditEvents(c.Request.Context(), h.Store, filter, c.Query("cursor"), limit)
+	if err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events, "next_cursor": nextCursor})
+}
+
+// newAuditEvent describes an action taken in the request. The outcome is a
+// failure if err is not nil. While impersonating, the actor is the admin.
+func newAuditEvent(c *gin.Context, action string, actorID *uuid.UUID, targetUserID *uuid.UUID, err *errors.ApiError) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Action:       action,
+		Outcome:      models.AuditOutcomeSuccess,
+		ActorID:      actorID,
+		TargetUserID: targetUserID,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		RequestID:    middlewares.GetRequestID(c),
+		Details:      map[string]any{},
+	}
+
+	if impersonatorID := middlewares.GetImpersonatorIDFromRequest(c); impersonatorID != nil {
+		event.ActorID = impersonatorID
+		event.Details["impersonated"] = true
+	}
+
+	if err != nil {
+		event.Outcome = models.AuditOutcomeFailure
+		event.Details["error"] = err.Error()
+	}
+
+	return event
+}
+
+// recordAuditEvent writes the event to the audit log. A failure to do so
+// is logged but does not fail the request.
+func recordAuditEvent(ctx context.Context, store models.Store, event *models.AuditEvent) {
+	if err := models.RecordAuditEvent(ctx, store, event); err != nil {
+		log.Printf("failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// requestUserID returns the ID of the authenticated user, or nil if the
+// request is not authenticated.
+func requestUserID(c *gin.Context) *uuid.UUID {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		return nil
+	}
+
+	return &userID
+}
@@ -6,15 +6,19 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/metrics"
 	"github.com/vantutran2k1/social-network-auth/middlewares"
 	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/utils"
 	"github.com/vantutran2k1/social-network-auth/validators"
 )
 
 type UserRegistrationRequest struct {
-	Username string `json:"username" binding:"required"`
-	Password string `json:"password" binding:"required,min=8,max=32"`
-	Email    string `json:"email" binding:"required,email"`
+	Username   string `json:"username" binding:"required"`
+	Password   string `json:"password" binding:"required,min=8,max=32"`
+	Email      string `json:"email" binding:"required,email"`
+	InviteCode string `json:"invite_code"`
 }
 
 type UserAuthenticationRequest struct {
@@ -47,42 +51,86 @@ type SendResetPasswordEmailRequest struct {
 	ResetToken string `json:"reset_token" binding:"required"`
 }
 
-func Register(c *gin.Context) {
+type UpdateEmailRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewEmail        string `json:"new_email" binding:"required,email"`
+}
+
+type UpdateUsernameRequest struct {
+	NewUsername string `json:"new_username" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type RestoreAccountRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// AuthHandler serves the authentication and account endpoints.
+type AuthHandler struct {
+	Store              models.Store
+	Config             *config.Config
+	Mailer             utils.Mailer
+	Authenticator      models.Authenticator
+	RegistrationPolicy *validators.RegistrationPolicy
+	TokenCache         models.TokenCache
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
 	var creds UserRegistrationRequest
 	if errs := validators.BindAndValidate(c, &creds); len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
 		return
 	}
 
+	if errs := h.RegistrationPolicy.ValidateRegistration(creds.Username, creds.Email, creds.InviteCode); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
 	user := models.User{}
-	if err := user.Register(config.DB, creds.Username, creds.Password, creds.Email); err != nil {
+	if err := user.Register(c.Request.Context(), h.Store, creds.Username, creds.Password, creds.Email, creds.InviteCode); err != nil {
+		event := newAuditEvent(c, models.AuditUserRegistered, nil, nil, err)
+		event.Details["username"] = creds.Username
+		recordAuditEvent(c.Request.Context(), h.Store, event)
+
 		c.JSON(err.Code, gin.H{"error": err.Error()})
 		return
 	}
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditUserRegistered, &user.ID, &user.ID, nil))
 
 	data := map[string]any{
-		"username": user.Username,
-		"email":    user.Email,
+		"username":    user.Username,
+		"email":       user.Email,
+		"referred_by": user.ReferredBy,
 	}
 	c.JSON(http.StatusCreated, gin.H{"data": data})
 }
 
-func Login(c *gin.Context) {
+func (h *AuthHandler) Login(c *gin.Context) {
 	var auth UserAuthenticationRequest
 	if errs := validators.BindAndValidate(c, &auth); len(errs) > 0 {
+		metrics.LoginsFailed.WithLabelValues("invalid_request").Inc()
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
 		return
 	}
 
 	user := models.User{}
-	loginUser, err := user.Authenticate(config.DB, auth.Username, auth.Password)
+	loginUser, err := user.Authenticate(c.Request.Context(), h.Store, h.Authenticator, auth.Username, auth.Password)
 	if err != nil {
-		c.JSON(err.Code, gin.H{"error": err.Error()})
+		metrics.LoginsFailed.WithLabelValues(loginFailureReason(err)).Inc()
+		h.recordFailedLogin(c, auth.Username, err)
+		c.JSON(err.Code, apiErrorResponse(err))
 		return
 	}
+	metrics.LoginsSucceeded.Inc()
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditLogin, &loginUser.ID, &loginUser.ID, nil))
 
 	t := models.Token{}
-	token, err := t.CreateLoginToken(config.DB, loginUser.ID)
+	token, err := t.CreateLoginToken(c.Request.Context(), h.Store, &h.Config.Tokens, loginUser.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -91,9 +139,13 @@ func Login(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": token.Token})
 }
 
-func Logout(c *gin.Context) {
+func (h *AuthHandler) Logout(c *gin.Context) {
+	userID := requestUserID(c)
+
 	var token models.Token
-	if err := token.Revoke(config.DB, middlewares.GetAuthTokenFromRequest(c)); err != nil {
+	err := token.Revoke(c.Request.Context(), h.Store, h.TokenCache, middlewares.GetAuthTokenFromRequest(c))
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditLogout, userID, userID, err))
+	if err != nil {
 		c.JSON(err.Code, gin.H{"error": err.Error()})
 		return
 	}
@@ -101,7 +153,7 @@ func Logout(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "token revoked successfully"})
 }
 
-func UpdatePassword(c *gin.Context) {
+func (h *AuthHandler) UpdatePassword(c *gin.Context) {
 	userID, err := middlewares.GetUserIDFromRequest(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -115,15 +167,17 @@ func UpdatePassword(c *gin.Context) {
 	}
 
 	u := models.User{ID: userID}
-	if err := u.UpdatePassword(config.DB, request.CurrentPassword, request.NewPassword); err != nil {
-		c.JSON(err.Code, gin.H{"error": err.Error()})
+	e := u.UpdatePassword(c.Request.Context(), h.Store, h.TokenCache, request.CurrentPassword, request.NewPassword)
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditPasswordUpdated, &userID, &userID, e))
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "password updated successfully"})
 }
 
-func UpdateUserLevel(c *gin.Context) {
+func (h *AuthHandler) UpdateUserLevel(c *gin.Context) {
 	var request UpdateLevelRequest
 	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
@@ -131,7 +185,11 @@ func UpdateUserLevel(c *gin.Context) {
 	}
 
 	user := models.User{}
-	if err := user.UpdateLevel(config.DB, request.UserId, request.LevelName); err != nil {
+	err := user.UpdateLevel(c.Request.Context(), h.Store, request.UserId, request.LevelName)
+	event := newAuditEvent(c, models.AuditLevelUpdated, requestUserID(c), &request.UserId, err)
+	event.Details["level"] = request.LevelName
+	recordAuditEvent(c.Request.Context(), h.Store, event)
+	if err != nil {
 		c.JSON(err.Code, gin.H{"error": err.Error()})
 		return
 	}
@@ -143,7 +201,7 @@ func UpdateUserLevel(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func CreateResetPasswordToken(c *gin.Context) {
+func (h *AuthHandler) CreateResetPasswordToken(c *gin.Context) {
 	var request CreateResetPasswordTokenRequest
 	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
@@ -151,14 +209,14 @@ func CreateResetPasswordToken(c *gin.Context) {
 	}
 
 	u := models.User{}
-	user, err := u.GetUserByUsernameOrEmail(config.DB, request.UserIdentity)
+	user, err := u.GetUserByUsernameOrEmail(c.Request.Context(), h.Store, request.UserIdentity)
 	if err != nil {
 		c.JSON(err.Code, gin.H{"error": err.Error()})
 		return
 	}
 
 	t := models.PasswordResetToken{}
-	token, err := t.CreateResetToken(config.DB, user.ID)
+	token, err := t.CreateResetToken(c.Request.Context(), h.Store, &h.Config.Tokens, user.ID)
 	if err != nil {
 		c.JSON(err.Code, gin.H{"error": err.Error()})
 		return
@@ -171,7 +229,7 @@ func CreateResetPasswordToken(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func ResetPassword(c *gin.Context) {
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
 	resetToken := c.Query("reset_token")
 	if resetToken == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "reset_token is required"})
@@ -185,7 +243,13 @@ func ResetPassword(c *gin.Context) {
 	}
 
 	var u models.User
-	if err := u.ResetPassword(config.DB, request.Email, resetToken, request.NewPassword, request.ConfirmPassword); err != nil {
+	err := u.ResetPassword(c.Request.Context(), h.Store, h.TokenCache, request.Email, resetToken, request.NewPassword, request.ConfirmPassword)
+	var targetUserID *uuid.UUID
+	if u.ID != uuid.Nil {
+		targetUserID = &u.ID
+	}
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditPasswordReset, nil, targetUserID, err))
+	if err != nil {
 		c.JSON(err.Code, gin.H{"error": err.Error()})
 		return
 	}
@@ -193,7 +257,7 @@ func ResetPassword(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": "password updated successfully"})
 }
 
-func SendResetPasswordEmail(c *gin.Context) {
+func (h *AuthHandler) SendResetPasswordEmail(c *gin.Context) {
 	var request SendResetPasswordEmailRequest
 	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
@@ -201,10 +265,204 @@ func SendResetPasswordEmail(c *gin.Context) {
 	}
 
 	user := models.User{}
-	if err := user.SendResetPasswordEmail(config.DB, request.Email, request.ResetToken); err != nil {
+	if err := user.SendResetPasswordEmail(c.Request.Context(), h.Mailer, request.Email, request.ResetToken); err != nil {
 		c.JSON(err.Code, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": "email sent successfully"})
 }
+
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request DeleteAccountRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	user := models.User{}
+	if err := user.DeleteAccount(c.Request.Context(), h.Store, h.Mailer, h.TokenCache, &h.Config.Accounts, userID, request.Password); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := map[string]any{
+		"scheduled_purge_at": user.ScheduledPurgeAt,
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h *AuthHandler) RestoreAccount(c *gin.Context) {
+	var request RestoreAccountRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	user := models.User{}
+	if err := user.RestoreAccount(c.Request.Context(), h.Store, request.Username, request.Password); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := map[string]any{
+		"username": user.Username,
+		"email":    user.Email,
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h *AuthHandler) UpdateEmail(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request UpdateEmailRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	if errs := h.RegistrationPolicy.ValidateEmail("new_email", request.NewEmail); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	r := models.EmailChangeRequest{}
+	tokenString := middlewares.GetAuthTokenFromRequest(c)
+	if err := r.RequestEmailChange(c.Request.Context(), h.Store, h.Mailer, h.Config, userID, tokenString, request.CurrentPassword, request.NewEmail); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := map[string]any{
+		"new_email":    r.NewEmail,
+		"token_expiry": r.TokenExpiry,
+	}
+	c.JSON(http.StatusAccepted, gin.H{"data": data})
+}
+
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	r := models.EmailChangeRequest{}
+	if err := r.ConfirmEmailChange(c.Request.Context(), h.Store, h.TokenCache, h.RegistrationPolicy, token); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": map[string]any{"email": r.NewEmail}})
+}
+
+func (h *AuthHandler) CancelEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	r := models.EmailChangeRequest{}
+	if err := r.CancelEmailChange(c.Request.Context(), h.Store, token); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email change cancelled successfully"})
+}
+
+func (h *AuthHandler) UpdateUsername(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request UpdateUsernameRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	if errs := h.RegistrationPolicy.ValidateUsername("new_username", request.NewUsername); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	user := models.User{}
+	if err := user.ChangeUsername(c.Request.Context(), h.Store, &h.Config.Usernames, userID, request.NewUsername); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": map[string]any{"username": user.Username}})
+}
+
+func (h *AuthHandler) ResolveUsername(c *gin.Context) {
+	username := c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return
+	}
+
+	u := models.User{}
+	user, err := u.ResolveUsername(c.Request.Context(), h.Store, username)
+	if err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := map[string]any{
+		"user_id":  user.ID,
+		"username": user.Username,
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// recordFailedLogin audits a failed login against the account it was meant
+// for, if there is one, so that its owner can see it.
+func (h *AuthHandler) recordFailedLogin(c *gin.Context, username string, err *errors.ApiError) {
+	var targetUserID *uuid.UUID
+	u := models.User{}
+	if user, e := u.GetUserByUsernameOrEmail(c.Request.Context(), h.Store, username); e == nil {
+		targetUserID = &user.ID
+	}
+
+	event := newAuditEvent(c, models.AuditLogin, nil, targetUserID, err)
+	event.Details["username"] = username
+	recordAuditEvent(c.Request.Context(), h.Store, event)
+}
+
+// loginFailureReason classifies a failed login for metrics. Unknown users
+// and wrong passwords are counted together as invalid credentials.
+func loginFailureReason(err *errors.ApiError) string {
+	switch {
+	case err.ErrorCode != "":
+		return err.ErrorCode
+	case err.Code >= http.StatusInternalServerError:
+		return "internal_error"
+	default:
+		return "invalid_credentials"
+	}
+}
+
+// apiErrorResponse also includes the machine-readable code of errors that
+// have one, so that clients can tell e.g. suspended accounts apart.
+func apiErrorResponse(err *errors.ApiError) gin.H {
+	if err.ErrorCode == "" {
+		return gin.H{"error": err.Error()}
+	}
+
+	return gin.H{"error": err.Error(), "code": err.ErrorCode}
+}
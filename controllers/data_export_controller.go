@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/middlewares"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+type RequestDataExportRequest struct {
+	Format string `json:"format" binding:"omitempty,oneof=json zip"`
+}
+
+type DataExportHandler struct {
+	Store  models.Store
+	Config *config.Config
+}
+
+func (h *DataExportHandler) RequestDataExport(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request RequestDataExportRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+	if request.Format == "" {
+		request.Format = models.DataExportJSON
+	}
+
+	export := models.DataExport{}
+	if err := export.RequestExport(c.Request.Context(), h.Store, userID, request.Format); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": getDataExportResponseData(c, &export)})
+}
+
+func (h *DataExportHandler) GetDataExport(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	exportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	export := models.DataExport{}
+	if err := export.GetExport(c.Request.Context(), h.Store, userID, exportID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getDataExportResponseData(c, &export)})
+}
+
+func (h *DataExportHandler) DownloadDataExport(c *gin.Context) {
+	exportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	export := models.DataExport{}
+	if err := export.GetDownload(c.Request.Context(), h.Store, exportID, token); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, export.FileName()))
+	c.Data(http.StatusOK, export.ContentType(), export.Content)
+}
+
+func getDataExportResponseData(c *gin.Context, export *models.DataExport) map[string]any {
+	data := map[string]any{
+		"id":         export.ID,
+		"format":     export.Format,
+		"status":     export.Status,
+		"created_at": export.CreatedAt,
+	}
+
+	if export.Status == models.DataExportCompleted && export.DownloadToken != nil {
+		data["completed_at"] = export.CompletedAt
+		data["expires_at"] = export.ExpiresAt
+		data["download_url"] = fmt.Sprintf("%s/api/auth/data-export/%s/download?token=%s", requestBaseURL(c), export.ID, *export.DownloadToken)
+	}
+	if export.Error != nil {
+		data["error"] = *export.Error
+	}
+
+	return data
+}
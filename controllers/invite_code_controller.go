@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/middlewares"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type InviteCodeHandler struct {
+	Store  models.Store
+	Config *config.Config
+}
+
+func (h *InviteCodeHandler) CreateInviteCode(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := models.InviteCode{}
+	if err := code.CreateInviteCode(c.Request.Context(), h.Store, &h.Config.InviteCodes, userID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": code})
+}
+
+func (h *InviteCodeHandler) GetInviteCodes(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	code := models.InviteCode{}
+	codes, e := code.GetUserInviteCodes(c.Request.Context(), h.Store, userID)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": codes})
+}
+
+// RevokeInviteCode revokes one of the user's own codes, or any code when
+// called through the admin routes.
+func (h *InviteCodeHandler) RevokeInviteCode(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	codeID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	code := models.InviteCode{}
+	if err := code.RevokeInviteCode(c.Request.Context(), h.Store, codeID, userID, middlewares.IsAdminRequest(c)); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invite code revoked successfully"})
+}
+
+func (h *InviteCodeHandler) AdminListInviteCodes(c *gin.Context) {
+	offset, limit := getPagination(c)
+
+	var createdBy *uuid.UUID
+	if value := c.Query("created_by"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for created_by"})
+			return
+		}
+		createdBy = &id
+	}
+
+	code := models.InviteCode{}
+	codes, total, e := code.ListInviteCodes(c.Request.Context(), h.Store, createdBy, offset, limit)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": codes, "total": total, "offset": offset, "limit": limit})
+}
+
+func (h *InviteCodeHandler) AdminGetInviteCodeUses(c *gin.Context) {
+	codeID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	code := models.InviteCode{}
+	uses, users, e := code.GetInviteCodeUses(c.Request.Context(), h.Store, codeID)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	data := make([]map[string]any, 0, len(uses))
+	for _, use := range uses {
+		user := users[use.UserID]
+		data = append(data, map[string]any{
+			"user_id":  use.UserID,
+			"username": user.Username,
+			"email":    user.Email,
+			"used_at":  use.UsedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"invite_code": code, "uses": data}})
+}
+
+func getPagination(c *gin.Context) (int, int) {
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+
+	return offset, limit
+}
@@ -6,7 +6,6 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/vantutran2k1/social-network-auth/config"
 	"github.com/vantutran2k1/social-network-auth/middlewares"
 	"github.com/vantutran2k1/social-network-auth/models"
 	"github.com/vantutran2k1/social-network-auth/validators"
@@ -38,7 +37,11 @@ type ProfileResponse struct {
 	Phone       string `json:"phone,omitempty"`
 }
 
-func CreateProfile(c *gin.Context) {
+type ProfileHandler struct {
+	Store models.Store
+}
+
+func (h *ProfileHandler) CreateProfile(c *gin.Context) {
 	var request CreateProfileRequest
 	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
@@ -53,7 +56,8 @@ func CreateProfile(c *gin.Context) {
 
 	p := models.Profile{}
 	profile, e := p.CreateProfile(
-		config.DB,
+		c.Request.Context(),
+		h.Store,
 		userID,
 		request.FirstName,
 		request.LastName,
@@ -61,6 +65,7 @@ func CreateProfile(c *gin.Context) {
 		request.Address,
 		request.Phone,
 	)
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditProfileCreated, &userID, &userID, e))
 	if e != nil {
 		c.JSON(e.Code, gin.H{"error": e.Error()})
 		return
@@ -76,7 +81,7 @@ func CreateProfile(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"data": getProfileResponseData(r)})
 }
 
-func GetProfile(c *gin.Context) {
+func (h *ProfileHandler) GetProfile(c *gin.Context) {
 	userIDStr := c.Query("user_id")
 	if userIDStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id is required"})
@@ -90,7 +95,7 @@ func GetProfile(c *gin.Context) {
 	}
 
 	p := models.Profile{}
-	profile, e := p.GetProfileByUser(config.DB, userID)
+	profile, e := p.GetProfileByUser(c.Request.Context(), h.Store, userID)
 	if e != nil {
 		c.JSON(e.Code, gin.H{"error": e.Error()})
 		return
@@ -106,7 +111,7 @@ func GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": getProfileResponseData(r)})
 }
 
-func GetCurrentProfile(c *gin.Context) {
+func (h *ProfileHandler) GetCurrentProfile(c *gin.Context) {
 	userID, err := middlewares.GetUserIDFromRequest(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -114,7 +119,7 @@ func GetCurrentProfile(c *gin.Context) {
 	}
 
 	p := models.Profile{}
-	profile, e := p.GetProfileByUser(config.DB, userID)
+	profile, e := p.GetProfileByUser(c.Request.Context(), h.Store, userID)
 	if e != nil {
 		c.JSON(e.Code, gin.H{"error": e.Error()})
 		return
@@ -130,7 +135,7 @@ func GetCurrentProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": getProfileResponseData(r)})
 }
 
-func UpdateCurrentProfile(c *gin.Context) {
+func (h *ProfileHandler) UpdateCurrentProfile(c *gin.Context) {
 	var request UpdateProfileRequest
 	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
@@ -144,8 +149,10 @@ func UpdateCurrentProfile(c *gin.Context) {
 	}
 
 	p := models.Profile{}
-	if err := p.UpdateProfileByUser(config.DB, userID, request.FirstName, request.LastName, request.DateOfBirth, request.Address, request.Phone); err != nil {
-		c.JSON(err.Code, gin.H{"error": err.Error()})
+	e := p.UpdateProfileByUser(c.Request.Context(), h.Store, userID, request.FirstName, request.LastName, request.DateOfBirth, request.Address, request.Phone)
+	recordAuditEvent(c.Request.Context(), h.Store, newAuditEvent(c, models.AuditProfileUpdated, &userID, &userID, e))
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
 		return
 	}
 
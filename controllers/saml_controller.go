@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/crewjam/saml"
+	"github.com/gin-gonic/gin"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/sso"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+const samlRequestIDCookie = "saml_request_id"
+
+// SAMLHandler serves the single sign-on endpoints of the identity providers
+// in IdentityProviders.
+type SAMLHandler struct {
+	Store              models.Store
+	Config             *config.Config
+	IdentityProviders  *sso.Registry
+	RegistrationPolicy *validators.RegistrationPolicy
+}
+
+func (h *SAMLHandler) SAMLMetadata(c *gin.Context) {
+	idp := h.IdentityProviders.GetIdentityProvider(c.Param("idp"))
+	if idp == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity provider not found"})
+		return
+	}
+
+	metadata, err := xml.MarshalIndent(idp.ServiceProvider.Metadata(), "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+func (h *SAMLHandler) SAMLLogin(c *gin.Context) {
+	var idp *sso.IdentityProvider
+	if email := c.Query("email"); email != "" {
+		idp = h.IdentityProviders.GetIdentityProviderByEmail(email)
+	} else {
+		idp = h.IdentityProviders.GetIdentityProvider(c.Query("idp"))
+	}
+	if idp == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity provider not found"})
+		return
+	}
+
+	sp := idp.ServiceProvider
+	request, err := sp.MakeAuthenticationRequest(
+		sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	redirectURL, err := request.Redirect(c.Query("relay_state"), sp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(samlRequestIDCookie, request.ID, int(saml.MaxIssueDelay.Seconds()), sp.AcsURL.Path, "", true, true)
+	c.Redirect(http.StatusFound, redirectURL.String())
+}
+
+func (h *SAMLHandler) SAMLAssertionConsumer(c *gin.Context) {
+	idp := h.IdentityProviders.GetIdentityProvider(c.Param("idp"))
+	if idp == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "identity provider not found"})
+		return
+	}
+
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var possibleRequestIDs []string
+	if requestID, err := c.Cookie(samlRequestIDCookie); err == nil {
+		possibleRequestIDs = append(possibleRequestIDs, requestID)
+	}
+
+	assertion, err := idp.ServiceProvider.ParseResponse(c.Request, possibleRequestIDs)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid SAML response"})
+		return
+	}
+
+	identity, err := idp.GetIdentity(assertion)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if e := idp.Assertion(assertion).Consume(c.Request.Context(), h.Store); e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	u := models.User{}
+	user, e := u.ProvisionExternalUser(c.Request.Context(), h.Store, h.RegistrationPolicy, *identity)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	if e := user.CheckStatus(c.Request.Context(), h.Store); e != nil {
+		c.JSON(e.Code, apiErrorResponse(e))
+		return
+	}
+
+	t := models.Token{}
+	token, e := t.CreateLoginToken(c.Request.Context(), h.Store, &h.Config.Tokens, user.ID)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.SetCookie(samlRequestIDCookie, "", -1, idp.ServiceProvider.AcsURL.Path, "", true, true)
+	c.JSON(http.StatusOK, gin.H{"data": token.Token})
+}
@@ -0,0 +1,230 @@
+package controllers_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/controllers"
+	"github.com/vantutran2k1/social-network-auth/sso"
+	"github.com/vantutran2k1/social-network-auth/storage"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+const testSPBaseURL = "https://sp.example.com"
+
+// testIdP is a SAML identity provider serving its metadata over HTTP and
+// signing assertions with a self-signed certificate.
+type testIdP struct {
+	*saml.IdentityProvider
+	metadataURL string
+}
+
+func startTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	key, cert := newTestKeyPair(t, "idp.example.com")
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	metadataURL, _ := url.Parse(server.URL + "/metadata")
+	ssoURL, _ := url.Parse(server.URL + "/sso")
+	idp := &saml.IdentityProvider{
+		Key:         key,
+		Certificate: cert,
+		Logger:      logger.DefaultLogger,
+		MetadataURL: *metadataURL,
+		SSOURL:      *ssoURL,
+	}
+	mux.HandleFunc("/metadata", idp.ServeMetadata)
+
+	return &testIdP{IdentityProvider: idp, metadataURL: metadataURL.String()}
+}
+
+// respond makes an IdP-initiated response asserting alice to sp, and returns
+// the form the browser would post to the assertion consumer service.
+func (idp *testIdP) respond(t *testing.T, sp *saml.ServiceProvider) url.Values {
+	t.Helper()
+
+	req := &saml.IdpAuthnRequest{
+		IDP:                     idp.IdentityProvider,
+		HTTPRequest:             httptest.NewRequest(http.MethodGet, "/sso", nil),
+		ServiceProviderMetadata: sp.Metadata(),
+		Now:                     saml.TimeNow(),
+	}
+	req.SPSSODescriptor = &req.ServiceProviderMetadata.SPSSODescriptors[0]
+	for _, endpoint := range req.SPSSODescriptor.AssertionConsumerServices {
+		if endpoint.Binding == saml.HTTPPostBinding {
+			req.ACSEndpoint = &endpoint
+			break
+		}
+	}
+
+	session := &saml.Session{
+		ID:            "session",
+		CreateTime:    saml.TimeNow(),
+		ExpireTime:    saml.TimeNow().Add(time.Hour),
+		NameID:        "alice@example.com",
+		UserName:      "alice",
+		UserEmail:     "alice@example.com",
+		UserGivenName: "Alice",
+		UserSurname:   "Smith",
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url.Values{"SAMLResponse": {form.SAMLResponse}}
+}
+
+func newTestKeyPair(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key, cert
+}
+
+// newTestSAMLConfig writes the service provider's key pair and the identity
+// provider list to the test's directory.
+func newTestSAMLConfig(t *testing.T, metadataURL string, timeout time.Duration) *config.SAMLConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	key, cert := newTestKeyPair(t, "sp.example.com")
+	writeFile(t, filepath.Join(dir, "sp.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	writeFile(t, filepath.Join(dir, "sp.key"), pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+
+	idps, err := json.Marshal([]config.SAMLIdentityProviderConfig{{
+		Name:              "acme",
+		EmailDomains:      []string{"example.com"},
+		MetadataURL:       metadataURL,
+		AllowIDPInitiated: true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "idps.json"), idps)
+
+	return &config.SAMLConfig{
+		SPBaseURL:       testSPBaseURL,
+		SPCertFile:      filepath.Join(dir, "sp.crt"),
+		SPKeyFile:       filepath.Join(dir, "sp.key"),
+		IdPConfigFile:   filepath.Join(dir, "idps.json"),
+		MetadataTimeout: timeout,
+	}
+}
+
+func writeFile(t *testing.T, name string, content []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(name, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSAMLAssertionConsumerRejectsReplayedAssertions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	idp := startTestIdP(t)
+	registry, err := sso.NewRegistry(newTestSAMLConfig(t, idp.metadataURL, 5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := validators.NewRegistrationPolicy(&config.RegistrationConfig{
+		UsernamePattern:   "^[a-zA-Z0-9_.@-]+$",
+		UsernameMinLength: 3,
+		UsernameMaxLength: 64,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &controllers.SAMLHandler{
+		Store:              storage.NewMemoryStore(),
+		Config:             &config.Config{Tokens: config.TokenConfig{JWTKey: "test", Expiration: time.Hour}},
+		IdentityProviders:  registry,
+		RegistrationPolicy: policy,
+	}
+	router := gin.New()
+	router.POST("/api/auth/saml/:idp/acs", h.SAMLAssertionConsumer)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, testSPBaseURL+"/api/auth/saml/acme/acs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	sp := registry.GetIdentityProvider("acme").ServiceProvider
+	form := idp.respond(t, sp)
+
+	if w := post(form); w.Code != http.StatusOK {
+		t.Fatalf("first sign-in status = %d, body = %s", w.Code, w.Body)
+	}
+	if w := post(form); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed sign-in status = %d, want %d, body = %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+	if w := post(idp.respond(t, sp)); w.Code != http.StatusOK {
+		t.Errorf("sign-in with a new assertion status = %d, body = %s", w.Code, w.Body)
+	}
+}
+
+func TestNewRegistryTimesOutFetchingMetadata(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+
+	start := time.Now()
+	if _, err := sso.NewRegistry(newTestSAMLConfig(t, server.URL, 200*time.Millisecond)); err == nil {
+		t.Error("NewRegistry() error = nil, want a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("NewRegistry() returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/scim"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 1000
+)
+
+type SCIMHandler struct {
+	Store              models.Store
+	TokenCache         models.TokenCache
+	RegistrationPolicy *validators.RegistrationPolicy
+}
+
+func (h *SCIMHandler) SCIMListUsers(c *gin.Context) {
+	startIndex, err := strconv.Atoi(c.DefaultQuery("startIndex", "1"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(scimDefaultCount)))
+	if err != nil || count < 0 {
+		count = scimDefaultCount
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+
+	var filter scim.Filter
+	if expression := c.Query("filter"); expression != "" {
+		filter, err = scim.ParseFilter(expression, models.SCIMUserAttributes)
+		if err != nil {
+			scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+			return
+		}
+	}
+
+	u := models.User{}
+	users, profiles, total, e := u.ListUsersWithProfiles(c.Request.Context(), h.Store, filter, startIndex-1, count)
+	if e != nil {
+		scimError(c, e.Code, "", e.Error())
+		return
+	}
+
+	resources := make([]scim.User, 0, len(users))
+	for i := range users {
+		resources = append(resources, toSCIMUser(c, &users[i], profiles[users[i].ID]))
+	}
+
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *SCIMHandler) SCIMGetUser(c *gin.Context) {
+	userID, ok := parseSCIMUserID(c)
+	if !ok {
+		return
+	}
+
+	u := models.User{}
+	user, profile, err := u.GetProvisionedUserWithProfile(c.Request.Context(), h.Store, userID)
+	if err != nil {
+		scimError(c, err.Code, "", err.Error())
+		return
+	}
+
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(http.StatusOK, toSCIMUser(c, user, profile))
+}
+
+func (h *SCIMHandler) SCIMCreateUser(c *gin.Context) {
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	request, e := toProvisioningRequest(&resource)
+	if e != nil {
+		scimError(c, e.Code, "invalidValue", e.Error())
+		return
+	}
+
+	user := models.User{}
+	profile, e := user.CreateProvisionedUser(c.Request.Context(), h.Store, h.RegistrationPolicy, request)
+	if e != nil {
+		scimError(c, e.Code, scimTypeFor(e), e.Error())
+		return
+	}
+
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(http.StatusCreated, toSCIMUser(c, &user, profile))
+}
+
+func (h *SCIMHandler) SCIMReplaceUser(c *gin.Context) {
+	userID, ok := parseSCIMUserID(c)
+	if !ok {
+		return
+	}
+
+	var resource scim.User
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	h.updateSCIMUser(c, userID, &resource)
+}
+
+func (h *SCIMHandler) SCIMPatchUser(c *gin.Context) {
+	userID, ok := parseSCIMUserID(c)
+	if !ok {
+		return
+	}
+
+	var patch scim.PatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	u := models.User{}
+	user, profile, e := u.GetProvisionedUserWithProfile(c.Request.Context(), h.Store, userID)
+	if e != nil {
+		scimError(c, e.Code, "", e.Error())
+		return
+	}
+
+	resource := toSCIMUser(c, user, profile)
+	if err := patch.Apply(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidPath", err.Error())
+		return
+	}
+
+	h.updateSCIMUser(c, userID, &resource)
+}
+
+func (h *SCIMHandler) SCIMDeleteUser(c *gin.Context) {
+	userID, ok := parseSCIMUserID(c)
+	if !ok {
+		return
+	}
+
+	u := models.User{}
+	if err := u.DeleteProvisionedUser(c.Request.Context(), h.Store, userID); err != nil {
+		scimError(c, err.Code, "", err.Error())
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) updateSCIMUser(c *gin.Context, userID uuid.UUID, resource *scim.User) {
+	request, e := toProvisioningRequest(resource)
+	if e != nil {
+		scimError(c, e.Code, "invalidValue", e.Error())
+		return
+	}
+
+	user := models.User{}
+	profile, e := user.UpdateProvisionedUser(c.Request.Context(), h.Store, h.TokenCache, h.RegistrationPolicy, userID, request)
+	if e != nil {
+		scimError(c, e.Code, scimTypeFor(e), e.Error())
+		return
+	}
+
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(http.StatusOK, toSCIMUser(c, &user, profile))
+}
+
+func toProvisioningRequest(resource *scim.User) (models.ProvisioningRequest, *errors.ApiError) {
+	request := models.ProvisioningRequest{
+		Username: resource.UserName,
+		Email:    resource.PrimaryEmail(),
+		Password: resource.Password,
+		Phone:    resource.PrimaryPhoneNumber(),
+		Address:  resource.PrimaryAddress(),
+		Active:   resource.IsActive(),
+	}
+	if resource.ExternalID != "" {
+		externalID := resource.ExternalID
+		request.ExternalID = &externalID
+	}
+	if resource.Name != nil {
+		request.FirstName = resource.Name.GivenName
+		request.LastName = resource.Name.FamilyName
+	}
+
+	if request.Username == "" {
+		return request, errors.BadRequestError("userName is required")
+	}
+	if request.Email == "" {
+		return request, errors.BadRequestError("an email is required")
+	}
+	if request.Password != "" && (len(request.Password) < 8 || len(request.Password) > 32) {
+		return request, errors.BadRequestError("password must be between 8 and 32 characters")
+	}
+
+	return request, nil
+}
+
+func toSCIMUser(c *gin.Context, user *models.User, profile *models.Profile) scim.User {
+	active := !user.DeletedAt.Valid
+	resource := scim.User{
+		Schemas:  []string{scim.UserSchema},
+		ID:       user.ID.String(),
+		UserName: user.Username,
+		Emails:   []scim.MultiValued{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     fmt.Sprintf("%s/scim/v2/Users/%s", requestBaseURL(c), user.ID),
+		},
+	}
+	if user.ExternalID != nil {
+		resource.ExternalID = *user.ExternalID
+	}
+
+	if profile != nil {
+		resource.Name = &scim.Name{
+			GivenName:  profile.FirstName,
+			FamilyName: profile.LastName,
+			Formatted:  profile.FirstName + " " + profile.LastName,
+		}
+		if profile.Phone != "" {
+			resource.PhoneNumbers = []scim.MultiValued{{Value: profile.Phone, Type: "work", Primary: true}}
+		}
+		if profile.Address != "" {
+			resource.Addresses = []scim.Address{{Formatted: profile.Address, Type: "work", Primary: true}}
+		}
+	}
+
+	return resource
+}
+
+func parseSCIMUserID(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", fmt.Sprintf("user %s not found", c.Param("id")))
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+func scimTypeFor(err *errors.ApiError) string {
+	if err.Code == http.StatusConflict {
+		return "uniqueness"
+	}
+
+	return ""
+}
+
+func scimError(c *gin.Context, status int, scimType string, detail string) {
+	c.Header("Content-Type", scim.ContentType)
+	c.JSON(status, scim.NewError(status, scimType, detail))
+}
+
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + c.Request.Host
+}
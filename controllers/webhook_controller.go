@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/middlewares"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+type CreateWebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Secret      string   `json:"secret" binding:"omitempty,min=16"`
+	EventTypes  []string `json:"event_types"`
+	Description string   `json:"description" binding:"max=255"`
+}
+
+type UpdateWebhookEndpointRequest struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Secret      string   `json:"secret" binding:"omitempty,min=16"`
+	EventTypes  []string `json:"event_types"`
+	Description string   `json:"description" binding:"max=255"`
+	Active      *bool    `json:"active" binding:"required"`
+}
+
+type WebhookHandler struct {
+	Store models.Store
+}
+
+func (h *WebhookHandler) AdminCreateWebhookEndpoint(c *gin.Context) {
+	userID, err := middlewares.GetUserIDFromRequest(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var request CreateWebhookEndpointRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{}
+	if err := endpoint.CreateWebhookEndpoint(c.Request.Context(), h.Store, userID, request.URL, request.Secret, request.EventTypes, request.Description); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The secret is only ever returned here, so that it can be set up on
+	// the receiving side.
+	data := getWebhookEndpointResponseData(&endpoint)
+	data["secret"] = endpoint.Secret
+	c.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+func (h *WebhookHandler) AdminListWebhookEndpoints(c *gin.Context) {
+	endpoint := models.WebhookEndpoint{}
+	endpoints, err := endpoint.ListWebhookEndpoints(c.Request.Context(), h.Store)
+	if err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": endpoints})
+}
+
+func (h *WebhookHandler) AdminGetWebhookEndpoint(c *gin.Context) {
+	endpointID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{}
+	if err := endpoint.GetWebhookEndpoint(c.Request.Context(), h.Store, endpointID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getWebhookEndpointResponseData(&endpoint)})
+}
+
+func (h *WebhookHandler) AdminUpdateWebhookEndpoint(c *gin.Context) {
+	endpointID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	var request UpdateWebhookEndpointRequest
+	if errs := validators.BindAndValidate(c, &request); len(errs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": errs})
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{}
+	e := endpoint.UpdateWebhookEndpoint(
+		c.Request.Context(),
+		h.Store,
+		endpointID,
+		request.URL,
+		request.Secret,
+		request.EventTypes,
+		request.Description,
+		*request.Active,
+	)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": getWebhookEndpointResponseData(&endpoint)})
+}
+
+func (h *WebhookHandler) AdminDeleteWebhookEndpoint(c *gin.Context) {
+	endpointID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	endpoint := models.WebhookEndpoint{}
+	if err := endpoint.DeleteWebhookEndpoint(c.Request.Context(), h.Store, endpointID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "webhook endpoint deleted successfully"})
+}
+
+func (h *WebhookHandler) AdminListWebhookDeliveries(c *gin.Context) {
+	endpointID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	offset, limit := getPagination(c)
+
+	delivery := models.WebhookDelivery{}
+	deliveries, total, e := delivery.ListWebhookDeliveries(c.Request.Context(), h.Store, endpointID, c.Query("status"), offset, limit)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": deliveries, "total": total, "offset": offset, "limit": limit})
+}
+
+func (h *WebhookHandler) AdminGetWebhookDelivery(c *gin.Context) {
+	deliveryID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	delivery := models.WebhookDelivery{}
+	attempts, e := delivery.GetWebhookDelivery(c.Request.Context(), h.Store, deliveryID)
+	if e != nil {
+		c.JSON(e.Code, gin.H{"error": e.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"delivery": delivery, "attempts": attempts}})
+}
+
+func (h *WebhookHandler) AdminRedeliverWebhook(c *gin.Context) {
+	deliveryID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid syntax for id"})
+		return
+	}
+
+	delivery := models.WebhookDelivery{}
+	if err := delivery.Redeliver(c.Request.Context(), h.Store, deliveryID); err != nil {
+		c.JSON(err.Code, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"data": delivery})
+}
+
+func getWebhookEndpointResponseData(endpoint *models.WebhookEndpoint) map[string]any {
+	return map[string]any{
+		"id":          endpoint.ID,
+		"url":         endpoint.URL,
+		"event_types": endpoint.EventTypes,
+		"description": endpoint.Description,
+		"active":      endpoint.Active,
+		"created_by":  endpoint.CreatedBy,
+		"created_at":  endpoint.CreatedAt,
+		"updated_at":  endpoint.UpdatedAt,
+	}
+}
@@ -0,0 +1,10 @@
+ALTER TABLE profiles
+ALTER COLUMN date_of_birth SET NOT NULL;
+
+DROP INDEX IF EXISTS users_auth_provider_external_id_idx;
+
+ALTER TABLE users
+DROP COLUMN external_id;
+
+ALTER TABLE users
+DROP COLUMN auth_provider;
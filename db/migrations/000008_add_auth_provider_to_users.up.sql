@@ -0,0 +1,13 @@
+ALTER TABLE users
+ADD COLUMN auth_provider VARCHAR(32) NOT NULL DEFAULT 'local';
+
+ALTER TABLE users
+ADD COLUMN external_id VARCHAR(255);
+
+CREATE UNIQUE INDEX users_auth_provider_external_id_idx
+ON users (auth_provider, external_id)
+WHERE external_id IS NOT NULL;
+
+-- Profiles of externally provisioned users only know the user's names.
+ALTER TABLE profiles
+ALTER COLUMN date_of_birth DROP NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_scheduled_purge_at_idx;
+
+ALTER TABLE users
+DROP COLUMN scheduled_purge_at;
@@ -0,0 +1,6 @@
+ALTER TABLE users
+ADD COLUMN scheduled_purge_at TIMESTAMP;
+
+CREATE INDEX users_scheduled_purge_at_idx
+ON users (scheduled_purge_at)
+WHERE scheduled_purge_at IS NOT NULL;
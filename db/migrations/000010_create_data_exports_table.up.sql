@@ -0,0 +1,14 @@
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    content BYTEA,
+    download_token VARCHAR(255),
+    error TEXT,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX data_exports_user_id_idx ON data_exports (user_id);
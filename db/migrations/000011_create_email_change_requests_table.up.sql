@@ -0,0 +1,15 @@
+CREATE TABLE email_change_requests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    old_email VARCHAR(255) NOT NULL,
+    new_email VARCHAR(255) NOT NULL,
+    confirm_token VARCHAR(255) UNIQUE NOT NULL,
+    cancel_token VARCHAR(255) UNIQUE NOT NULL,
+    requested_by_token_id UUID,
+    token_expiry TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    confirmed_at TIMESTAMP,
+    cancelled_at TIMESTAMP
+);
+
+CREATE INDEX email_change_requests_user_id_idx ON email_change_requests (user_id);
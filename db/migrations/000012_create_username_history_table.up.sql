@@ -0,0 +1,12 @@
+CREATE TABLE username_history (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    old_username VARCHAR(255) NOT NULL,
+    new_username VARCHAR(255) NOT NULL,
+    changed_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    held_until TIMESTAMP NOT NULL
+);
+
+CREATE INDEX username_history_user_id_idx ON username_history (user_id, changed_at);
+
+CREATE INDEX username_history_old_username_idx ON username_history (old_username, changed_at);
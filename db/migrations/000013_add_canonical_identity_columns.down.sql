@@ -0,0 +1,19 @@
+DROP INDEX IF EXISTS username_history_old_username_canonical_idx;
+
+ALTER TABLE username_history
+DROP COLUMN old_username_canonical;
+
+DROP INDEX IF EXISTS users_username_skeleton_idx;
+
+DROP INDEX IF EXISTS users_email_canonical_idx;
+
+DROP INDEX IF EXISTS users_username_canonical_idx;
+
+ALTER TABLE users
+DROP COLUMN username_skeleton;
+
+ALTER TABLE users
+DROP COLUMN email_canonical;
+
+ALTER TABLE users
+DROP COLUMN username_canonical;
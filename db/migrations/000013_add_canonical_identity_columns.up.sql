@@ -0,0 +1,20 @@
+-- Usernames and emails are compared in a case-folded, NFKC-normalised form.
+-- The columns are filled in by the application on startup, as neither the
+-- case folding nor the confusables mapping of skeletons can be expressed in
+-- SQL. It also creates the unique indexes on the canonical columns once it
+-- made sure that no two users collide.
+ALTER TABLE users
+ADD COLUMN username_canonical VARCHAR(255);
+
+ALTER TABLE users
+ADD COLUMN email_canonical VARCHAR(255);
+
+ALTER TABLE users
+ADD COLUMN username_skeleton VARCHAR(255);
+
+CREATE INDEX users_username_skeleton_idx ON users (username_skeleton);
+
+ALTER TABLE username_history
+ADD COLUMN old_username_canonical VARCHAR(255);
+
+CREATE INDEX username_history_old_username_canonical_idx ON username_history (old_username_canonical, changed_at);
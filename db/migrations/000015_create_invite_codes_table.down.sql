@@ -0,0 +1,6 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS referred_by;
+
+DROP TABLE IF EXISTS invite_code_uses;
+
+DROP TABLE IF EXISTS invite_codes;
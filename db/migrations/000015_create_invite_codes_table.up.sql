@@ -0,0 +1,26 @@
+CREATE TABLE invite_codes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code VARCHAR(255) UNIQUE NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    max_uses INT NOT NULL,
+    use_count INT DEFAULT 0 NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX invite_codes_created_by_idx ON invite_codes (created_by, created_at);
+
+CREATE TABLE invite_code_uses (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    invite_code_id UUID REFERENCES invite_codes(id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    used_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX invite_code_uses_invite_code_id_idx ON invite_code_uses (invite_code_id, used_at);
+
+ALTER TABLE users
+ADD COLUMN referred_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX users_referred_by_idx ON users (referred_by);
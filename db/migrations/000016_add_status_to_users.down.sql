@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS account_status_changes;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS suspended_until;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS status_reason;
+
+ALTER TABLE users
+DROP COLUMN IF EXISTS status;
@@ -0,0 +1,21 @@
+ALTER TABLE users
+ADD COLUMN status VARCHAR(20) DEFAULT 'ACTIVE' NOT NULL;
+
+ALTER TABLE users
+ADD COLUMN status_reason TEXT;
+
+ALTER TABLE users
+ADD COLUMN suspended_until TIMESTAMP;
+
+CREATE TABLE account_status_changes (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE NOT NULL,
+    old_status VARCHAR(20) NOT NULL,
+    new_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    suspended_until TIMESTAMP,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX account_status_changes_user_id_idx ON account_status_changes (user_id, created_at);
@@ -0,0 +1,21 @@
+-- Actor and target are deliberately not foreign keys, so that the log
+-- outlives the users it mentions.
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    action VARCHAR(100) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    actor_id UUID,
+    target_user_id UUID,
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    request_id VARCHAR(255),
+    details JSONB,
+    created_at TIMESTAMP DEFAULT (NOW() AT TIME ZONE 'UTC') NOT NULL
+);
+
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, created_at);
+
+CREATE INDEX audit_events_target_user_id_idx ON audit_events (target_user_id, created_at);
+
+ALTER TABLE tokens
+ADD COLUMN impersonator_id UUID REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS audit_events_created_at_idx;
+
+DROP INDEX IF EXISTS audit_events_action_idx;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+
+DROP FUNCTION IF EXISTS audit_events_append_only();
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS password_reset_tokens_token_expiry_idx;
+
+DROP INDEX IF EXISTS tokens_expires_at_idx;
+
+DROP INDEX IF EXISTS tokens_user_id_expires_at_idx;
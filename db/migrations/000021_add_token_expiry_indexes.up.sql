@@ -0,0 +1,5 @@
+CREATE INDEX tokens_user_id_expires_at_idx ON tokens (user_id, expires_at);
+
+CREATE INDEX tokens_expires_at_idx ON tokens (expires_at);
+
+CREATE INDEX password_reset_tokens_token_expiry_idx ON password_reset_tokens (token_expiry);
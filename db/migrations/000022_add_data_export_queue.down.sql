@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS data_exports_status_created_at_idx;
+
+DROP INDEX IF EXISTS data_exports_user_id_in_progress_idx;
+
+ALTER TABLE data_exports
+DROP COLUMN started_at;
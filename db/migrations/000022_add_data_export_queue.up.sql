@@ -0,0 +1,24 @@
+-- Exports are generated by workers claiming pending rows. started_at tells
+-- when a worker claimed an export, so that exports left processing by a
+-- worker that died can be handed to another one.
+ALTER TABLE data_exports
+ADD COLUMN started_at TIMESTAMP;
+
+-- Only the most recent export of a user can stay in progress.
+UPDATE data_exports
+SET status = 'FAILED',
+    error = 'superseded by a more recent export'
+WHERE status IN ('PENDING', 'PROCESSING')
+  AND EXISTS (
+    SELECT 1
+    FROM data_exports newer
+    WHERE newer.user_id = data_exports.user_id
+      AND newer.status IN ('PENDING', 'PROCESSING')
+      AND (newer.created_at, newer.id) > (data_exports.created_at, data_exports.id)
+  );
+
+CREATE UNIQUE INDEX data_exports_user_id_in_progress_idx ON data_exports (user_id)
+WHERE status IN ('PENDING', 'PROCESSING');
+
+CREATE INDEX data_exports_status_created_at_idx ON data_exports (status, created_at)
+WHERE status IN ('PENDING', 'PROCESSING');
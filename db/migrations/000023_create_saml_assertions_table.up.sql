@@ -0,0 +1,10 @@
+-- The SAML assertions users signed in with, kept until they expire so that
+-- a captured assertion can not be used again.
+CREATE TABLE saml_assertions (
+    identity_provider VARCHAR(100) NOT NULL,
+    assertion_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (identity_provider, assertion_id)
+);
+
+CREATE INDEX saml_assertions_expires_at_idx ON saml_assertions (expires_at);
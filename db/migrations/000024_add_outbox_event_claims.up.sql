@@ -0,0 +1,5 @@
+-- Relays claim events in a short transaction and publish them outside of
+-- it. claimed_until keeps other relays away from the events being
+-- published, and lets them take over events claimed by a relay that died.
+ALTER TABLE outbox_events
+ADD COLUMN claimed_until TIMESTAMP;
@@ -0,0 +1,5 @@
+DROP INDEX outbox_events_unpublished_idx;
+CREATE INDEX outbox_events_unpublished_idx ON outbox_events (created_at, id) WHERE published_at IS NULL;
+
+ALTER TABLE outbox_events
+DROP COLUMN dead_at;
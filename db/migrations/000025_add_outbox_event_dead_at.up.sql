@@ -0,0 +1,7 @@
+-- Events that failed to publish too many times are set aside as dead, so
+-- that they no longer hold up the events written after them.
+ALTER TABLE outbox_events
+ADD COLUMN dead_at TIMESTAMP;
+
+DROP INDEX outbox_events_unpublished_idx;
+CREATE INDEX outbox_events_unpublished_idx ON outbox_events (created_at, id) WHERE published_at IS NULL AND dead_at IS NULL;
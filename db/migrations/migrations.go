@@ -0,0 +1,167 @@
+// Package migrations embeds the SQL migrations of the database schema and
+// applies them.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/pgx/v5"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/vantutran2k1/social-network-auth/config"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// startupLockID is the key of the advisory lock held while migrating on
+// startup. It differs from the lock golang-migrate takes itself, which
+// gives up after a timeout rather than waiting for a long migration run
+// by another replica.
+const startupLockID = 4729301857
+
+// Migrator applies the embedded migrations to a database.
+type Migrator struct {
+	db      *sql.DB
+	source  source.Driver
+	migrate *migrate.Migrate
+}
+
+// MigrationStatus tells whether one migration is applied.
+type MigrationStatus struct {
+	Version uint
+	Name    string
+	Applied bool
+}
+
+// Status describes the schema version of the database. A dirty version
+// failed half-way and has to be fixed by hand, then forced.
+type Status struct {
+	Version    uint
+	Dirty      bool
+	Migrations []MigrationStatus
+}
+
+// New connects to the database described by cfg. The connection is only
+// used for migrating and is released by Close.
+func New(cfg *config.DBConfig) (*Migrator, error) {
+	db, err := sql.Open("pgx", cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+
+	src, err := iofs.New(files, ".")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	driver, err := pgx.WithInstance(db, &pgx.Config{})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Migrator{db: db, source: src, migrate: m}, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.migrate.Up())
+}
+
+// UpWithLock applies all pending migrations while holding an advisory lock,
+// so that replicas starting at the same time wait for the first one to
+// migrate instead of racing it.
+func (m *Migrator) UpWithLock(ctx context.Context) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", startupLockID); err != nil {
+		return fmt.Errorf("can not acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", startupLockID)
+
+	return m.Up()
+}
+
+// Steps applies the next n migrations, or reverts the last -n ones if n is
+// negative.
+func (m *Migrator) Steps(n int) error {
+	return m.migrate.Steps(n)
+}
+
+// Down reverts the given number of applied migrations, or all of them if
+// steps is not positive.
+func (m *Migrator) Down(steps int) error {
+	if steps <= 0 {
+		return ignoreNoChange(m.migrate.Down())
+	}
+
+	return m.Steps(-steps)
+}
+
+// Force sets the schema version without running any migration, which
+// clears the dirty flag once a failed migration was fixed by hand.
+func (m *Migrator) Force(version int) error {
+	return m.migrate.Force(version)
+}
+
+// Status lists the embedded migrations and which of them are applied.
+func (m *Migrator) Status() (*Status, error) {
+	status := &Status{}
+
+	version, dirty, err := m.migrate.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, err
+	}
+	status.Version = version
+	status.Dirty = dirty
+
+	v, err := m.source.First()
+	for err == nil {
+		r, name, readErr := m.source.ReadUp(v)
+		if readErr != nil {
+			return nil, readErr
+		}
+		r.Close()
+
+		status.Migrations = append(status.Migrations, MigrationStatus{Version: v, Name: name, Applied: v <= version})
+		v, err = m.source.Next(v)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// Close releases the connection to the database.
+func (m *Migrator) Close() error {
+	sourceErr, dbErr := m.migrate.Close()
+	return errors.Join(sourceErr, dbErr)
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+
+	return err
+}
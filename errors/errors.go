@@ -8,6 +8,9 @@ import (
 type ApiError struct {
 	Code    int
 	Message string
+	// ErrorCode is a machine-readable reason for errors clients are expected
+	// to tell apart, e.g. "account_suspended". Empty for most errors.
+	ErrorCode string
 }
 
 func (e *ApiError) Error() string {
@@ -18,6 +21,24 @@ func BadRequestError(format string, args ...any) *ApiError {
 	return newApiError(http.StatusBadRequest, fmt.Sprintf(format, args...))
 }
 
+func UnauthorizedError(format string, args ...any) *ApiError {
+	return newApiError(http.StatusUnauthorized, fmt.Sprintf(format, args...))
+}
+
+func NotFoundError(format string, args ...any) *ApiError {
+	return newApiError(http.StatusNotFound, fmt.Sprintf(format, args...))
+}
+
+func ForbiddenError(errorCode string, format string, args ...any) *ApiError {
+	err := newApiError(http.StatusForbidden, fmt.Sprintf(format, args...))
+	err.ErrorCode = errorCode
+	return err
+}
+
+func ConflictError(format string, args ...any) *ApiError {
+	return newApiError(http.StatusConflict, fmt.Sprintf(format, args...))
+}
+
 func InternalServerError(format string, args ...any) *ApiError {
 	return newApiError(http.StatusInternalServerError, fmt.Sprintf(format, args...))
 }
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher writes events to a single topic, keyed by aggregate ID so
+// that the events of a user stay in order.
+type KafkaPublisher struct {
+	Writer *kafka.Writer
+}
+
+func NewKafkaPublisher(brokers string, topic string) (*KafkaPublisher, error) {
+	if brokers == "" {
+		return nil, fmt.Errorf("EVENT_KAFKA_BROKERS is required for the kafka publisher")
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+
+	return &KafkaPublisher{Writer: writer}, nil
+}
+
+func (p *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return p.Writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(event.AggregateID.String()),
+		Value: body,
+		Headers: []kafka.Header{
+			{Key: "event_type", Value: []byte(event.Type)},
+			{Key: "event_id", Value: []byte(event.ID.String())},
+		},
+	})
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.Writer.Close()
+}
@@ -0,0 +1,25 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+)
+
+// LogPublisher writes events to the standard logger. It is meant for
+// development, where no broker is running.
+type LogPublisher struct{}
+
+func (p *LogPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("event published: %s", body)
+	return nil
+}
+
+func (p *LogPublisher) Close() error {
+	return nil
+}
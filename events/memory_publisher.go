@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPublisher is an in-process broker. It keeps every published event
+// and hands them to subscribers, which makes it suitable for tests and for
+// running without any external broker.
+type MemoryPublisher struct {
+	mu          sync.Mutex
+	events      []Event
+	subscribers []func(Event)
+}
+
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{}
+}
+
+func (p *MemoryPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.Lock()
+	p.events = append(p.events, event)
+	subscribers := append([]func(Event){}, p.subscribers...)
+	p.mu.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(event)
+	}
+
+	return nil
+}
+
+// Subscribe calls fn with every event published from now on.
+func (p *MemoryPublisher) Subscribe(fn func(Event)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscribers = append(p.subscribers, fn)
+}
+
+// Events returns the events published so far.
+func (p *MemoryPublisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Event{}, p.events...)
+}
+
+func (p *MemoryPublisher) Close() error {
+	return nil
+}
@@ -0,0 +1,51 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes each event on the subject SubjectPrefix + type,
+// e.g. "users.user.registered".
+type NATSPublisher struct {
+	Conn          *nats.Conn
+	SubjectPrefix string
+}
+
+func NewNATSPublisher(url string, subjectPrefix string) (*NATSPublisher, error) {
+	if url == "" {
+		url = nats.DefaultURL
+	}
+
+	conn, err := nats.Connect(url, nats.Name("social-network-auth"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	return &NATSPublisher{Conn: conn, SubjectPrefix: subjectPrefix}, nil
+}
+
+func (p *NATSPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(p.SubjectPrefix + event.Type)
+	msg.Data = body
+	msg.Header.Set(nats.MsgIdHdr, event.ID.String())
+	if err := p.Conn.PublishMsg(msg); err != nil {
+		return err
+	}
+
+	// Core NATS publishes are fire and forget; flushing at least makes sure
+	// the server received the event.
+	return p.Conn.FlushWithContext(ctx)
+}
+
+func (p *NATSPublisher) Close() error {
+	return p.Conn.Drain()
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+)
+
+// Event is a versioned domain event as published to other services. Data
+// is the JSON payload, whose shape is fixed for a given Type and Version.
+type Event struct {
+	ID          uuid.UUID       `json:"id"`
+	Type        string          `json:"type"`
+	Version     int             `json:"version"`
+	AggregateID uuid.UUID       `json:"aggregate_id"`
+	OccurredAt  time.Time       `json:"occurred_at"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// Publisher delivers events to a broker or other destination. Publish must
+// only return nil once the event is delivered, as the event is not retried
+// afterwards.
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+	Close() error
+}
+
+// NewPublisher creates the publisher selected in cfg: "log" (the default),
+// "webhook", "nats", "kafka" or "memory".
+func NewPublisher(cfg *config.EventConfig) (Publisher, error) {
+	switch cfg.Publisher {
+	case "", "log":
+		return &LogPublisher{}, nil
+	case "webhook":
+		return NewWebhookPublisher(cfg.WebhookURL, cfg.WebhookSecret)
+	case "nats":
+		return NewNATSPublisher(cfg.NATSURL, cfg.NATSSubjectPrefix)
+	case "kafka":
+		return NewKafkaPublisher(cfg.KafkaBrokers, cfg.KafkaTopic)
+	case "memory":
+		return NewMemoryPublisher(), nil
+	default:
+		return nil, fmt.Errorf("unknown event publisher %q", cfg.Publisher)
+	}
+}
@@ -0,0 +1,76 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const SignatureHeader = "X-Signature-SHA256"
+
+// WebhookPublisher POSTs each event as JSON to a single URL. If a secret is
+// set, the body is signed with HMAC-SHA256 in the X-Signature-SHA256 header.
+type WebhookPublisher struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func NewWebhookPublisher(url string, secret string) (*WebhookPublisher, error) {
+	if url == "" {
+		return nil, fmt.Errorf("EVENT_WEBHOOK_URL is required for the webhook publisher")
+	}
+
+	return &WebhookPublisher{
+		URL:    url,
+		Secret: secret,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *WebhookPublisher) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+	req.Header.Set("X-Event-ID", event.ID.String())
+	if p.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(p.Secret, body))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (p *WebhookPublisher) Close() error {
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body with the secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,73 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+// ExpiredTokenPurgeJob deletes the tokens that expired longer ago than the
+// configured retention.
+func ExpiredTokenPurgeJob(store models.Store, cfg *config.JobConfig) Job {
+	return Job{
+		Name:     "expired_token_purge",
+		Interval: cfg.TokenPurgeInterval,
+		Run: func(ctx context.Context) (int, error) {
+			before := time.Now().UTC().Add(-cfg.ExpiredTokenRetention)
+			return models.PurgeExpiredTokens(ctx, store, before, cfg.TokenPurgeBatchSize)
+		},
+	}
+}
+
+// ExpiredResetTokenPurgeJob deletes the password reset tokens that expired
+// longer ago than the configured retention.
+func ExpiredResetTokenPurgeJob(store models.Store, cfg *config.JobConfig) Job {
+	return Job{
+		Name:     "expired_reset_token_purge",
+		Interval: cfg.ResetTokenPurgeInterval,
+		Run: func(ctx context.Context) (int, error) {
+			before := time.Now().UTC().Add(-cfg.ExpiredResetTokenRetention)
+			return models.PurgeExpiredPasswordResetTokens(ctx, store, before, cfg.ResetTokenPurgeBatchSize)
+		},
+	}
+}
+
+// ExpiredDataPurgeJob deletes the pending email changes, the data exports
+// and the used SAML assertions that expired.
+func ExpiredDataPurgeJob(store models.Store, cfg *config.JobConfig) Job {
+	return Job{
+		Name:     "expired_data_purge",
+		Interval: cfg.ExpiredDataPurgeInterval,
+		Run: func(ctx context.Context) (int, error) {
+			purge, err := models.PurgeExpiredData(ctx, store, time.Now().UTC())
+			return int(purge.EmailChangeRequests + purge.DataExports + purge.SAMLAssertions), err
+		},
+	}
+}
+
+// DataExportReclaimJob makes the data exports left processing by a replica
+// that died pending again.
+func DataExportReclaimJob(store models.Store, cfg *config.DataExportConfig) Job {
+	return Job{
+		Name:     "data_export_reclaim",
+		Interval: cfg.ReclaimInterval,
+		Run: func(ctx context.Context) (int, error) {
+			return models.ReclaimStaleDataExports(ctx, store, cfg.ProcessingTimeout)
+		},
+	}
+}
+
+// AccountPurgeJob permanently deletes the accounts whose deletion grace
+// period has ended.
+func AccountPurgeJob(store models.Store, cfg *config.AccountConfig) Job {
+	return Job{
+		Name:     "deleted_account_purge",
+		Interval: cfg.PurgeInterval,
+		Run: func(ctx context.Context) (int, error) {
+			u := models.User{}
+			return u.PurgeDeletedAccounts(ctx, store, cfg.PurgeBatchSize)
+		},
+	}
+}
@@ -0,0 +1,15 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+// StartDataExportJob periodically generates the pending data exports.
+func StartDataExportJob(store models.Store, cfg *config.DataExportConfig) *Worker {
+	return startWorker("data export generation", cfg.ProcessingInterval, cfg.ProcessingBatchSize, func(ctx context.Context, batchSize int) (int, error) {
+		return models.GeneratePendingDataExports(ctx, store, cfg, batchSize)
+	})
+}
@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+)
+
+// schedulerLockID is the key of the advisory lock held by the replica that
+// runs the scheduled jobs.
+const schedulerLockID = 4729301858
+
+// Leader decides whether this replica runs the scheduled jobs, so that
+// replicas do not all clean up the same rows at once.
+type Leader interface {
+	// IsLeader tells whether this replica is the leader, trying to become
+	// the leader if no replica is.
+	IsLeader(ctx context.Context) bool
+	// Release gives up leadership.
+	Release()
+}
+
+// AlwaysLeader is the leader of a single replica, used with in-memory
+// storage where replicas do not share data.
+type AlwaysLeader struct{}
+
+func (AlwaysLeader) IsLeader(ctx context.Context) bool { return true }
+
+func (AlwaysLeader) Release() {}
+
+// AdvisoryLockLeader elects the leader with a Postgres session-level
+// advisory lock. The lock is held on a dedicated connection, so that it is
+// released by Postgres when the replica dies or loses its connection, and
+// another replica takes over on its next check.
+type AdvisoryLockLeader struct {
+	db *sql.DB
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+func NewAdvisoryLockLeader(db *sql.DB) *AdvisoryLockLeader {
+	return &AdvisoryLockLeader{db: db}
+}
+
+func (l *AdvisoryLockLeader) IsLeader(ctx context.Context) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err == nil {
+			return true
+		}
+
+		log.Printf("lost the connection holding the scheduler lock")
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		log.Printf("can not connect to acquire the scheduler lock: %v", err)
+		return false
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", schedulerLockID).Scan(&acquired); err != nil || !acquired {
+		if err != nil {
+			log.Printf("can not acquire the scheduler lock: %v", err)
+		}
+		conn.Close()
+		return false
+	}
+
+	log.Printf("became the leader for scheduled jobs")
+	l.conn = conn
+	return true
+}
+
+func (l *AdvisoryLockLeader) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		return
+	}
+
+	if _, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", schedulerLockID); err != nil {
+		log.Printf("can not release the scheduler lock: %v", err)
+	}
+	l.conn.Close()
+	l.conn = nil
+}
@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/events"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+const (
+	outboxPublishTimeout = 30 * time.Second
+	// outboxClaimLease is how long a relay has to publish the events it
+	// claimed before another relay takes them over.
+	outboxClaimLease = 5 * time.Minute
+)
+
+// StartOutboxRelay periodically publishes the events written to the outbox.
+// Each relay publishes the batch it claimed in the order the events were
+// written, and retries a failed event on its next run before the events
+// after it, until the event runs out of attempts and is marked dead. The
+// relay runs on every replica, and replicas claim different batches, so
+// events of different batches may be published out of order: consumers
+// must not rely on the order of events.
+func StartOutboxRelay(store models.Store, publisher events.Publisher, cfg *config.EventConfig) *Worker {
+	return startWorker("outbox relay", cfg.RelayInterval, cfg.RelayBatchSize, func(ctx context.Context, batchSize int) (int, error) {
+		return relayOutboxEvents(ctx, store, publisher, batchSize, cfg.RelayMaxAttempts)
+	})
+}
+
+// relayOutboxEvents publishes up to batchSize events and returns how many
+// were published or marked dead. The events are claimed in a short
+// transaction and published outside of it, so that no lock is held while
+// the broker is waited on. A failed publish ends the batch unless the event
+// ran out of its maxAttempts attempts; it is only an error of the relay if
+// the relay is being stopped.
+func relayOutboxEvents(ctx context.Context, store models.Store, publisher events.Publisher, batchSize int, maxAttempts int) (int, error) {
+	outboxEvents, err := models.ClaimUnpublishedOutboxEvents(ctx, store, batchSize, outboxClaimLease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range outboxEvents {
+		e := &outboxEvents[i]
+		if err := publishOutboxEvent(ctx, publisher, e); err != nil {
+			if ctx.Err() != nil {
+				// Stopped: the events are left for the next relay to
+				// publish, without counting an attempt.
+				releaseOutboxEvents(context.WithoutCancel(ctx), store, outboxEvents[i:])
+				return i, ctx.Err()
+			}
+
+			log.Printf("failed to publish event %v (%s), attempt %d: %v", e.ID, e.EventType, e.Attempts+1, err)
+			if err := e.MarkFailed(ctx, store, err, maxAttempts); err != nil {
+				log.Printf("outbox relay failed: %v", err)
+			}
+			if e.DeadAt != nil {
+				// Giving up on the event lets the ones after it through.
+				log.Printf("event %v (%s) is dead after %d attempts", e.ID, e.EventType, e.Attempts)
+				continue
+			}
+			releaseOutboxEvents(ctx, store, outboxEvents[i+1:])
+			return i, nil
+		}
+
+		// The event is published again once its claim expires if this
+		// fails, which consumers tolerate as events may be delivered more
+		// than once.
+		if err := e.MarkPublished(ctx, store); err != nil {
+			releaseOutboxEvents(ctx, store, outboxEvents[i+1:])
+			return i, err
+		}
+	}
+
+	return len(outboxEvents), nil
+}
+
+// releaseOutboxEvents gives up the events left after a failure, so that they
+// are published after the failed one on the next run.
+func releaseOutboxEvents(ctx context.Context, store models.Store, outboxEvents []models.OutboxEvent) {
+	if err := models.ReleaseOutboxEvents(ctx, store, outboxEvents); err != nil {
+		log.Printf("outbox relay failed to release events: %v", err)
+	}
+}
+
+// publishOutboxEvent publishes outside of any transaction.
+func publishOutboxEvent(ctx context.Context, publisher events.Publisher, e *models.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, outboxPublishTimeout)
+	defer cancel()
+
+	return publisher.Publish(ctx, e.Event())
+}
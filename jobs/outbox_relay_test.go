@@ -0,0 +1,234 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/events"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+)
+
+// testPublisher is the in-process broker, with a hook run before each event
+// is accepted that can fail or hold the publish.
+type testPublisher struct {
+	*events.MemoryPublisher
+	before func(ctx context.Context, event events.Event) error
+}
+
+func newTestPublisher(before func(ctx context.Context, event events.Event) error) *testPublisher {
+	return &testPublisher{MemoryPublisher: events.NewMemoryPublisher(), before: before}
+}
+
+func (p *testPublisher) Publish(ctx context.Context, event events.Event) error {
+	if p.before != nil {
+		if err := p.before(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return p.MemoryPublisher.Publish(ctx, event)
+}
+
+func writeOutboxEvents(t *testing.T, store models.Store, n int) []uuid.UUID {
+	t.Helper()
+
+	ids := make([]uuid.UUID, 0, n)
+	for i := 0; i < n; i++ {
+		event := models.OutboxEvent{
+			ID:          uuid.New(),
+			EventType:   models.UserRegisteredEvent,
+			Version:     1,
+			AggregateID: uuid.New(),
+			Payload:     json.RawMessage(`{}`),
+			CreatedAt:   time.Now().UTC(),
+		}
+		if err := store.OutboxEvents().Create(context.Background(), &event); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, event.ID)
+	}
+
+	return ids
+}
+
+func publishedIDs(p *testPublisher) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, e := range p.Events() {
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
+
+const testMaxAttempts = 3
+
+// relay runs the relay once and returns how many events it published or
+// marked dead.
+func relay(t *testing.T, store models.Store, publisher events.Publisher, batchSize int) int {
+	t.Helper()
+
+	published, err := relayOutboxEvents(context.Background(), store, publisher, batchSize, testMaxAttempts)
+	if err != nil {
+		t.Errorf("relayOutboxEvents() error = %v", err)
+	}
+
+	return published
+}
+
+func assertIDs(t *testing.T, name string, got []uuid.UUID, want []uuid.UUID) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("%s = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRelayOutboxEventsPublishesInOrderOnce(t *testing.T) {
+	store := storage.NewMemoryStore()
+	publisher := newTestPublisher(nil)
+	ids := writeOutboxEvents(t, store, 3)
+
+	if published := relay(t, store, publisher, 10); published != 3 {
+		t.Errorf("relay() = %d, want 3", published)
+	}
+	if published := relay(t, store, publisher, 10); published != 0 {
+		t.Errorf("second relay() = %d, want 0", published)
+	}
+
+	assertIDs(t, "published events", publishedIDs(publisher), ids)
+}
+
+func TestRelayOutboxEventsRetriesFailedEventFirst(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ids := writeOutboxEvents(t, store, 3)
+
+	fail := true
+	publisher := newTestPublisher(func(ctx context.Context, event events.Event) error {
+		if fail && event.ID == ids[1] {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	})
+
+	if published := relay(t, store, publisher, 10); published != 1 {
+		t.Errorf("relay() = %d, want 1", published)
+	}
+	assertIDs(t, "published events", publishedIDs(publisher), ids[:1])
+
+	unpublished, err := store.OutboxEvents().FindUnpublished(context.Background(), time.Now().UTC(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpublished) != 2 || unpublished[0].Attempts != 1 || unpublished[0].LastError == "" {
+		t.Fatalf("unpublished events = %+v, want the failed event and the one after it, released", unpublished)
+	}
+
+	fail = false
+	if published := relay(t, store, publisher, 10); published != 2 {
+		t.Errorf("second relay() = %d, want 2", published)
+	}
+	assertIDs(t, "published events", publishedIDs(publisher), ids)
+}
+
+func TestRelayOutboxEventsMarksEventDeadAfterMaxAttempts(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ids := writeOutboxEvents(t, store, 3)
+
+	publisher := newTestPublisher(func(ctx context.Context, event events.Event) error {
+		if event.ID == ids[0] {
+			return errors.New("message too large")
+		}
+		return nil
+	})
+
+	for attempt := 1; attempt < testMaxAttempts; attempt++ {
+		if published := relay(t, store, publisher, 10); published != 0 {
+			t.Fatalf("relay() attempt %d = %d, want 0", attempt, published)
+		}
+	}
+
+	// The last attempt gives up on the event and publishes the ones after it.
+	if published := relay(t, store, publisher, 10); published != 3 {
+		t.Errorf("last relay() = %d, want 3", published)
+	}
+	assertIDs(t, "published events", publishedIDs(publisher), ids[1:])
+
+	unpublished, err := store.OutboxEvents().FindUnpublished(context.Background(), time.Now().UTC(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpublished) != 0 {
+		t.Errorf("unpublished events = %+v, want none, the dead event skipped", unpublished)
+	}
+}
+
+func TestRelayOutboxEventsPublishesOutsideTransaction(t *testing.T) {
+	store := storage.NewMemoryStore()
+	writeOutboxEvents(t, store, 1)
+
+	publishing := make(chan struct{})
+	release := make(chan struct{})
+	publisher := newTestPublisher(func(ctx context.Context, event events.Event) error {
+		close(publishing)
+		<-release
+		return nil
+	})
+
+	done := make(chan int)
+	go func() { done <- relay(t, store, publisher, 10) }()
+	<-publishing
+
+	// The store must stay usable while the broker is waited on, and the
+	// claimed event must not be handed to another relay.
+	other := make(chan int)
+	go func() { other <- relay(t, store, newTestPublisher(nil), 10) }()
+	select {
+	case published := <-other:
+		if published != 0 {
+			t.Errorf("concurrent relay() = %d, want 0", published)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the store is locked while an event is published")
+	}
+
+	close(release)
+	if published := <-done; published != 1 {
+		t.Errorf("relay() = %d, want 1", published)
+	}
+}
+
+func TestOutboxRelayStopsWhilePublishing(t *testing.T) {
+	store := storage.NewMemoryStore()
+	writeOutboxEvents(t, store, 2)
+
+	publishing := make(chan struct{})
+	publisher := newTestPublisher(func(ctx context.Context, event events.Event) error {
+		close(publishing)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	worker := StartOutboxRelay(store, publisher, &config.EventConfig{RelayInterval: time.Hour, RelayBatchSize: 10})
+	<-publishing
+	worker.Stop()
+
+	// The events are left for the next relay, without counting an attempt.
+	unpublished, err := store.OutboxEvents().FindUnpublished(context.Background(), time.Now().UTC(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpublished) != 2 || unpublished[0].Attempts != 0 {
+		t.Fatalf("unpublished events = %+v, want both events, released", unpublished)
+	}
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/metrics"
+)
+
+// Job is a task the scheduler runs periodically.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// Run does one run of the job and returns how many items it processed.
+	Run func(ctx context.Context) (int, error)
+}
+
+// JobRun describes one run of a job.
+type JobRun struct {
+	Job       string
+	StartedAt time.Time
+	Duration  time.Duration
+	Processed int
+	Err       error
+}
+
+// Reporter is told about every run of a job.
+type Reporter interface {
+	Report(run JobRun)
+}
+
+// LogReporter logs failed runs, and successful runs that processed
+// anything.
+type LogReporter struct{}
+
+func (LogReporter) Report(run JobRun) {
+	switch {
+	case run.Err != nil:
+		log.Printf("job %s failed after %v and %d items: %v", run.Job, run.Duration, run.Processed, run.Err)
+	case run.Processed > 0:
+		log.Printf("job %s processed %d items in %v", run.Job, run.Processed, run.Duration)
+	}
+}
+
+// MetricsReporter counts the runs, their duration and the items processed
+// in Prometheus metrics.
+type MetricsReporter struct{}
+
+func (MetricsReporter) Report(run JobRun) {
+	result := "success"
+	if run.Err != nil {
+		result = "failure"
+	}
+
+	metrics.JobRuns.WithLabelValues(run.Job, result).Inc()
+	metrics.JobRunDuration.WithLabelValues(run.Job).Observe(run.Duration.Seconds())
+	metrics.JobItemsProcessed.WithLabelValues(run.Job).Add(float64(run.Processed))
+}
+
+// Reporters reports every run to each of its reporters.
+type Reporters []Reporter
+
+func (r Reporters) Report(run JobRun) {
+	for _, reporter := range r {
+		reporter.Report(run)
+	}
+}
+
+// Scheduler runs jobs at their interval on the replica that is the leader.
+// Every replica keeps checking, so that another one takes over when the
+// leader goes away.
+type Scheduler struct {
+	leader   Leader
+	reporter Reporter
+	jobs     []Job
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(leader Leader, reporter Reporter) *Scheduler {
+	return &Scheduler{leader: leader, reporter: reporter}
+}
+
+// Add registers a job. Jobs must be added before Start.
+func (s *Scheduler) Add(job Job) {
+	s.jobs = append(s.jobs, job)
+}
+
+// Start runs every job right away, then at its interval, until Stop.
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+}
+
+// Stop waits for the running jobs to return and gives up leadership.
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+	s.leader.Release()
+}
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if s.leader.IsLeader(ctx) {
+			s.run(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	startedAt := time.Now()
+	processed, err := job.Run(ctx)
+	if ctx.Err() != nil {
+		// Stopped in the middle of the run.
+		return
+	}
+
+	s.reporter.Report(JobRun{
+		Job:       job.Name,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+		Processed: processed,
+		Err:       err,
+	})
+}
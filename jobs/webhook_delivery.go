@@ -0,0 +1,14 @@
+package jobs
+
+import (
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/webhooks"
+)
+
+// StartWebhookDeliveryJob periodically sends due webhook deliveries.
+func StartWebhookDeliveryJob(store models.Store, cfg *config.WebhookConfig) *Worker {
+	dispatcher := webhooks.NewDispatcher(store, cfg.MaxAttempts, cfg.RetryBase)
+
+	return startWorker("webhook delivery", cfg.DeliveryInterval, cfg.DeliveryBatchSize, dispatcher.DeliverDue)
+}
@@ -0,0 +1,57 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Worker processes a queue in batches on every replica, right away and then
+// at its interval, until Stop. As long as full batches are processed, it
+// keeps going without waiting, so that a backlog is not drained one batch
+// per interval.
+type Worker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startWorker runs processBatch, which processes up to batchSize items and
+// returns how many it processed, until the worker is stopped. Failures are
+// logged as failures of name.
+func startWorker(name string, interval time.Duration, batchSize int, processBatch func(ctx context.Context, batchSize int) (int, error)) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Worker{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			for ctx.Err() == nil {
+				processed, err := processBatch(ctx, batchSize)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("%s failed: %v", name, err)
+				}
+				if err != nil || processed < batchSize {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return w
+}
+
+// Stop cancels the batch being processed and waits for the worker to return.
+func (w *Worker) Stop() {
+	w.cancel()
+	<-w.done
+}
@@ -1,31 +1,76 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
 
 	"github.com/joho/godotenv"
 	"github.com/vantutran2k1/social-network-auth/config"
-	"github.com/vantutran2k1/social-network-auth/routes"
-	"github.com/vantutran2k1/social-network-auth/transaction"
-	"github.com/vantutran2k1/social-network-auth/validators"
 )
 
+const usage = `Usage: %s [-config FILE] <command> [arguments]
+
+Commands:
+  serve                       serve the API (the default)
+  migrate up [N]              apply all pending migrations, or the next N
+  migrate down [N | -all]     revert the last N migrations (1 by default), or all of them
+  migrate status              list the migrations and which of them are applied
+  migrate force VERSION       set the schema version without migrating, to recover from a failed migration
+  create-admin                create a local user with admin rights
+  revoke-user-tokens USER     revoke all active tokens of a user, given by ID, username or email
+  purge-expired               delete expired tokens, requests and exports, and purge deleted accounts
+  print-config                print the configuration, with secrets redacted
+
+Flags:
+`
+
+// command runs one subcommand with the arguments that follow its name.
+type command func(cfg *config.Config, args []string) error
+
+var commands = map[string]command{
+	"serve":              serve,
+	"migrate":            migrateCommand,
+	"create-admin":       createAdmin,
+	"revoke-user-tokens": revokeUserTokens,
+	"purge-expired":      purgeExpired,
+	"print-config":       printConfig,
+}
+
 func main() {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	// .env is a convenience for local development; containers pass the
+	// environment directly.
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	validators.RegisterCustomValidators()
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file")
+	flag.Parse()
+
+	name, args := "serve", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
 
-	config.InitDB()
-	transaction.InitTransactionManager(config.DB)
+	run, ok := commands[name]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	router := routes.SetupRouter()
-	err = router.Run(":" + os.Getenv("APP_PORT"))
+	cfg, err := config.Load(*configFile)
 	if err != nil {
 		log.Fatal(err)
-		return
+	}
+
+	if err := run(cfg, args); err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,112 @@
+// Package metrics defines the Prometheus metrics of the service and serves
+// them.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// HTTPRequestDuration observes the requests served, by route pattern rather
+// than path so that IDs do not multiply the series.
+var HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "auth_http_request_duration_seconds",
+	Help:    "Duration of the HTTP requests served, by method, route and status.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"method", "route", "status"})
+
+// EmailsSent counts the emails sent, by result: "success" or "failure".
+var EmailsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_emails_sent_total",
+	Help: "Emails sent, by result.",
+}, []string{"result"})
+
+// TokenCacheLookups counts the token validations by where the token was
+// found: "local_hit", "shared_hit" or "miss", which needs a database lookup.
+var TokenCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_token_cache_lookups_total",
+	Help: "Token validations by token cache result.",
+}, []string{"result"})
+
+// JobRuns counts the runs of the scheduled jobs, by job and result:
+// "success" or "failure".
+var JobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_job_runs_total",
+	Help: "Runs of the scheduled jobs, by job and result.",
+}, []string{"job", "result"})
+
+var JobRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "auth_job_run_duration_seconds",
+	Help:    "Duration of the runs of the scheduled jobs, by job.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"job"})
+
+var JobItemsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_job_items_processed_total",
+	Help: "Items processed by the scheduled jobs, such as rows deleted, by job.",
+}, []string{"job"})
+
+var LoginsSucceeded = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "auth_logins_succeeded_total",
+	Help: "Successful logins with a username and password.",
+})
+
+// LoginsFailed counts the failed logins by reason: "invalid_request",
+// "invalid_credentials", "internal_error", or the error code of the account
+// status that prevented the login, such as "account_suspended".
+var LoginsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_logins_failed_total",
+	Help: "Failed logins with a username and password, by reason.",
+}, []string{"reason"})
+
+var Registrations = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "auth_registrations_total",
+	Help: "Users registered.",
+})
+
+var PasswordResetsRequested = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "auth_password_resets_requested_total",
+	Help: "Password reset tokens issued.",
+})
+
+var PasswordResetsCompleted = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "auth_password_resets_completed_total",
+	Help: "Passwords reset with a reset token.",
+})
+
+var TokenRevocations = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "auth_token_revocations_total",
+	Help: "Tokens revoked, by logout or because of a change to their user.",
+})
+
+var LevelChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_level_changes_total",
+	Help: "User level changes, by new level.",
+}, []string{"level"})
+
+// NewDBStatsRegistry returns a registry of the connection pool statistics of
+// db. Each app keeps its own and serves it along with the metrics of the
+// process, so that several apps in the same process report their own pool.
+func NewDBStatsRegistry(db *sql.DB) (*prometheus.Registry, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collectors.NewDBStatsCollector(db, "auth")); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+// Handler serves the metrics of the process, along with those of the given
+// gatherers, in the Prometheus text format.
+func Handler(gatherers ...prometheus.Gatherer) http.Handler {
+	gatherers = append([]prometheus.Gatherer{prometheus.DefaultGatherer}, gatherers...)
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers(gatherers), promhttp.HandlerOpts{}),
+	)
+}
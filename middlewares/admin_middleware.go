@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+// AdminMiddleware only lets admins through. It must run after AuthMiddleware.
+func AdminMiddleware(store models.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := GetUserIDFromRequest(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := store.Users().FindByID(c.Request.Context(), userID)
+		if err != nil || !user.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+			return
+		}
+
+		c.Set("is_admin", true)
+		c.Next()
+	}
+}
+
+func IsAdminRequest(c *gin.Context) bool {
+	return c.GetBool("is_admin")
+}
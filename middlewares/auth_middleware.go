@@ -2,18 +2,21 @@ package middlewares
 
 import (
 	"errors"
+	"log"
 	"net/http"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/vantutran2k1/social-network-auth/config"
 	"github.com/vantutran2k1/social-network-auth/models"
 	"github.com/vantutran2k1/social-network-auth/utils"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware lets requests with a valid token of an active user through.
+// Tokens are checked against jwtKey and the tokens saved in store, which
+// cache spares looking up on every request along with the user's status.
+func AuthMiddleware(store models.Store, cache models.TokenCache, jwtKey []byte) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := GetAuthTokenFromRequest(c)
 		if tokenString == "" {
@@ -23,7 +26,7 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		claims := &utils.Claims{}
 		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return utils.JwtKey, nil
+			return jwtKey, nil
 		})
 		if err != nil || !token.Valid {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
@@ -31,13 +34,47 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		var dbToken models.Token
-		if !dbToken.Validate(config.DB, tokenString) {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token not found or expired"})
+		if err := dbToken.Validate(c.Request.Context(), store, cache, tokenString); err != nil {
+			body := gin.H{"error": err.Error()}
+			if err.ErrorCode != "" {
+				body["code"] = err.ErrorCode
+			}
+			c.AbortWithStatusJSON(err.Code, body)
 			return
 		}
 
+		if claims.ImpersonatorID != nil {
+			impersonator, err := store.Users().FindByID(c.Request.Context(), *claims.ImpersonatorID)
+			if err != nil || !impersonator.IsAdmin || impersonator.Status != models.AccountActive {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "impersonator is no longer allowed to impersonate"})
+				return
+			}
+			c.Set("impersonator_id", *claims.ImpersonatorID)
+		}
+
 		c.Set("user_id", claims.UserID)
 		c.Next()
+
+		if claims.ImpersonatorID != nil {
+			recordImpersonatedRequest(c, store, *claims.ImpersonatorID, claims.UserID)
+		}
+	}
+}
+
+// BlockImpersonationMiddleware refuses requests made with an impersonation
+// token. It guards sensitive actions such as changing credentials and must
+// run after AuthMiddleware.
+func BlockImpersonationMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, impersonated := c.Get("impersonator_id"); impersonated {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "this action is not allowed while impersonating",
+				"code":  "impersonation_not_allowed",
+			})
+			return
+		}
+
+		c.Next()
 	}
 }
 
@@ -50,6 +87,17 @@ func GetUserIDFromRequest(c *gin.Context) (uuid.UUID, error) {
 	return userID.(uuid.UUID), nil
 }
 
+// GetImpersonatorIDFromRequest returns the admin impersonating the user, if any.
+func GetImpersonatorIDFromRequest(c *gin.Context) *uuid.UUID {
+	impersonatorID, exist := c.Get("impersonator_id")
+	if !exist {
+		return nil
+	}
+
+	id := impersonatorID.(uuid.UUID)
+	return &id
+}
+
 func GetAuthTokenFromRequest(c *gin.Context) string {
 	token := c.Request.Header.Get("Authorization")
 	tokenParts := strings.Split(token, " ")
@@ -59,3 +107,28 @@ func GetAuthTokenFromRequest(c *gin.Context) string {
 
 	return token
 }
+
+func recordImpersonatedRequest(c *gin.Context, store models.Store, impersonatorID uuid.UUID, userID uuid.UUID) {
+	outcome := models.AuditOutcomeSuccess
+	if c.Writer.Status() >= http.StatusBadRequest {
+		outcome = models.AuditOutcomeFailure
+	}
+
+	event := models.AuditEvent{
+		Action:       models.AuditImpersonatedRequest,
+		Outcome:      outcome,
+		ActorID:      &impersonatorID,
+		TargetUserID: &userID,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		RequestID:    GetRequestID(c),
+		Details: map[string]any{
+			"method": c.Request.Method,
+			"path":   c.FullPath(),
+			"status": c.Writer.Status(),
+		},
+	}
+	if err := models.RecordAuditEvent(c.Request.Context(), store, &event); err != nil {
+		log.Printf("failed to record impersonated request of %v as %v: %v", impersonatorID, userID, err)
+	}
+}
@@ -0,0 +1,24 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/vantutran2k1/social-network-auth/scim"
+)
+
+// SCIMAuthMiddleware authenticates identity providers calling the SCIM API
+// with the expected bearer token. Every request is refused when it is empty.
+func SCIMAuthMiddleware(expected string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := GetAuthTokenFromRequest(c)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			c.Header("Content-Type", scim.ContentType)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, scim.NewError(http.StatusUnauthorized, "", "invalid bearer token"))
+			return
+		}
+
+		c.Next()
+	}
+}
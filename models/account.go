@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"gorm.io/gorm"
+)
+
+// DeleteAccount soft-deletes the user's account after confirming their
+// password. The account can be restored until the deletion grace period
+// ends, after which it is purged.
+func (user *User) DeleteAccount(
+	ctx context.Context,
+	store Store,
+	mailer utils.Mailer,
+	cache TokenCache,
+	cfg *config.AccountConfig,
+	userID uuid.UUID,
+	password string,
+) *errors.ApiError {
+	dbUser, err := store.Users().FindByID(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.BadRequestError("user not found")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	if err := user.confirmPassword(ctx, password); err != nil {
+		return err
+	}
+
+	purgeAt := time.Now().UTC().Add(cfg.DeletionGracePeriod)
+	user.ScheduledPurgeAt = &purgeAt
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Save(ctx, user); err != nil {
+			return err
+		}
+
+		return deactivateUser(ctx, store, cache, userID)
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	// The account is already deleted at this point, so a failing email must
+	// not turn the request into an error.
+	if err := user.sendAccountDeletionEmail(ctx, mailer); err != nil {
+		log.Printf("failed to send account deletion email to user %v: %v", userID, err)
+	}
+
+	return nil
+}
+
+// RestoreAccount reactivates an account deleted by its owner, as long as its
+// grace period has not ended yet.
+func (user *User) RestoreAccount(ctx context.Context, store Store, username string, password string) *errors.ApiError {
+	dbUser, err := store.Users().FindByUsernameWithDeleted(ctx, utils.CanonicalizeUsername(username))
+	if err != nil && !utils.IsRecordNotFound(err) {
+		return errors.InternalServerError(err.Error())
+	}
+	if err != nil || dbUser.ScheduledPurgeAt == nil || !dbUser.ScheduledPurgeAt.After(time.Now().UTC()) {
+		return errors.BadRequestError("no restorable account found for user %s", username)
+	}
+	*user = *dbUser
+
+	if err := user.confirmPassword(ctx, password); err != nil {
+		return err
+	}
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		return reactivateUser(ctx, store, user.ID)
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	user.DeletedAt = gorm.DeletedAt{}
+	user.ScheduledPurgeAt = nil
+
+	return nil
+}
+
+// PurgeDeletedAccounts permanently deletes accounts whose grace period has
+// ended, at most batchSize per transaction, and returns how many were purged.
+func (user *User) PurgeDeletedAccounts(ctx context.Context, store Store, batchSize int) (int, error) {
+	purged := 0
+	for {
+		userIDs, err := store.Users().FindScheduledForPurge(ctx, time.Now().UTC(), batchSize)
+		if err != nil {
+			return purged, err
+		}
+
+		if len(userIDs) == 0 {
+			return purged, nil
+		}
+
+		err = store.WithTransaction(ctx, func(ctx context.Context) error {
+			for _, id := range userIDs {
+				if err := purgeUser(ctx, store, id); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+
+		purged += len(userIDs)
+		if len(userIDs) < batchSize {
+			return purged, nil
+		}
+	}
+}
+
+// confirmPassword checks the password of an account that logs in with a
+// local password. Accounts managed by an external identity provider have no
+// password we could check.
+func (user *User) confirmPassword(ctx context.Context, password string) *errors.ApiError {
+	if user.AuthProvider != LocalAuthProvider && user.AuthProvider != SCIMAuthProvider {
+		return errors.BadRequestError("account is managed by an external identity provider")
+	}
+
+	if err := utils.ComparePassword(ctx, user.Password, password); err != nil {
+		return errors.BadRequestError("invalid password")
+	}
+
+	return nil
+}
+
+func (user *User) sendAccountDeletionEmail(ctx context.Context, mailer utils.Mailer) *errors.ApiError {
+	data := struct {
+		Username  string
+		PurgeDate string
+	}{
+		Username:  user.Username,
+		PurgeDate: user.ScheduledPurgeAt.Format("January 2, 2006"),
+	}
+
+	body, err := parseEmailTemplate(ctx, "account_deletion", data)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return mailer.SendEmail(ctx, user.Email, "Your account has been deleted", body)
+}
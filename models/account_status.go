@@ -0,0 +1,248 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+type AccountStatus string
+
+const (
+	AccountPending   AccountStatus = "PENDING"
+	AccountActive    AccountStatus = "ACTIVE"
+	AccountSuspended AccountStatus = "SUSPENDED"
+	AccountBanned    AccountStatus = "BANNED"
+)
+
+// Error codes returned when a non-active account tries to authenticate.
+const (
+	AccountPendingErrorCode   = "account_pending"
+	AccountSuspendedErrorCode = "account_suspended"
+	AccountBannedErrorCode    = "account_banned"
+)
+
+// accountStatusTransitions lists the statuses each status can change to.
+// Suspended accounts can be suspended again to change the end of the suspension.
+var accountStatusTransitions = map[AccountStatus][]AccountStatus{
+	AccountPending:   {AccountActive, AccountBanned},
+	AccountActive:    {AccountSuspended, AccountBanned},
+	AccountSuspended: {AccountActive, AccountSuspended, AccountBanned},
+	AccountBanned:    {AccountActive},
+}
+
+// AccountStatusChange records a change of a user's status and who made it.
+// ActorID is nil for changes made by the system, e.g. a suspension ending.
+type AccountStatusChange struct {
+	ID             uuid.UUID     `json:"id" gorm:"primary_key"`
+	UserID         uuid.UUID     `json:"user_id" gorm:"not null"`
+	OldStatus      AccountStatus `json:"old_status" gorm:"not null"`
+	NewStatus      AccountStatus `json:"new_status" gorm:"not null"`
+	Reason         string        `json:"reason"`
+	SuspendedUntil *time.Time    `json:"suspended_until,omitempty"`
+	ActorID        *uuid.UUID    `json:"actor_id"`
+	CreatedAt      time.Time     `json:"created_at" gorm:"not null;autoCreateTime:false"`
+}
+
+// SuspendUser suspends the user until suspendedUntil, or indefinitely if it
+// is nil, and revokes all their tokens.
+func (user *User) SuspendUser(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID, actorID uuid.UUID, reason string, suspendedUntil *time.Time) *errors.ApiError {
+	if suspendedUntil != nil && !suspendedUntil.After(time.Now().UTC()) {
+		return errors.BadRequestError("suspension end must be in the future")
+	}
+
+	return user.changeStatus(ctx, store, cache, userID, &actorID, AccountSuspended, reason, suspendedUntil)
+}
+
+// BanUser permanently bans the user and revokes all their tokens.
+func (user *User) BanUser(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID, actorID uuid.UUID, reason string) *errors.ApiError {
+	return user.changeStatus(ctx, store, cache, userID, &actorID, AccountBanned, reason, nil)
+}
+
+// ReinstateUser makes a pending, suspended or banned user active again.
+func (user *User) ReinstateUser(ctx context.Context, store Store, userID uuid.UUID, actorID uuid.UUID, reason string) *errors.ApiError {
+	return user.changeStatus(ctx, store, nil, userID, &actorID, AccountActive, reason, nil)
+}
+
+func (user *User) GetStatusHistory(ctx context.Context, store Store, userID uuid.UUID) ([]AccountStatusChange, *errors.ApiError) {
+	if _, err := store.Users().FindByIDWithDeleted(ctx, userID); err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, errors.NotFoundError("user %v not found", userID)
+		}
+
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	changes, err := store.AccountStatusChanges().FindByUser(ctx, userID)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return changes, nil
+}
+
+// CheckStatus fails with a specific error code unless the user is active.
+// Suspensions that have ended are lifted on the way.
+func (user *User) CheckStatus(ctx context.Context, store Store) *errors.ApiError {
+	if user.Status == AccountSuspended && user.SuspendedUntil != nil && !user.SuspendedUntil.After(time.Now().UTC()) {
+		if err := user.liftExpiredSuspension(ctx, store); err != nil {
+			return err
+		}
+	}
+
+	switch user.Status {
+	case AccountPending:
+		return errors.ForbiddenError(AccountPendingErrorCode, "account is pending activation")
+	case AccountSuspended:
+		if user.SuspendedUntil != nil {
+			return errors.ForbiddenError(AccountSuspendedErrorCode, "account is suspended until %v", user.SuspendedUntil.Format(time.RFC3339))
+		}
+		return errors.ForbiddenError(AccountSuspendedErrorCode, "account is suspended")
+	case AccountBanned:
+		return errors.ForbiddenError(AccountBannedErrorCode, "account is banned")
+	}
+
+	return nil
+}
+
+// CheckUserStatus loads the user and checks their status, see CheckStatus.
+func (user *User) CheckUserStatus(ctx context.Context, store Store, userID uuid.UUID) *errors.ApiError {
+	dbUser, err := store.Users().FindByID(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.BadRequestError("user not found")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	return user.CheckStatus(ctx, store)
+}
+
+// liftExpiredSuspension makes the user active again and reloads them. Only
+// the first of concurrent requests of the user lifts the suspension and
+// records the change; the others find it lifted, or changed by an admin in
+// the meantime.
+func (user *User) liftExpiredSuspension(ctx context.Context, store Store) *errors.ApiError {
+	const reason = "suspension ended"
+
+	now := time.Now().UTC()
+	change := AccountStatusChange{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		OldStatus: AccountSuspended,
+		NewStatus: AccountActive,
+		Reason:    reason,
+		CreatedAt: now,
+	}
+
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		lifted, err := store.Users().LiftExpiredSuspension(ctx, user.ID, reason, now)
+		if err != nil || !lifted {
+			return err
+		}
+
+		return store.AccountStatusChanges().Create(ctx, &change)
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	dbUser, err := store.Users().FindByID(ctx, user.ID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("user %v not found", user.ID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	return nil
+}
+
+// changeStatus only uses cache to revoke the tokens of suspended and banned
+// users, so it may be nil when activating a user.
+func (user *User) changeStatus(
+	ctx context.Context,
+	store Store,
+	cache TokenCache,
+	userID uuid.UUID,
+	actorID *uuid.UUID,
+	status AccountStatus,
+	reason string,
+	suspendedUntil *time.Time,
+) *errors.ApiError {
+	if actorID != nil && *actorID == userID {
+		return errors.BadRequestError("can not change the status of your own account")
+	}
+
+	dbUser, err := store.Users().FindByID(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("user %v not found", userID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	if !canChangeStatus(user.Status, status) {
+		return errors.BadRequestError("can not change status from %v to %v", user.Status, status)
+	}
+
+	now := time.Now().UTC()
+	change := AccountStatusChange{
+		ID:             uuid.New(),
+		UserID:         userID,
+		OldStatus:      user.Status,
+		NewStatus:      status,
+		Reason:         reason,
+		SuspendedUntil: suspendedUntil,
+		ActorID:        actorID,
+		CreatedAt:      now,
+	}
+
+	user.Status = status
+	user.StatusReason = reason
+	user.SuspendedUntil = suspendedUntil
+	user.UpdatedAt = now
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Save(ctx, user); err != nil {
+			return err
+		}
+
+		if err := store.AccountStatusChanges().Create(ctx, &change); err != nil {
+			return err
+		}
+
+		if status == AccountSuspended || status == AccountBanned {
+			token := &Token{}
+			if err := token.RevokeUserActiveTokens(ctx, store, cache, userID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+func canChangeStatus(from AccountStatus, to AccountStatus) bool {
+	for _, s := range accountStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package models_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+)
+
+func TestCheckStatusLiftsExpiredSuspensionOnce(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+
+	suspendedUntil := time.Now().UTC().Add(-time.Minute)
+	user := models.User{
+		ID:                uuid.New(),
+		Username:          "alice",
+		UsernameCanonical: "alice",
+		Email:             "alice@example.com",
+		EmailCanonical:    "alice@example.com",
+		Level:             models.BRONZE,
+		Status:            models.AccountSuspended,
+		SuspendedUntil:    &suspendedUntil,
+		AuthProvider:      models.LocalAuthProvider,
+		CreatedAt:         time.Now().UTC(),
+		UpdatedAt:         time.Now().UTC(),
+	}
+	if err := store.Users().Create(ctx, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each request loaded the user while they were still suspended.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(u models.User) {
+			defer wg.Done()
+
+			if err := u.CheckStatus(ctx, store); err != nil {
+				t.Errorf("CheckStatus() error = %v", err)
+			}
+			if u.Status != models.AccountActive {
+				t.Errorf("CheckStatus() user status = %v, want %v", u.Status, models.AccountActive)
+			}
+		}(user)
+	}
+	wg.Wait()
+
+	changes, err := store.AccountStatusChanges().FindByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].OldStatus != models.AccountSuspended || changes[0].NewStatus != models.AccountActive {
+		t.Errorf("status changes = %+v, want the suspension lifted once", changes)
+	}
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"gorm.io/gorm"
+)
+
+// UserFilter narrows down the users listed to admins. Zero fields do not filter.
+type UserFilter struct {
+	Username      string
+	Email         string
+	Level         Level
+	Status        AccountStatus
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// Deleted filters on whether users are soft-deleted. Nil lists both.
+	Deleted *bool
+}
+
+// ListUsers returns up to limit users matching the filter, oldest first,
+// starting after cursor. The returned cursor is empty on the last page.
+func (user *User) ListUsers(ctx context.Context, store Store, filter UserFilter, cursor string, limit int) ([]User, string, *errors.ApiError) {
+	var after *ListPosition
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", errors.BadRequestError("invalid cursor")
+		}
+		after = &ListPosition{CreatedAt: createdAt, ID: id}
+	}
+
+	// One more user than asked for tells whether there is a next page.
+	users, err := store.Users().List(ctx, filter, after, limit+1)
+	if err != nil {
+		return nil, "", errors.InternalServerError(err.Error())
+	}
+
+	if len(users) <= limit {
+		return users, "", nil
+	}
+
+	users = users[:limit]
+	last := users[len(users)-1]
+	return users, encodeCursor(last.CreatedAt, last.ID), nil
+}
+
+// ForceLogout revokes all active tokens of the user.
+func (user *User) ForceLogout(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID) *errors.ApiError {
+	if err := user.loadWithDeleted(ctx, store, userID); err != nil {
+		return err
+	}
+
+	token := &Token{}
+	return token.RevokeUserActiveTokens(ctx, store, cache, userID)
+}
+
+// SendPasswordResetForUser creates a password reset token for the user and
+// emails it to them.
+func (user *User) SendPasswordResetForUser(ctx context.Context, store Store, mailer utils.Mailer, cfg *config.TokenConfig, userID uuid.UUID) *errors.ApiError {
+	dbUser, err := store.Users().FindByID(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("user %v not found", userID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	if user.AuthProvider != LocalAuthProvider && user.AuthProvider != SCIMAuthProvider {
+		return errors.BadRequestError("password of %v users is managed by their identity provider", user.AuthProvider)
+	}
+
+	t := PasswordResetToken{}
+	token, apiErr := t.CreateResetToken(ctx, store, cfg, userID)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	return user.SendResetPasswordEmail(ctx, mailer, user.Email, token.Token)
+}
+
+// RestoreUser undoes the soft deletion of the user, including a pending
+// self-deletion.
+func (user *User) RestoreUser(ctx context.Context, store Store, userID uuid.UUID) *errors.ApiError {
+	if err := user.loadWithDeleted(ctx, store, userID); err != nil {
+		return err
+	}
+
+	if !user.DeletedAt.Valid {
+		return errors.BadRequestError("user %v is not deleted", userID)
+	}
+
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		return reactivateUser(ctx, store, userID)
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	user.DeletedAt = gorm.DeletedAt{}
+	user.ScheduledPurgeAt = nil
+	return nil
+}
+
+// CreateAdmin registers a local user with admin rights. It is meant for
+// operators setting up the service, so the registration policy does not
+// apply.
+func (user *User) CreateAdmin(ctx context.Context, store Store, username string, password string, email string) *errors.ApiError {
+	var apiErr *errors.ApiError
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		if apiErr = user.Register(ctx, store, username, password, email, ""); apiErr != nil {
+			return apiErr
+		}
+
+		user.IsAdmin = true
+		return store.Users().Save(ctx, user)
+	})
+	if apiErr != nil {
+		return apiErr
+	}
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// loadWithDeleted loads the user, soft-deleted or not.
+func (user *User) loadWithDeleted(ctx context.Context, store Store, userID uuid.UUID) *errors.ApiError {
+	dbUser, err := store.Users().FindByIDWithDeleted(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("user %v not found", userID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	return nil
+}
+
+// encodeCursor encodes the position after a row in a list ordered by
+// created_at and id.
+func encodeCursor(createdAt time.Time, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(createdAt.Format(time.RFC3339Nano) + "|" + id.String()))
+}
+
+func decodeCursor(cursor string) (time.Time, uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	createdAt, id, found := strings.Cut(string(raw), "|")
+	if !found {
+		return time.Time{}, uuid.Nil, fmt.Errorf("malformed cursor")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	u, err := uuid.Parse(id)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	return t, u, nil
+}
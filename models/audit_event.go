@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+)
+
+const (
+	AuditUserRegistered       = "user.registered"
+	AuditLogin                = "auth.login"
+	AuditLogout               = "auth.logout"
+	AuditPasswordUpdated      = "password.updated"
+	AuditPasswordReset        = "password.reset"
+	AuditLevelUpdated         = "level.updated"
+	AuditProfileCreated       = "profile.created"
+	AuditProfileUpdated       = "profile.updated"
+	AuditImpersonationStarted = "impersonation.started"
+	AuditImpersonatedRequest  = "impersonation.request"
+)
+
+const (
+	AuditOutcomeSuccess = "SUCCESS"
+	AuditOutcomeFailure = "FAILURE"
+)
+
+// AuditEvent is an entry of the security audit log. ActorID is who performed
+// the action and TargetUserID whose account it concerned; either can be nil.
+// User IDs are kept as they are after the users are purged. Events can not
+// be changed or deleted once written.
+type AuditEvent struct {
+	ID           uuid.UUID      `json:"id" gorm:"primary_key"`
+	Action       string         `json:"action" gorm:"not null"`
+	Outcome      string         `json:"outcome" gorm:"not null"`
+	ActorID      *uuid.UUID     `json:"actor_id"`
+	TargetUserID *uuid.UUID     `json:"target_user_id"`
+	IPAddress    string         `json:"ip_address"`
+	UserAgent    string         `json:"user_agent"`
+	RequestID    string         `json:"request_id"`
+	Details      map[string]any `json:"details,omitempty" gorm:"serializer:json"`
+	CreatedAt    time.Time      `json:"created_at" gorm:"not null;autoCreateTime:false"`
+}
+
+// AuditEventFilter narrows down the audit events listed. Zero fields do not filter.
+type AuditEventFilter struct {
+	ActorID      *uuid.UUID
+	TargetUserID *uuid.UUID
+	Action       string
+	Outcome      string
+	From         *time.Time
+	To           *time.Time
+}
+
+func RecordAuditEvent(ctx context.Context, store Store, event *AuditEvent) error {
+	event.ID = uuid.New()
+	event.CreatedAt = time.Now().UTC()
+	return store.AuditEvents().Create(ctx, event)
+}
+
+// ListAuditEvents returns up to limit events matching the filter, most
+// recent first, starting after cursor. The returned cursor is empty on the
+// last page.
+func (e *AuditEvent) ListAuditEvents(ctx context.Context, store Store, filter AuditEventFilter, cursor string, limit int) ([]AuditEvent, string, *errors.ApiError) {
+	var before *ListPosition
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, "", errors.BadRequestError("invalid cursor")
+		}
+		before = &ListPosition{CreatedAt: createdAt, ID: id}
+	}
+
+	// One more event than asked for tells whether there is a next page.
+	events, err := store.AuditEvents().Find(ctx, filter, before, limit+1)
+	if err != nil {
+		return nil, "", errors.InternalServerError(err.Error())
+	}
+
+	if len(events) <= limit {
+		return events, "", nil
+	}
+
+	events = events[:limit]
+	last := events[len(events)-1]
+	return events, encodeCursor(last.CreatedAt, last.ID), nil
+}
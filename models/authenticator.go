@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+const (
+	LocalAuthProvider = "local"
+	LDAPAuthProvider  = "ldap"
+	SAMLAuthProvider  = "saml"
+)
+
+// Authenticator verifies a username and password pair and returns the
+// matching local user.
+type Authenticator interface {
+	Authenticate(ctx context.Context, store Store, username string, password string) (*User, *errors.ApiError)
+}
+
+// NewAuthenticator builds the authenticator chain from the configured
+// authentication backends. Users provisioned on their first login must
+// follow policy.
+func NewAuthenticator(cfg *config.Config, policy *validators.RegistrationPolicy) (Authenticator, error) {
+	var chain ChainAuthenticator
+	for _, backend := range cfg.Auth.Backends {
+		switch backend {
+		case "database":
+			chain = append(chain, &DatabaseAuthenticator{})
+		case "ldap":
+			chain = append(chain, &LDAPAuthenticator{Config: &cfg.LDAP, Policy: policy})
+		default:
+			return nil, fmt.Errorf("unknown authentication backend %q", backend)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no authentication backend configured")
+	}
+
+	return chain, nil
+}
+
+// ChainAuthenticator tries each authenticator in order and returns the first
+// successful result. If all of them fail, the error of the first one is returned.
+type ChainAuthenticator []Authenticator
+
+func (c ChainAuthenticator) Authenticate(ctx context.Context, store Store, username string, password string) (*User, *errors.ApiError) {
+	var firstErr *errors.ApiError
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, store, username, password)
+		if err == nil {
+			return user, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, firstErr
+}
+
+type DatabaseAuthenticator struct{}
+
+func (a *DatabaseAuthenticator) Authenticate(ctx context.Context, store Store, username string, password string) (*User, *errors.ApiError) {
+	dbUser, err := store.Users().FindByUsername(ctx, utils.CanonicalizeUsername(username))
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, errors.BadRequestError("user %s not found", username)
+		}
+
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	// Users pushed through SCIM may be given a password by their identity provider.
+	if dbUser.AuthProvider != LocalAuthProvider && dbUser.AuthProvider != SCIMAuthProvider {
+		return nil, errors.BadRequestError("user %s not found", username)
+	}
+
+	if err := utils.ComparePassword(ctx, dbUser.Password, password); err != nil {
+		return nil, errors.BadRequestError("invalid password")
+	}
+
+	return dbUser, nil
+}
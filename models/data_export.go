@@ -0,0 +1,368 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+const (
+	DataExportPending    = "PENDING"
+	DataExportProcessing = "PROCESSING"
+	DataExportCompleted  = "COMPLETED"
+	DataExportFailed     = "FAILED"
+
+	DataExportJSON = "json"
+	DataExportZIP  = "zip"
+)
+
+type DataExport struct {
+	ID            uuid.UUID  `json:"id" gorm:"primary_key"`
+	UserID        uuid.UUID  `json:"user_id" gorm:"not null"`
+	Format        string     `json:"format" gorm:"not null"`
+	Status        string     `json:"status" gorm:"not null"`
+	Content       []byte     `json:"-"`
+	DownloadToken *string    `json:"-"`
+	Error         *string    `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"not null;autoCreateTime:false"`
+	StartedAt     *time.Time `json:"-"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// exportSection collects one part of a user's personal data. Every kind of
+// personal data kept should have a section here.
+type exportSection struct {
+	Name    string
+	Collect func(ctx context.Context, store Store, userID uuid.UUID) (any, error)
+}
+
+var exportSections = []exportSection{
+	{Name: "user", Collect: collectUserData},
+	{Name: "profile", Collect: collectProfileData},
+	{Name: "sessions", Collect: collectSessionData},
+	{Name: "password_reset_requests", Collect: collectPasswordResetData},
+	{Name: "email_change_requests", Collect: collectEmailChangeData},
+	{Name: "username_history", Collect: collectUsernameHistoryData},
+	{Name: "invite_codes", Collect: collectInviteCodeData},
+	{Name: "account_status_changes", Collect: collectAccountStatusData},
+	{Name: "security_activity", Collect: collectAuditEventData},
+}
+
+// auditEventExportPageSize is how many audit events are loaded at a time
+// when collecting the security activity of a user.
+const auditEventExportPageSize = 500
+
+// RequestExport queues a data export for the user, which is generated in
+// the background by GeneratePendingDataExports. Only one export per user can
+// be in progress at a time.
+func (e *DataExport) RequestExport(ctx context.Context, store Store, userID uuid.UUID, format string) *errors.ApiError {
+	inProgress, err := store.DataExports().ExistsInProgress(ctx, userID)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if inProgress {
+		return errors.BadRequestError("a data export is already in progress")
+	}
+
+	e.ID = uuid.New()
+	e.UserID = userID
+	e.Format = format
+	e.Status = DataExportPending
+	e.CreatedAt = time.Now().UTC()
+	if err := store.DataExports().Create(ctx, e); err != nil {
+		// The unique index catches exports requested between the check
+		// above and the insert.
+		if utils.IsUniqueViolation(err) {
+			return errors.BadRequestError("a data export is already in progress")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// GeneratePendingDataExports claims up to limit pending exports, generates
+// them and returns how many it claimed. Exports that fail are marked as
+// failed rather than returned as errors.
+func GeneratePendingDataExports(ctx context.Context, store Store, cfg *config.DataExportConfig, limit int) (int, error) {
+	var exports []DataExport
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		pending, err := store.DataExports().FindPending(ctx, limit)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		now := time.Now().UTC()
+		ids := make([]uuid.UUID, 0, len(pending))
+		for i := range pending {
+			ids = append(ids, pending[i].ID)
+			pending[i].Status = DataExportProcessing
+			pending[i].StartedAt = &now
+		}
+		if err := store.DataExports().MarkProcessing(ctx, ids, now); err != nil {
+			return err
+		}
+
+		exports = pending
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range exports {
+		exports[i].generate(ctx, store, cfg.Expiration)
+	}
+
+	return len(exports), nil
+}
+
+// ReclaimStaleDataExports makes the exports that have been processing for
+// longer than timeout pending again, so that another worker generates the
+// exports of a worker that died, and returns how many there were.
+func ReclaimStaleDataExports(ctx context.Context, store Store, timeout time.Duration) (int, error) {
+	return store.DataExports().ResetStale(ctx, time.Now().UTC().Add(-timeout))
+}
+
+func (e *DataExport) GetExport(ctx context.Context, store Store, userID uuid.UUID, exportID uuid.UUID) *errors.ApiError {
+	export, err := store.DataExports().FindByID(ctx, exportID)
+	if err != nil && !utils.IsRecordNotFound(err) {
+		return errors.InternalServerError(err.Error())
+	}
+	if err != nil || export.UserID != userID {
+		return errors.NotFoundError("data export %v not found", exportID)
+	}
+	*e = *export
+
+	return nil
+}
+
+// GetDownload loads a completed export by its download token. Download links
+// stop working once the export expires.
+func (e *DataExport) GetDownload(ctx context.Context, store Store, exportID uuid.UUID, downloadToken string) *errors.ApiError {
+	export, err := store.DataExports().FindByID(ctx, exportID)
+	if err != nil && !utils.IsRecordNotFound(err) {
+		return errors.InternalServerError(err.Error())
+	}
+	if err != nil || export.Status != DataExportCompleted || export.DownloadToken == nil ||
+		subtle.ConstantTimeCompare([]byte(*export.DownloadToken), []byte(downloadToken)) != 1 {
+		return errors.NotFoundError("data export %v not found", exportID)
+	}
+	*e = *export
+
+	if e.ExpiresAt == nil || time.Now().UTC().After(*e.ExpiresAt) {
+		return errors.BadRequestError("download link has expired")
+	}
+
+	return nil
+}
+
+func (e *DataExport) FileName() string {
+	return "personal-data-" + e.CreatedAt.Format("20060102150405") + "." + e.Format
+}
+
+func (e *DataExport) ContentType() string {
+	if e.Format == DataExportZIP {
+		return "application/zip"
+	}
+
+	return "application/json"
+}
+
+func (e *DataExport) generate(ctx context.Context, store Store, expiration time.Duration) {
+	content, err := e.build(ctx, store)
+	if err != nil {
+		e.fail(ctx, store, err)
+		return
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		e.fail(ctx, store, err)
+		return
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(expiration)
+	e.Status = DataExportCompleted
+	e.Content = content
+	e.DownloadToken = &token
+	e.CompletedAt = &now
+	e.ExpiresAt = &expiresAt
+	if err := store.DataExports().Save(ctx, e); err != nil {
+		e.fail(ctx, store, err)
+	}
+}
+
+func (e *DataExport) fail(ctx context.Context, store Store, cause error) {
+	log.Printf("data export %v failed: %v", e.ID, cause)
+
+	message := cause.Error()
+	e.Status = DataExportFailed
+	e.Content = nil
+	e.DownloadToken = nil
+	e.Error = &message
+	if err := store.DataExports().Save(ctx, e); err != nil {
+		log.Printf("failed to mark data export %v as failed: %v", e.ID, err)
+	}
+}
+
+func (e *DataExport) build(ctx context.Context, store Store) ([]byte, error) {
+	data := make(map[string]any, len(exportSections))
+	for _, section := range exportSections {
+		value, err := section.Collect(ctx, store, e.UserID)
+		if err != nil {
+			return nil, err
+		}
+		data[section.Name] = value
+	}
+
+	if e.Format != DataExportZIP {
+		return json.MarshalIndent(data, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, section := range exportSections {
+		content, err := json.MarshalIndent(data[section.Name], "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := w.Create(section.Name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func collectUserData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	return store.Users().FindByIDWithDeleted(ctx, userID)
+}
+
+func collectProfileData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	return getProfileWithDeleted(ctx, store, userID)
+}
+
+func collectSessionData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	type session struct {
+		ID        uuid.UUID `json:"id"`
+		IssuedAt  time.Time `json:"issued_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	tokens, err := store.Tokens().FindByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, session{ID: t.ID, IssuedAt: t.IssuedAt, ExpiresAt: t.ExpiresAt})
+	}
+
+	return sessions, nil
+}
+
+func collectPasswordResetData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	type passwordResetRequest struct {
+		ID          uuid.UUID `json:"id"`
+		CreatedAt   time.Time `json:"created_at"`
+		TokenExpiry time.Time `json:"token_expiry"`
+	}
+
+	tokens, err := store.PasswordResetTokens().FindByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]passwordResetRequest, 0, len(tokens))
+	for _, t := range tokens {
+		requests = append(requests, passwordResetRequest{ID: t.ID, CreatedAt: t.CreatedAt, TokenExpiry: t.TokenExpiry})
+	}
+
+	return requests, nil
+}
+
+func collectEmailChangeData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	type emailChangeRequest struct {
+		ID          uuid.UUID  `json:"id"`
+		OldEmail    string     `json:"old_email"`
+		NewEmail    string     `json:"new_email"`
+		CreatedAt   time.Time  `json:"created_at"`
+		ConfirmedAt *time.Time `json:"confirmed_at"`
+		CancelledAt *time.Time `json:"cancelled_at"`
+	}
+
+	changes, err := store.EmailChangeRequests().FindByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]emailChangeRequest, 0, len(changes))
+	for _, r := range changes {
+		requests = append(requests, emailChangeRequest{
+			ID:          r.ID,
+			OldEmail:    r.OldEmail,
+			NewEmail:    r.NewEmail,
+			CreatedAt:   r.CreatedAt,
+			ConfirmedAt: r.ConfirmedAt,
+			CancelledAt: r.CancelledAt,
+		})
+	}
+
+	return requests, nil
+}
+
+func collectUsernameHistoryData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	return store.UsernameHistory().FindByUser(ctx, userID)
+}
+
+func collectInviteCodeData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	return store.InviteCodes().FindByCreator(ctx, userID)
+}
+
+func collectAccountStatusData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	return store.AccountStatusChanges().FindByUser(ctx, userID)
+}
+
+// collectAuditEventData returns the events targeting the user, most recent
+// first.
+func collectAuditEventData(ctx context.Context, store Store, userID uuid.UUID) (any, error) {
+	filter := AuditEventFilter{TargetUserID: &userID}
+
+	var events []AuditEvent
+	var before *ListPosition
+	for {
+		page, err := store.AuditEvents().Find(ctx, filter, before, auditEventExportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, page...)
+
+		if len(page) < auditEventExportPageSize {
+			return events, nil
+		}
+		last := page[len(page)-1]
+		before = &ListPosition{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+// EmailChangeRequest is a pending change of a user's email. The change is
+// applied once the new address is confirmed, and can be cancelled from the
+// old address until then.
+type EmailChangeRequest struct {
+	ID                 uuid.UUID `gorm:"primary_key"`
+	UserID             uuid.UUID `gorm:"not null"`
+	OldEmail           string    `gorm:"not null"`
+	NewEmail           string    `gorm:"not null"`
+	ConfirmToken       string    `gorm:"not null"`
+	CancelToken        string    `gorm:"not null"`
+	RequestedByTokenID *uuid.UUID
+	TokenExpiry        time.Time `gorm:"not null"`
+	CreatedAt          time.Time `gorm:"not null;autoCreateTime:false"`
+	ConfirmedAt        *time.Time
+	CancelledAt        *time.Time
+}
+
+// RequestEmailChange starts changing the user's email to newEmail and sends
+// a confirmation link to the new address and a cancel link to the old one.
+// Any earlier pending request of the user is cancelled.
+func (r *EmailChangeRequest) RequestEmailChange(
+	ctx context.Context,
+	store Store,
+	mailer utils.Mailer,
+	cfg *config.Config,
+	userID uuid.UUID,
+	currentTokenString string,
+	currentPassword string,
+	newEmail string,
+) *errors.ApiError {
+	user, err := store.Users().FindByID(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.BadRequestError("user not found")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+
+	if err := user.confirmPassword(ctx, currentPassword); err != nil {
+		return err
+	}
+
+	if user.EmailCanonical == utils.CanonicalizeEmail(newEmail) {
+		return errors.BadRequestError("new email can not be the same as current one")
+	}
+
+	if err := checkEmailAvailable(ctx, store, newEmail, userID); err != nil {
+		return err
+	}
+
+	confirmToken, err := generateResetToken()
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	cancelToken, err := generateResetToken()
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	t := Token{}
+	currentToken, apiErr := t.GetByTokenString(ctx, store, currentTokenString)
+	if apiErr != nil {
+		return apiErr
+	}
+
+	r.ID = uuid.New()
+	r.UserID = userID
+	r.OldEmail = user.Email
+	r.NewEmail = newEmail
+	r.ConfirmToken = confirmToken
+	r.CancelToken = cancelToken
+	r.RequestedByTokenID = &currentToken.ID
+	r.TokenExpiry = time.Now().UTC().Add(cfg.Tokens.EmailChangeExpiration)
+	r.CreatedAt = time.Now().UTC()
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.EmailChangeRequests().CancelPendingByUser(ctx, userID, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		return store.EmailChangeRequests().Create(ctx, r)
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return r.sendEmails(ctx, mailer, cfg.App.BaseURL, user)
+}
+
+// ConfirmEmailChange applies the change identified by confirmToken. The
+// request must still be pending and the new email allowed by the
+// registration policy and free when the change is applied, and all sessions
+// of the user except the one that requested the change are revoked.
+func (r *EmailChangeRequest) ConfirmEmailChange(
+	ctx context.Context,
+	store Store,
+	cache TokenCache,
+	policy *validators.RegistrationPolicy,
+	confirmToken string,
+) *errors.ApiError {
+	request, err := store.EmailChangeRequests().FindPendingByConfirmToken(ctx, confirmToken, time.Now().UTC())
+	if err != nil {
+		return pendingEmailChangeError(err)
+	}
+	*r = *request
+
+	now := time.Now().UTC()
+	var apiErr *errors.ApiError
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		// Confirming first keeps a concurrent cancellation out until the
+		// transaction ends, and fails if one got in since the lookup.
+		confirmed, err := store.EmailChangeRequests().Confirm(ctx, r.ID, now)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			apiErr = errors.BadRequestError("invalid or expired token")
+			return apiErr
+		}
+
+		if errs := policy.ValidateEmail("new_email", r.NewEmail); len(errs) > 0 {
+			apiErr = errors.BadRequestError("%s: %s", errs[0].Field, errs[0].Message)
+			return apiErr
+		}
+		if apiErr = checkEmailAvailable(ctx, store, r.NewEmail, r.UserID); apiErr != nil {
+			return apiErr
+		}
+
+		user, err := store.Users().FindByID(ctx, r.UserID)
+		if err != nil {
+			return err
+		}
+
+		user.Email = r.NewEmail
+		user.EmailCanonical = utils.CanonicalizeEmail(r.NewEmail)
+		user.UpdatedAt = now
+		if err := store.Users().Save(ctx, user); err != nil {
+			return err
+		}
+
+		exceptTokenID := uuid.Nil
+		if r.RequestedByTokenID != nil {
+			exceptTokenID = *r.RequestedByTokenID
+		}
+
+		token := &Token{}
+		if err := token.RevokeUserActiveTokensExcept(ctx, store, cache, r.UserID, exceptTokenID); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if apiErr != nil {
+		return apiErr
+	}
+	if err != nil {
+		// The unique constraint catches addresses taken by transactions
+		// that run at the same time.
+		if utils.IsUniqueViolation(err) {
+			return errors.ConflictError("email already exists")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	r.ConfirmedAt = &now
+
+	return nil
+}
+
+// CancelEmailChange cancels the change identified by cancelToken, as long as
+// it was not confirmed in the meantime.
+func (r *EmailChangeRequest) CancelEmailChange(ctx context.Context, store Store, cancelToken string) *errors.ApiError {
+	request, err := store.EmailChangeRequests().FindPendingByCancelToken(ctx, cancelToken, time.Now().UTC())
+	if err != nil {
+		return pendingEmailChangeError(err)
+	}
+	*r = *request
+
+	now := time.Now().UTC()
+	cancelled, err := store.EmailChangeRequests().Cancel(ctx, r.ID, now)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if !cancelled {
+		return errors.BadRequestError("invalid or expired token")
+	}
+	r.CancelledAt = &now
+
+	return nil
+}
+
+func pendingEmailChangeError(err error) *errors.ApiError {
+	if utils.IsRecordNotFound(err) {
+		return errors.BadRequestError("invalid or expired token")
+	}
+
+	return errors.InternalServerError(err.Error())
+}
+
+func (r *EmailChangeRequest) sendEmails(ctx context.Context, mailer utils.Mailer, baseURL string, user *User) *errors.ApiError {
+	confirmation, err := parseEmailTemplate(ctx, "email_change_confirmation", map[string]string{
+		"Username":   user.Username,
+		"ConfirmURL": baseURL + "/?email_confirm_token=" + r.ConfirmToken,
+		"Expiry":     r.TokenExpiry.Format(time.RFC1123),
+		"BaseURL":    baseURL,
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if err := mailer.SendEmail(ctx, r.NewEmail, "Confirm your new email address", confirmation); err != nil {
+		return err
+	}
+
+	notice, err := parseEmailTemplate(ctx, "email_change_notice", map[string]string{
+		"Username":  user.Username,
+		"NewEmail":  r.NewEmail,
+		"CancelURL": baseURL + "/?email_cancel_token=" + r.CancelToken,
+		"BaseURL":   baseURL,
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return mailer.SendEmail(ctx, r.OldEmail, "Email change requested for your account", notice)
+}
+
+// checkEmailAvailable also looks at soft-deleted users, since the unique
+// constraint on users.email covers them too.
+func checkEmailAvailable(ctx context.Context, store Store, email string, userID uuid.UUID) *errors.ApiError {
+	taken, err := store.Users().ExistsWithUsernameOrEmail(ctx, "", utils.CanonicalizeEmail(email), userID)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if taken {
+		return errors.ConflictError("email already exists")
+	}
+
+	return nil
+}
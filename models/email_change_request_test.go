@@ -0,0 +1,121 @@
+package models_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+	"github.com/vantutran2k1/social-network-auth/tokencache"
+)
+
+// requestTestEmailChange registers alice and stores a pending change of her
+// email to newEmail.
+func requestTestEmailChange(t *testing.T, store models.Store, newEmail string) (*models.User, *models.EmailChangeRequest) {
+	t.Helper()
+	ctx := context.Background()
+
+	user := models.User{}
+	if err := user.Register(ctx, store, "alice", "password123", "alice@example.com", ""); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	request := models.EmailChangeRequest{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		OldEmail:     user.Email,
+		NewEmail:     newEmail,
+		ConfirmToken: "confirm-token",
+		CancelToken:  "cancel-token",
+		TokenExpiry:  time.Now().UTC().Add(time.Hour),
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := store.EmailChangeRequests().Create(ctx, &request); err != nil {
+		t.Fatal(err)
+	}
+
+	return &user, &request
+}
+
+func newTestTokenCache(t *testing.T) models.TokenCache {
+	t.Helper()
+	cache, err := tokencache.New(&config.TokenCacheConfig{Size: 10, LocalTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestConfirmEmailChangeAppliesTheRegistrationPolicy(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	// The domain was denied after the change was requested.
+	user, request := requestTestEmailChange(t, store, "alice@denied.example.com")
+
+	r := models.EmailChangeRequest{}
+	err := r.ConfirmEmailChange(ctx, store, newTestTokenCache(t), newTestPolicy(t, "denied.example.com"), request.ConfirmToken)
+	if err == nil || err.Code != http.StatusBadRequest {
+		t.Fatalf("ConfirmEmailChange() error = %v, want the policy violation", err)
+	}
+
+	dbUser, e := store.Users().FindByID(ctx, user.ID)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if dbUser.Email != user.Email {
+		t.Errorf("email = %s, want it unchanged", dbUser.Email)
+	}
+	if _, e := store.EmailChangeRequests().FindPendingByConfirmToken(ctx, request.ConfirmToken, time.Now().UTC()); e != nil {
+		t.Errorf("the request is no longer pending: %v", e)
+	}
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	user, request := requestTestEmailChange(t, store, "alice@new.example.com")
+
+	r := models.EmailChangeRequest{}
+	if err := r.ConfirmEmailChange(ctx, store, newTestTokenCache(t), newTestPolicy(t), request.ConfirmToken); err != nil {
+		t.Fatalf("ConfirmEmailChange() error = %v", err)
+	}
+	if r.ConfirmedAt == nil {
+		t.Error("ConfirmEmailChange() did not set ConfirmedAt")
+	}
+
+	dbUser, err := store.Users().FindByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dbUser.Email != request.NewEmail {
+		t.Errorf("email = %s, want %s", dbUser.Email, request.NewEmail)
+	}
+
+	c := models.EmailChangeRequest{}
+	if err := c.CancelEmailChange(ctx, store, request.CancelToken); err == nil || err.Code != http.StatusBadRequest {
+		t.Errorf("CancelEmailChange() error = %v after the confirmation, want invalid token", err)
+	}
+}
+
+func TestEmailChangeConfirmationAndCancellationExcludeEachOther(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	_, request := requestTestEmailChange(t, store, "alice@new.example.com")
+	if confirmed, err := store.EmailChangeRequests().Confirm(ctx, request.ID, request.TokenExpiry); err != nil || confirmed {
+		t.Errorf("Confirm() = %v, %v once expired, want false", confirmed, err)
+	}
+
+	// A confirmation that loaded the request before it was cancelled.
+	if cancelled, err := store.EmailChangeRequests().Cancel(ctx, request.ID, now); err != nil || !cancelled {
+		t.Fatalf("Cancel() = %v, %v, want true", cancelled, err)
+	}
+	if confirmed, err := store.EmailChangeRequests().Confirm(ctx, request.ID, now); err != nil || confirmed {
+		t.Errorf("Confirm() = %v, %v after Cancel(), want false", confirmed, err)
+	}
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+// ExternalIdentity describes a user asserted by an external identity provider.
+type ExternalIdentity struct {
+	Provider   string
+	ExternalID string
+	Username   string
+	Email      string
+	FirstName  string
+	LastName   string
+}
+
+// ProvisionExternalUser returns the local user linked to the given identity,
+// creating the user (and a profile, if names are known) on first use, as
+// long as the policy accepts their username and email.
+func (user *User) ProvisionExternalUser(ctx context.Context, store Store, policy *validators.RegistrationPolicy, identity ExternalIdentity) (*User, *errors.ApiError) {
+	dbUser, err := store.Users().FindByExternalID(ctx, identity.Provider, identity.ExternalID)
+	if err == nil {
+		return dbUser, nil
+	}
+	if !utils.IsRecordNotFound(err) {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	if identity.Email == "" {
+		return nil, errors.BadRequestError("external identity for %s has no email", identity.Username)
+	}
+
+	if err := checkRegistrationPolicy(policy, nil, identity.Username, identity.Email); err != nil {
+		return nil, err
+	}
+
+	taken, err := store.Users().ExistsWithUsernameOrEmail(ctx, utils.CanonicalizeUsername(identity.Username), utils.CanonicalizeEmail(identity.Email), uuid.Nil)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+	if taken {
+		return nil, errors.BadRequestError("username or email already exists")
+	}
+	if err := checkProvisionedUsername(ctx, store, identity.Username, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	// External users never log in with a local password, so store the hash
+	// of a random value that nobody knows.
+	password, err := generateUnusablePassword(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	externalID := identity.ExternalID
+	user.ID = uuid.New()
+	user.Username = identity.Username
+	user.Email = identity.Email
+	user.setCanonicalIdentity()
+	user.Password = password
+	user.Level = BRONZE
+	user.Status = AccountActive
+	user.AuthProvider = identity.Provider
+	user.ExternalID = &externalID
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = time.Now().UTC()
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Create(ctx, user); err != nil {
+			return err
+		}
+
+		if err := enqueueUserRegisteredEvent(ctx, store, user); err != nil {
+			return err
+		}
+
+		if identity.FirstName == "" && identity.LastName == "" {
+			return nil
+		}
+
+		profile := Profile{
+			ID:        uuid.New(),
+			UserID:    user.ID,
+			FirstName: identity.FirstName,
+			LastName:  identity.LastName,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+		}
+		return store.Profiles().Create(ctx, &profile)
+	})
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return user, nil
+}
+
+func generateUnusablePassword(ctx context.Context) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return utils.HashPassword(ctx, hex.EncodeToString(bytes))
+}
@@ -0,0 +1,63 @@
+package models_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+)
+
+func TestProvisionExternalUserChecksUsernameAvailability(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+
+	mallory := models.User{}
+	if err := mallory.Register(ctx, store, "mallory", "password123", "mallory@example.com", ""); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	// mallory was called bob until a moment ago.
+	history := models.UsernameHistory{
+		ID:                   uuid.New(),
+		UserID:               mallory.ID,
+		OldUsername:          "bob",
+		OldUsernameCanonical: "bob",
+		NewUsername:          "mallory",
+		ChangedAt:            time.Now().UTC(),
+		HeldUntil:            time.Now().UTC().Add(time.Hour),
+	}
+	if err := store.UsernameHistory().Create(ctx, &history); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		wantCode int
+	}{
+		{name: "held username", username: "bob", wantCode: http.StatusConflict},
+		{name: "confusable username", username: "rnallory", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := models.ExternalIdentity{
+				Provider:   models.SAMLAuthProvider,
+				ExternalID: tt.username + "@idp.example.com",
+				Username:   tt.username,
+				Email:      tt.username + "@example.com",
+			}
+
+			u := models.User{}
+			_, err := u.ProvisionExternalUser(ctx, store, newTestPolicy(t), identity)
+			if err == nil || err.Code != tt.wantCode {
+				t.Fatalf("ProvisionExternalUser() error = %v, want code %d", err, tt.wantCode)
+			}
+			if _, err := store.Users().FindByExternalID(ctx, identity.Provider, identity.ExternalID); err == nil {
+				t.Error("ProvisionExternalUser() created the user")
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+// InviteCode lets up to MaxUses people register while registration is
+// invite-only. Users referred by a code get its creator as ReferredBy.
+type InviteCode struct {
+	ID        uuid.UUID  `json:"id" gorm:"primary_key"`
+	Code      string     `json:"code" gorm:"unique;not null"`
+	CreatedBy *uuid.UUID `json:"created_by"`
+	MaxUses   int        `json:"max_uses" gorm:"not null"`
+	UseCount  int        `json:"use_count" gorm:"not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	CreatedAt time.Time  `json:"created_at" gorm:"not null;autoCreateTime:false"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// InviteCodeUse records a registration made with an invite code.
+type InviteCodeUse struct {
+	ID           uuid.UUID `json:"id" gorm:"primary_key"`
+	InviteCodeID uuid.UUID `json:"invite_code_id" gorm:"not null"`
+	UserID       uuid.UUID `json:"user_id" gorm:"not null"`
+	UsedAt       time.Time `json:"used_at" gorm:"not null"`
+}
+
+// CreateInviteCode creates an invite code for the user, as long as they
+// created fewer codes than the quota of their level allows within the quota
+// period. The user is locked while counting, so that concurrent requests
+// can not exceed the quota.
+func (i *InviteCode) CreateInviteCode(ctx context.Context, store Store, cfg *config.InviteCodeConfig, userID uuid.UUID) *errors.ApiError {
+	code, err := generateInviteCode()
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	var apiErr *errors.ApiError
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		user, err := store.Users().FindByIDForUpdate(ctx, userID)
+		if err != nil {
+			if utils.IsRecordNotFound(err) {
+				apiErr = errors.BadRequestError("user not found")
+				return apiErr
+			}
+
+			return err
+		}
+
+		quota := cfg.Quota(string(user.Level))
+		if quota == 0 {
+			apiErr = errors.BadRequestError("users with level %v can not create invite codes", user.Level)
+			return apiErr
+		}
+
+		now := time.Now().UTC()
+		created, err := store.InviteCodes().CountCreatedSince(ctx, userID, now.Add(-cfg.QuotaPeriod))
+		if err != nil {
+			return err
+		}
+		if created >= quota {
+			apiErr = errors.BadRequestError("invite code quota of %d reached", quota)
+			return apiErr
+		}
+
+		i.ID = uuid.New()
+		i.Code = code
+		i.CreatedBy = &userID
+		i.MaxUses = cfg.MaxUses
+		i.UseCount = 0
+		i.ExpiresAt = now.Add(cfg.Expiration)
+		i.CreatedAt = now
+		return store.InviteCodes().Create(ctx, i)
+	})
+	if apiErr != nil {
+		return apiErr
+	}
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+func (i *InviteCode) GetUserInviteCodes(ctx context.Context, store Store, userID uuid.UUID) ([]InviteCode, *errors.ApiError) {
+	codes, err := store.InviteCodes().FindByCreator(ctx, userID)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return codes, nil
+}
+
+// RevokeInviteCode revokes the code so that it can not be used any more.
+// Codes of other users can only be revoked by admins.
+func (i *InviteCode) RevokeInviteCode(ctx context.Context, store Store, codeID uuid.UUID, userID uuid.UUID, isAdmin bool) *errors.ApiError {
+	if err := i.load(ctx, store, codeID); err != nil {
+		return err
+	}
+
+	if !isAdmin && (i.CreatedBy == nil || *i.CreatedBy != userID) {
+		return errors.NotFoundError("invite code %v not found", codeID)
+	}
+
+	if i.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	i.RevokedAt = &now
+	if err := store.InviteCodes().Save(ctx, i); err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// ListInviteCodes returns a page of all invite codes, most recent first,
+// optionally only those created by createdBy.
+func (i *InviteCode) ListInviteCodes(ctx context.Context, store Store, createdBy *uuid.UUID, offset int, limit int) ([]InviteCode, int64, *errors.ApiError) {
+	codes, total, err := store.InviteCodes().List(ctx, createdBy, offset, limit)
+	if err != nil {
+		return nil, 0, errors.InternalServerError(err.Error())
+	}
+
+	return codes, total, nil
+}
+
+// GetInviteCodeUses returns the registrations made with the code along with
+// the users who registered, including since deleted ones.
+func (i *InviteCode) GetInviteCodeUses(ctx context.Context, store Store, codeID uuid.UUID) ([]InviteCodeUse, map[uuid.UUID]User, *errors.ApiError) {
+	if err := i.load(ctx, store, codeID); err != nil {
+		return nil, nil, err
+	}
+
+	uses, err := store.InviteCodes().FindUses(ctx, codeID)
+	if err != nil {
+		return nil, nil, errors.InternalServerError(err.Error())
+	}
+
+	userIDs := make([]uuid.UUID, 0, len(uses))
+	for _, use := range uses {
+		userIDs = append(userIDs, use.UserID)
+	}
+
+	dbUsers, err := store.Users().FindByIDsWithDeleted(ctx, userIDs)
+	if err != nil {
+		return nil, nil, errors.InternalServerError(err.Error())
+	}
+
+	users := make(map[uuid.UUID]User, len(dbUsers))
+	for _, u := range dbUsers {
+		users[u.ID] = u
+	}
+
+	return uses, users, nil
+}
+
+func (i *InviteCode) load(ctx context.Context, store Store, codeID uuid.UUID) *errors.ApiError {
+	code, err := store.InviteCodes().FindByID(ctx, codeID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("invite code %v not found", codeID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*i = *code
+
+	return nil
+}
+
+// redeem uses up one use of the code for the newly registered userID.
+func (i *InviteCode) redeem(ctx context.Context, store Store, userID uuid.UUID) error {
+	i.UseCount++
+	use := InviteCodeUse{
+		ID:           uuid.New(),
+		InviteCodeID: i.ID,
+		UserID:       userID,
+		UsedAt:       time.Now().UTC(),
+	}
+
+	return store.InviteCodes().Redeem(ctx, i, &use)
+}
+
+func generateInviteCode() (string, error) {
+	bytes := make([]byte, 10)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,95 @@
+package models_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+)
+
+var testInviteCodeConfig = config.InviteCodeConfig{
+	Expiration:  14 * 24 * time.Hour,
+	MaxUses:     5,
+	SilverQuota: 2,
+	QuotaPeriod: 30 * 24 * time.Hour,
+}
+
+func registerSilverUser(t *testing.T, store models.Store) *models.User {
+	t.Helper()
+	ctx := context.Background()
+
+	user := models.User{}
+	if err := user.Register(ctx, store, "alice", "password123", "alice@example.com", ""); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := user.UpdateLevel(ctx, store, user.ID, string(models.SILVER)); err != nil {
+		t.Fatalf("UpdateLevel() error = %v", err)
+	}
+
+	return &user
+}
+
+func TestCreateInviteCodeCountsRevokedCodesTowardsTheQuota(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	user := registerSilverUser(t, store)
+
+	// A code created before the quota period does not count.
+	old := models.InviteCode{
+		ID:        uuid.New(),
+		Code:      "OLD",
+		CreatedBy: &user.ID,
+		MaxUses:   5,
+		ExpiresAt: time.Now().UTC().Add(-time.Hour),
+		CreatedAt: time.Now().UTC().Add(-testInviteCodeConfig.QuotaPeriod - time.Hour),
+	}
+	if err := store.InviteCodes().Create(ctx, &old); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < testInviteCodeConfig.SilverQuota; i++ {
+		code := models.InviteCode{}
+		if err := code.CreateInviteCode(ctx, store, &testInviteCodeConfig, user.ID); err != nil {
+			t.Fatalf("CreateInviteCode() error = %v", err)
+		}
+		if err := code.RevokeInviteCode(ctx, store, code.ID, user.ID, false); err != nil {
+			t.Fatalf("RevokeInviteCode() error = %v", err)
+		}
+	}
+
+	code := models.InviteCode{}
+	if err := code.CreateInviteCode(ctx, store, &testInviteCodeConfig, user.ID); err == nil || err.Code != http.StatusBadRequest {
+		t.Errorf("CreateInviteCode() error = %v, want the quota reached", err)
+	}
+}
+
+func TestCreateInviteCodeHoldsTheQuotaUnderConcurrentRequests(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+	user := registerSilverUser(t, store)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code := models.InviteCode{}
+			code.CreateInviteCode(ctx, store, &testInviteCodeConfig, user.ID)
+		}()
+	}
+	wg.Wait()
+
+	codes, err := store.InviteCodes().FindByCreator(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != testInviteCodeConfig.SilverQuota {
+		t.Errorf("created %d codes, want the quota of %d", len(codes), testInviteCodeConfig.SilverQuota)
+	}
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+// LDAPAuthenticator authenticates users by binding to an LDAP or Active
+// Directory server with their own credentials. Users are provisioned locally
+// on their first successful login, as long as Policy accepts them.
+type LDAPAuthenticator struct {
+	Config *config.LDAPConfig
+	Policy *validators.RegistrationPolicy
+}
+
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, store Store, username string, password string) (*User, *errors.ApiError) {
+	// An empty password would result in an unauthenticated bind, which most
+	// servers accept without checking anything.
+	if password == "" {
+		return nil, errors.BadRequestError("invalid password")
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+	defer conn.Close()
+
+	// Requests in flight when ctx ends fail once the connection is closed.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	entry, apiErr := a.findEntry(conn, username)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errors.BadRequestError("invalid password")
+		}
+
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	identity := ExternalIdentity{
+		Provider:   LDAPAuthProvider,
+		ExternalID: entry.DN,
+		Username:   entry.GetAttributeValue(a.Config.UsernameAttribute),
+		Email:      entry.GetAttributeValue(a.Config.EmailAttribute),
+		FirstName:  entry.GetAttributeValue(a.Config.FirstNameAttribute),
+		LastName:   entry.GetAttributeValue(a.Config.LastNameAttribute),
+	}
+	if identity.Username == "" {
+		identity.Username = username
+	}
+
+	u := User{}
+	return u.ProvisionExternalUser(ctx, store, a.Policy, identity)
+}
+
+// dial connects to the server. Connecting and every request then made on
+// the connection time out after the configured timeout, or when ctx ends if
+// that is sooner.
+func (a *LDAPAuthenticator) dial(ctx context.Context) (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.Config.InsecureSkipVerify}
+
+	timeout := a.Config.Timeout
+	dialer := &net.Dialer{Timeout: timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+		timeout = min(timeout, time.Until(deadline))
+	}
+
+	conn, err := ldap.DialURL(a.Config.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(timeout)
+
+	if a.Config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) findEntry(conn *ldap.Conn, username string) (*ldap.Entry, *errors.ApiError) {
+	if a.Config.BindDN != "" {
+		if err := conn.Bind(a.Config.BindDN, a.Config.BindPassword); err != nil {
+			return nil, errors.InternalServerError(err.Error())
+		}
+	}
+
+	request := ldap.NewSearchRequest(
+		a.Config.SearchBase,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		0,
+		false,
+		fmt.Sprintf(a.Config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{
+			a.Config.UsernameAttribute,
+			a.Config.EmailAttribute,
+			a.Config.FirstNameAttribute,
+			a.Config.LastNameAttribute,
+		},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			return nil, errors.BadRequestError("user %s not found", username)
+		}
+
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, errors.BadRequestError("user %s not found", username)
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, errors.InternalServerError("multiple directory entries match user %s", username)
+	}
+}
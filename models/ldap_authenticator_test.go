@@ -0,0 +1,272 @@
+package models_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+const (
+	testBindDN       = "cn=readonly,dc=example,dc=com"
+	testBindPassword = "readonly-secret"
+	testAliceDN      = "uid=alice,ou=people,dc=example,dc=com"
+	testAlicePass    = "alice-secret"
+)
+
+// testDirectory is an LDAP server holding the service account and alice.
+// Searches block until the test ends while stall is set.
+type testDirectory struct {
+	url     string
+	stall   bool
+	release chan struct{}
+}
+
+func startTestDirectory(t *testing.T, stall bool) *testDirectory {
+	t.Helper()
+
+	d := &testDirectory{stall: stall, release: make(chan struct{})}
+
+	server, err := gldap.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, err := gldap.NewMux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Bind(d.bind); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Search(d.search); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Router(mux); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := freeAddress(t)
+	go server.Run(addr)
+	t.Cleanup(func() {
+		close(d.release)
+		server.Stop()
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); !server.Ready(); {
+		if time.Now().After(deadline) {
+			t.Fatal("LDAP server did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d.url = "ldap://" + addr
+	return d
+}
+
+func (d *testDirectory) bind(w *gldap.ResponseWriter, r *gldap.Request) {
+	resp := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+	defer w.Write(resp)
+
+	m, err := r.GetSimpleBindMessage()
+	if err != nil {
+		return
+	}
+
+	if (m.UserName == testBindDN && string(m.Password) == testBindPassword) ||
+		(m.UserName == testAliceDN && string(m.Password) == testAlicePass) {
+		resp.SetResultCode(gldap.ResultSuccess)
+	}
+}
+
+func (d *testDirectory) search(w *gldap.ResponseWriter, r *gldap.Request) {
+	if d.stall {
+		<-d.release
+		return
+	}
+
+	resp := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+	defer w.Write(resp)
+
+	m, err := r.GetSearchMessage()
+	if err != nil {
+		resp.SetResultCode(gldap.ResultOperationsError)
+		return
+	}
+
+	if strings.Contains(m.Filter, "(uid=alice)") {
+		w.Write(r.NewSearchResponseEntry(testAliceDN, gldap.WithAttributes(map[string][]string{
+			"uid":       {"alice"},
+			"mail":      {"alice@example.com"},
+			"givenName": {"Alice"},
+			"sn":        {"Smith"},
+		})))
+	}
+}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return "127.0.0.1:" + strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+// newTestPolicy builds the default registration policy, denying emails of
+// the given domains.
+func newTestPolicy(t *testing.T, deniedDomains ...string) *validators.RegistrationPolicy {
+	t.Helper()
+
+	policy, err := validators.NewRegistrationPolicy(&config.RegistrationConfig{
+		UsernamePattern:     "^[a-zA-Z0-9_.-]+$",
+		UsernameMinLength:   3,
+		UsernameMaxLength:   32,
+		EmailDomainDenylist: deniedDomains,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return policy
+}
+
+func newTestLDAPAuthenticator(t *testing.T, url string, timeout time.Duration) *models.LDAPAuthenticator {
+	return &models.LDAPAuthenticator{Policy: newTestPolicy(t), Config: &config.LDAPConfig{
+		URL:                url,
+		BindDN:             testBindDN,
+		BindPassword:       testBindPassword,
+		SearchBase:         "ou=people,dc=example,dc=com",
+		UserFilter:         "(&(objectClass=person)(uid=%s))",
+		UsernameAttribute:  "uid",
+		EmailAttribute:     "mail",
+		FirstNameAttribute: "givenName",
+		LastNameAttribute:  "sn",
+		Timeout:            timeout,
+	}}
+}
+
+func TestLDAPAuthenticatorProvisionsUserOnFirstLogin(t *testing.T) {
+	d := startTestDirectory(t, false)
+	a := newTestLDAPAuthenticator(t, d.url, 5*time.Second)
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+
+	user, err := a.Authenticate(ctx, store, "alice", testAlicePass)
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.com" {
+		t.Errorf("Authenticate() user = %s <%s>, want alice <alice@example.com>", user.Username, user.Email)
+	}
+	if user.AuthProvider != models.LDAPAuthProvider || user.ExternalID == nil || *user.ExternalID != testAliceDN {
+		t.Errorf("Authenticate() user is not linked to %s", testAliceDN)
+	}
+
+	again, err := a.Authenticate(ctx, store, "alice", testAlicePass)
+	if err != nil {
+		t.Fatalf("second Authenticate() error = %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second Authenticate() user = %v, want %v", again.ID, user.ID)
+	}
+}
+
+func TestLDAPAuthenticatorAppliesRegistrationPolicy(t *testing.T) {
+	d := startTestDirectory(t, false)
+	a := newTestLDAPAuthenticator(t, d.url, 5*time.Second)
+	a.Policy = newTestPolicy(t, "example.com")
+	store := storage.NewMemoryStore()
+
+	_, err := a.Authenticate(context.Background(), store, "alice", testAlicePass)
+	if err == nil || err.Code != http.StatusBadRequest {
+		t.Fatalf("Authenticate() error = %v, want a bad request", err)
+	}
+	if _, err := store.Users().FindByExternalID(context.Background(), models.LDAPAuthProvider, testAliceDN); err == nil {
+		t.Error("Authenticate() provisioned a user with a denied email domain")
+	}
+}
+
+func TestLDAPAuthenticatorRejectsInvalidCredentials(t *testing.T) {
+	d := startTestDirectory(t, false)
+	a := newTestLDAPAuthenticator(t, d.url, 5*time.Second)
+	store := storage.NewMemoryStore()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{name: "wrong password", username: "alice", password: "wrong"},
+		{name: "empty password", username: "alice", password: ""},
+		{name: "unknown user", username: "bob", password: testAlicePass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Authenticate(context.Background(), store, tt.username, tt.password)
+			if err == nil || err.Code != http.StatusBadRequest {
+				t.Errorf("Authenticate() error = %v, want a bad request", err)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorTimesOut(t *testing.T) {
+	d := startTestDirectory(t, true)
+	store := storage.NewMemoryStore()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{
+			name:    "configured timeout",
+			timeout: 200 * time.Millisecond,
+			ctx:     func() (context.Context, context.CancelFunc) { return context.Background(), func() {} },
+		},
+		{
+			name:    "context deadline",
+			timeout: time.Minute,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 200*time.Millisecond)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestLDAPAuthenticator(t, d.url, tt.timeout)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			_, err := a.Authenticate(ctx, store, "alice", testAlicePass)
+			if err == nil || err.Code != http.StatusInternalServerError {
+				t.Errorf("Authenticate() error = %v, want an internal server error", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Authenticate() returned after %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorFailsWhenServerIsDown(t *testing.T) {
+	a := newTestLDAPAuthenticator(t, "ldap://"+freeAddress(t), time.Second)
+
+	_, err := a.Authenticate(context.Background(), storage.NewMemoryStore(), "alice", testAlicePass)
+	if err == nil || err.Code != http.StatusInternalServerError {
+		t.Errorf("Authenticate() error = %v, want an internal server error", err)
+	}
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/events"
+)
+
+// Domain events published to other services. The version of an event type
+// is bumped whenever its payload changes incompatibly.
+const (
+	UserRegisteredEvent     = "user.registered"
+	UserLevelChangedEvent   = "user.level_changed"
+	UserProfileUpdatedEvent = "user.profile_updated"
+	UserDeletedEvent        = "user.deleted"
+	UserRestoredEvent       = "user.restored"
+	UserPurgedEvent         = "user.purged"
+)
+
+var eventVersions = map[string]int{
+	UserRegisteredEvent:     1,
+	UserLevelChangedEvent:   1,
+	UserProfileUpdatedEvent: 1,
+	UserDeletedEvent:        1,
+	UserRestoredEvent:       1,
+	UserPurgedEvent:         1,
+}
+
+// OutboxEvent is a domain event waiting to be published. It is written in
+// the same transaction as the change it describes, so that an event is
+// published if and only if the change is committed.
+type OutboxEvent struct {
+	ID          uuid.UUID       `gorm:"primary_key"`
+	EventType   string          `gorm:"not null"`
+	Version     int             `gorm:"not null"`
+	AggregateID uuid.UUID       `gorm:"not null"`
+	Payload     json.RawMessage `gorm:"type:jsonb;not null"`
+	CreatedAt   time.Time       `gorm:"not null;autoCreateTime:false"`
+	PublishedAt *time.Time
+	// ClaimedUntil is set while a relay publishes the event.
+	ClaimedUntil *time.Time
+	// DeadAt is set once the event failed to publish too many times. Dead
+	// events are no longer published.
+	DeadAt    *time.Time
+	Attempts  int `gorm:"not null"`
+	LastError string
+}
+
+type UserRegisteredPayload struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Level     Level     `json:"level"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type UserLevelChangedPayload struct {
+	UserID   uuid.UUID `json:"user_id"`
+	OldLevel Level     `json:"old_level"`
+	NewLevel Level     `json:"new_level"`
+}
+
+type UserProfileUpdatedPayload struct {
+	UserID    uuid.UUID `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+}
+
+type UserDeletedPayload struct {
+	UserID           uuid.UUID  `json:"user_id"`
+	ScheduledPurgeAt *time.Time `json:"scheduled_purge_at,omitempty"`
+}
+
+type UserIDPayload struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// ClaimUnpublishedOutboxEvents returns up to limit unpublished events in the
+// order they were written, and keeps other relays from claiming them for
+// lease, so that they can be published outside of any transaction.
+func ClaimUnpublishedOutboxEvents(ctx context.Context, store Store, limit int, lease time.Duration) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		now := time.Now().UTC()
+		unpublished, err := store.OutboxEvents().FindUnpublished(ctx, now, limit)
+		if err != nil || len(unpublished) == 0 {
+			return err
+		}
+
+		claimedUntil := now.Add(lease)
+		if err := store.OutboxEvents().Claim(ctx, outboxEventIDs(unpublished), &claimedUntil); err != nil {
+			return err
+		}
+		for i := range unpublished {
+			unpublished[i].ClaimedUntil = &claimedUntil
+		}
+
+		events = unpublished
+		return nil
+	})
+
+	return events, err
+}
+
+// ReleaseOutboxEvents gives up the claim on events that were not published,
+// so that the next run can publish them without waiting for the lease.
+func ReleaseOutboxEvents(ctx context.Context, store Store, events []OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	return store.OutboxEvents().Claim(ctx, outboxEventIDs(events), nil)
+}
+
+// Event is the event as it is published.
+func (e *OutboxEvent) Event() events.Event {
+	return events.Event{
+		ID:          e.ID,
+		Type:        e.EventType,
+		Version:     e.Version,
+		AggregateID: e.AggregateID,
+		OccurredAt:  e.CreatedAt,
+		Data:        e.Payload,
+	}
+}
+
+func (e *OutboxEvent) MarkPublished(ctx context.Context, store Store) error {
+	now := time.Now().UTC()
+	e.PublishedAt = &now
+	e.ClaimedUntil = nil
+	e.Attempts++
+	e.LastError = ""
+	return store.OutboxEvents().Save(ctx, e)
+}
+
+// MarkFailed records the failed attempt and releases the event, or marks
+// it dead if it ran out of its maxAttempts attempts.
+func (e *OutboxEvent) MarkFailed(ctx context.Context, store Store, publishErr error, maxAttempts int) error {
+	e.ClaimedUntil = nil
+	e.Attempts++
+	e.LastError = publishErr.Error()
+	if e.Attempts >= maxAttempts {
+		now := time.Now().UTC()
+		e.DeadAt = &now
+	}
+	return store.OutboxEvents().Save(ctx, e)
+}
+
+func outboxEventIDs(events []OutboxEvent) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+
+	return ids
+}
+
+// enqueueEvent writes an event to the outbox and queues its webhook
+// deliveries. store must be the transaction making the change the event
+// describes. The deliveries are queued right away rather than once the event
+// is published, so that partners get it even while the broker is down.
+func enqueueEvent(ctx context.Context, store Store, eventType string, userID uuid.UUID, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	event := OutboxEvent{
+		ID:          uuid.New(),
+		EventType:   eventType,
+		Version:     eventVersions[eventType],
+		AggregateID: userID,
+		Payload:     body,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := store.OutboxEvents().Create(ctx, &event); err != nil {
+		return err
+	}
+
+	delivery, err := json.Marshal(event.Event())
+	if err != nil {
+		return err
+	}
+
+	return EnqueueWebhookDeliveries(ctx, store, event.ID, eventType, delivery)
+}
+
+func enqueueUserRegisteredEvent(ctx context.Context, store Store, user *User) error {
+	return enqueueEvent(ctx, store, UserRegisteredEvent, user.ID, UserRegisteredPayload{
+		UserID:    user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		Level:     user.Level,
+		CreatedAt: user.CreatedAt,
+	})
+}
@@ -0,0 +1,52 @@
+package models_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/events"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/storage"
+)
+
+func TestEventsQueueWebhookDeliveriesWithoutThePublisher(t *testing.T) {
+	store := storage.NewMemoryStore()
+	ctx := context.Background()
+
+	endpoint := models.WebhookEndpoint{
+		ID:        uuid.New(),
+		URL:       "https://partner.example.com/hooks",
+		Secret:    "secret",
+		Active:    true,
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+	}
+	if err := store.WebhookEndpoints().Create(ctx, &endpoint); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing relays the outbox, as if the broker were down.
+	user := models.User{}
+	if err := user.Register(ctx, store, "alice", "password123", "alice@example.com", ""); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	deliveries, err := store.WebhookDeliveries().FindDue(ctx, time.Now().UTC(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].EndpointID != endpoint.ID || deliveries[0].EventType != models.UserRegisteredEvent {
+		t.Fatalf("deliveries = %+v, want the registration queued for the endpoint", deliveries)
+	}
+
+	var event events.Event
+	if err := json.Unmarshal(deliveries[0].Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != deliveries[0].EventID || event.AggregateID != user.ID {
+		t.Errorf("delivered event = %+v, want the registration of %v", event, user.ID)
+	}
+}
@@ -1,16 +1,16 @@
 package models
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
 	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/metrics"
 	"github.com/vantutran2k1/social-network-auth/utils"
-	"gorm.io/gorm"
 )
 
 type PasswordResetToken struct {
@@ -21,8 +21,8 @@ type PasswordResetToken struct {
 	CreatedAt   time.Time `gorm:"not null,autoCreateTime:false"`
 }
 
-func (t *PasswordResetToken) CreateResetToken(db *gorm.DB, userID uuid.UUID) (*PasswordResetToken, *errors.ApiError) {
-	if err := db.Where(&User{ID: userID}).First(&User{}).Error; err != nil {
+func (t *PasswordResetToken) CreateResetToken(ctx context.Context, store Store, cfg *config.TokenConfig, userID uuid.UUID) (*PasswordResetToken, *errors.ApiError) {
+	if _, err := store.Users().FindByID(ctx, userID); err != nil {
 		if utils.IsRecordNotFound(err) {
 			return nil, errors.BadRequestError("user not found")
 		}
@@ -35,21 +35,18 @@ func (t *PasswordResetToken) CreateResetToken(db *gorm.DB, userID uuid.UUID) (*P
 		return nil, errors.InternalServerError(err.Error())
 	}
 
-	expirationAfter, err := strconv.Atoi(os.Getenv("RESET_PASSWORD_TOKEN_EXPIRATION_MINUTES"))
-	if err != nil {
-		return nil, errors.InternalServerError(err.Error())
-	}
 	resetToken := &PasswordResetToken{
 		ID:          uuid.New(),
 		UserID:      userID,
 		Token:       token,
-		TokenExpiry: time.Now().UTC().Add(time.Duration(expirationAfter) * time.Minute),
+		TokenExpiry: time.Now().UTC().Add(cfg.PasswordResetExpiration),
 		CreatedAt:   time.Now().UTC(),
 	}
 
-	if err := db.Create(&resetToken).Error; err != nil {
+	if err := store.PasswordResetTokens().Create(ctx, resetToken); err != nil {
 		return nil, errors.InternalServerError(err.Error())
 	}
+	metrics.PasswordResetsRequested.Inc()
 
 	return resetToken, nil
 }
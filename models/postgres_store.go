@@ -0,0 +1,970 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/scim"
+	"github.com/vantutran2k1/social-network-auth/transaction"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore returns the store backed by db. db can be a transaction,
+// which lets code that still works on *gorm.DB call into the store.
+func NewPostgresStore(db *gorm.DB) Store {
+	return &postgresStore{db: db}
+}
+
+func (s *postgresStore) Users() UserRepository {
+	return &postgresUserRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) Tokens() TokenRepository {
+	return &postgresTokenRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) Profiles() ProfileRepository {
+	return &postgresProfileRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) PasswordResetTokens() PasswordResetTokenRepository {
+	return &postgresPasswordResetTokenRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) InviteCodes() InviteCodeRepository {
+	return &postgresInviteCodeRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) AccountStatusChanges() AccountStatusChangeRepository {
+	return &postgresAccountStatusChangeRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) AuditEvents() AuditEventRepository {
+	return &postgresAuditEventRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) OutboxEvents() OutboxEventRepository {
+	return &postgresOutboxEventRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) EmailChangeRequests() EmailChangeRequestRepository {
+	return &postgresEmailChangeRequestRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) UsernameHistory() UsernameHistoryRepository {
+	return &postgresUsernameHistoryRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) DataExports() DataExportRepository {
+	return &postgresDataExportRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) WebhookEndpoints() WebhookEndpointRepository {
+	return &postgresWebhookEndpointRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) WebhookDeliveries() WebhookDeliveryRepository {
+	return &postgresWebhookDeliveryRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) SAMLAssertions() SAMLAssertionRepository {
+	return &postgresSAMLAssertionRepository{postgresRepository{db: s.db}}
+}
+
+func (s *postgresStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error, opts ...transaction.Option) error {
+	return transaction.NewTransactionManager(s.db).WithTransaction(ctx, fn, opts...)
+}
+
+// postgresRepository runs queries in the transaction of their context, see
+// transaction.DB.
+type postgresRepository struct {
+	db *gorm.DB
+}
+
+func (r postgresRepository) conn(ctx context.Context) *gorm.DB {
+	return transaction.DB(ctx, r.db)
+}
+
+type postgresUserRepository struct {
+	postgresRepository
+}
+
+func (r *postgresUserRepository) Create(ctx context.Context, user *User) error {
+	return r.conn(ctx).Create(user).Error
+}
+
+func (r *postgresUserRepository) Save(ctx context.Context, user *User) error {
+	return r.conn(ctx).Unscoped().Save(user).Error
+}
+
+func (r *postgresUserRepository) FindByID(ctx context.Context, id uuid.UUID) (*User, error) {
+	return r.first(r.conn(ctx).Where(&User{ID: id}))
+}
+
+func (r *postgresUserRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*User, error) {
+	return r.first(r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).Where(&User{ID: id}))
+}
+
+func (r *postgresUserRepository) FindByIDWithDeleted(ctx context.Context, id uuid.UUID) (*User, error) {
+	return r.first(r.conn(ctx).Unscoped().Where(&User{ID: id}))
+}
+
+func (r *postgresUserRepository) FindByIDsWithDeleted(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	var users []User
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	if err := r.conn(ctx).Unscoped().Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// The lookups by username or email spell out their conditions, as gorm
+// drops the zero fields of a struct condition and an empty value would
+// match any user. No user has an empty username or email, so an empty value
+// finds nothing.
+
+func (r *postgresUserRepository) FindByUsername(ctx context.Context, usernameCanonical string) (*User, error) {
+	if usernameCanonical == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return r.first(r.conn(ctx).Where("username_canonical = ?", usernameCanonical))
+}
+
+func (r *postgresUserRepository) FindByUsernameWithDeleted(ctx context.Context, usernameCanonical string) (*User, error) {
+	if usernameCanonical == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return r.first(r.conn(ctx).Unscoped().Where("username_canonical = ?", usernameCanonical))
+}
+
+func (r *postgresUserRepository) FindByEmail(ctx context.Context, emailCanonical string) (*User, error) {
+	if emailCanonical == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return r.first(r.conn(ctx).Where("email_canonical = ?", emailCanonical))
+}
+
+func (r *postgresUserRepository) FindByUsernameOrEmail(ctx context.Context, usernameCanonical string, emailCanonical string) (*User, error) {
+	switch {
+	case usernameCanonical == "" && emailCanonical == "":
+		return nil, gorm.ErrRecordNotFound
+	case emailCanonical == "":
+		return r.FindByUsername(ctx, usernameCanonical)
+	case usernameCanonical == "":
+		return r.FindByEmail(ctx, emailCanonical)
+	}
+
+	return r.first(r.conn(ctx).Where("username_canonical = ? OR email_canonical = ?", usernameCanonical, emailCanonical))
+}
+
+func (r *postgresUserRepository) FindByExternalID(ctx context.Context, provider string, externalID string) (*User, error) {
+	return r.first(r.conn(ctx).Where(&User{AuthProvider: provider, ExternalID: &externalID}))
+}
+
+// ExistsWithUsernameOrEmail needs no special case for empty values, as no
+// user has an empty username or email.
+func (r *postgresUserRepository) ExistsWithUsernameOrEmail(ctx context.Context, usernameCanonical string, emailCanonical string, exceptID uuid.UUID) (bool, error) {
+	query := r.conn(ctx).Unscoped().Model(&User{}).
+		Where("(username_canonical = ? OR email_canonical = ?) AND id <> ?", usernameCanonical, emailCanonical, exceptID)
+	return exists(query)
+}
+
+func (r *postgresUserRepository) ExistsWithUsernameSkeleton(ctx context.Context, skeleton string, exceptID uuid.UUID) (bool, error) {
+	return exists(r.conn(ctx).Unscoped().Model(&User{}).Where("username_skeleton = ? AND id <> ?", skeleton, exceptID))
+}
+
+func (r *postgresUserRepository) IsUsernameHeld(ctx context.Context, usernameCanonical string, exceptID uuid.UUID, now time.Time) (bool, error) {
+	return exists(r.conn(ctx).Model(&UsernameHistory{}).Where("old_username_canonical = ? AND held_until > ? AND user_id <> ?", usernameCanonical, now, exceptID))
+}
+
+func (r *postgresUserRepository) List(ctx context.Context, filter UserFilter, after *ListPosition, limit int) ([]User, error) {
+	query := r.conn(ctx).Unscoped().Model(&User{})
+
+	if filter.Username != "" {
+		query = query.Where("username_canonical LIKE ?", "%"+utils.EscapeLike(utils.CanonicalizeUsername(filter.Username))+"%")
+	}
+	if filter.Email != "" {
+		query = query.Where("email_canonical LIKE ?", "%"+utils.EscapeLike(utils.CanonicalizeEmail(filter.Email))+"%")
+	}
+	if filter.Level != UNKNOWN {
+		query = query.Where(&User{Level: filter.Level})
+	}
+	if filter.Status != "" {
+		query = query.Where(&User{Status: filter.Status})
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at < ?", *filter.CreatedBefore)
+	}
+	if filter.Deleted != nil {
+		if *filter.Deleted {
+			query = query.Where("deleted_at IS NOT NULL")
+		} else {
+			query = query.Where("deleted_at IS NULL")
+		}
+	}
+	if after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", after.CreatedAt, after.ID)
+	}
+
+	var users []User
+	if err := query.Order("created_at, id").Limit(limit).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// scimUserColumns maps SCIMUserAttributes to the columns they are stored in.
+// Profiles are joined to the users.
+var scimUserColumns = map[string]string{
+	"id":                "users.id::text",
+	"externalid":        "users.external_id",
+	"username":          "users.username",
+	"emails":            "users.email",
+	"emails.value":      "users.email",
+	"name.givenname":    "profiles.first_name",
+	"name.familyname":   "profiles.last_name",
+	"active":            "(users.deleted_at IS NULL)",
+	"meta.created":      "users.created_at",
+	"meta.lastmodified": "users.updated_at",
+}
+
+func (r *postgresUserRepository) FindBySCIMFilter(ctx context.Context, filter scim.Filter, offset int, limit int) ([]User, int64, error) {
+	query := func() *gorm.DB {
+		q := r.conn(ctx).Unscoped().Model(&User{}).Joins("LEFT JOIN profiles ON profiles.user_id = users.id").
+			Where("users.auth_provider = ? OR users.external_id IS NOT NULL", SCIMAuthProvider)
+		if filter != nil {
+			condition, args := scim.ToSQL(filter, scimUserColumns)
+			q = q.Where(condition, args...)
+		}
+		return q
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []User
+	if err := query().Select("users.*").Order("users.created_at, users.id").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func (r *postgresUserRepository) LiftExpiredSuspension(ctx context.Context, id uuid.UUID, reason string, now time.Time) (bool, error) {
+	result := r.conn(ctx).Model(&User{}).
+		Where("id = ? AND status = ? AND suspended_until <= ?", id, AccountSuspended, now).
+		Updates(map[string]any{
+			"status":          AccountActive,
+			"status_reason":   reason,
+			"suspended_until": nil,
+			"updated_at":      now,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *postgresUserRepository) FindScheduledForPurge(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.conn(ctx).Unscoped().Model(&User{}).
+		Where("scheduled_purge_at <= ?", now).
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (r *postgresUserRepository) Purge(ctx context.Context, id uuid.UUID) error {
+	tx := r.conn(ctx)
+	for _, model := range []any{
+		&Token{UserID: id},
+		&PasswordResetToken{UserID: id},
+		&DataExport{UserID: id},
+		&EmailChangeRequest{UserID: id},
+		&UsernameHistory{UserID: id},
+		&InviteCodeUse{UserID: id},
+		&AccountStatusChange{UserID: id},
+		&Profile{UserID: id},
+	} {
+		if err := tx.Unscoped().Where(model).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Unscoped().Where(&User{ID: id}).Delete(&User{}).Error
+}
+
+func (r *postgresUserRepository) first(query *gorm.DB) (*User, error) {
+	var user User
+	if err := query.First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+type postgresTokenRepository struct {
+	postgresRepository
+}
+
+func (r *postgresTokenRepository) Create(ctx context.Context, token *Token) error {
+	return r.conn(ctx).Create(token).Error
+}
+
+func (r *postgresTokenRepository) Save(ctx context.Context, tokens ...*Token) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	return r.conn(ctx).Save(tokens).Error
+}
+
+func (r *postgresTokenRepository) FindByToken(ctx context.Context, tokenString string) (*Token, error) {
+	var token Token
+	if err := r.conn(ctx).Where(&Token{Token: tokenString}).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *postgresTokenRepository) FindActiveByUser(ctx context.Context, userID uuid.UUID, now time.Time) ([]*Token, error) {
+	var tokens []*Token
+	if err := r.conn(ctx).Where("user_id = ? AND expires_at > ?", userID, now).Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (r *postgresTokenRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]Token, error) {
+	var tokens []Token
+	if err := r.conn(ctx).Where(&Token{UserID: userID}).Order("issued_at").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (r *postgresTokenRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	batch := r.conn(ctx).Model(&Token{}).Select("id").Where("expires_at < ?", before).Limit(limit)
+	result := r.conn(ctx).Where("id IN (?)", batch).Delete(&Token{})
+	return int(result.RowsAffected), result.Error
+}
+
+type postgresProfileRepository struct {
+	postgresRepository
+}
+
+// Create leaves an unknown date of birth NULL, since "" is not a valid date.
+func (r *postgresProfileRepository) Create(ctx context.Context, profile *Profile) error {
+	if profile.DateOfBirth == "" {
+		return r.conn(ctx).Omit("DateOfBirth").Create(profile).Error
+	}
+
+	return r.conn(ctx).Create(profile).Error
+}
+
+// Save leaves an unknown date of birth as it is, like Create.
+func (r *postgresProfileRepository) Save(ctx context.Context, profile *Profile) error {
+	fields := []string{"FirstName", "LastName", "Address", "Phone", "UpdatedAt"}
+	if profile.DateOfBirth != "" {
+		fields = append(fields, "DateOfBirth")
+	}
+
+	return r.conn(ctx).Unscoped().Model(profile).Select(fields).Updates(profile).Error
+}
+
+func (r *postgresProfileRepository) FindByUser(ctx context.Context, userID uuid.UUID) (*Profile, error) {
+	return r.first(r.conn(ctx).Where(&Profile{UserID: userID}))
+}
+
+func (r *postgresProfileRepository) FindByUserWithDeleted(ctx context.Context, userID uuid.UUID) (*Profile, error) {
+	return r.first(r.conn(ctx).Unscoped().Where(&Profile{UserID: userID}))
+}
+
+func (r *postgresProfileRepository) FindByUsersWithDeleted(ctx context.Context, userIDs []uuid.UUID) ([]Profile, error) {
+	var profiles []Profile
+	if len(userIDs) == 0 {
+		return profiles, nil
+	}
+
+	if err := r.conn(ctx).Unscoped().Where("user_id IN ?", userIDs).Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+
+	return profiles, nil
+}
+
+func (r *postgresProfileRepository) SetDeletedByUser(ctx context.Context, userID uuid.UUID, deletedAt *time.Time) error {
+	return r.conn(ctx).Unscoped().Model(&Profile{}).Where(&Profile{UserID: userID}).Update("deleted_at", deletedAt).Error
+}
+
+func (r *postgresProfileRepository) first(query *gorm.DB) (*Profile, error) {
+	var profile Profile
+	if err := query.First(&profile).Error; err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+type postgresPasswordResetTokenRepository struct {
+	postgresRepository
+}
+
+func (r *postgresPasswordResetTokenRepository) Create(ctx context.Context, token *PasswordResetToken) error {
+	return r.conn(ctx).Create(token).Error
+}
+
+func (r *postgresPasswordResetTokenRepository) FindValid(ctx context.Context, userID uuid.UUID, tokenString string, now time.Time) (*PasswordResetToken, error) {
+	var token PasswordResetToken
+	if err := r.conn(ctx).Where("token = ? AND token_expiry > ? AND user_id = ?", tokenString, now, userID).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *postgresPasswordResetTokenRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]PasswordResetToken, error) {
+	var tokens []PasswordResetToken
+	if err := r.conn(ctx).Where(&PasswordResetToken{UserID: userID}).Order("created_at").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (r *postgresPasswordResetTokenRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	batch := r.conn(ctx).Model(&PasswordResetToken{}).Select("id").Where("token_expiry < ?", before).Limit(limit)
+	result := r.conn(ctx).Where("id IN (?)", batch).Delete(&PasswordResetToken{})
+	return int(result.RowsAffected), result.Error
+}
+
+type postgresInviteCodeRepository struct {
+	postgresRepository
+}
+
+func (r *postgresInviteCodeRepository) Create(ctx context.Context, code *InviteCode) error {
+	return r.conn(ctx).Create(code).Error
+}
+
+func (r *postgresInviteCodeRepository) Save(ctx context.Context, code *InviteCode) error {
+	return r.conn(ctx).Save(code).Error
+}
+
+func (r *postgresInviteCodeRepository) FindByID(ctx context.Context, id uuid.UUID) (*InviteCode, error) {
+	var code InviteCode
+	if err := r.conn(ctx).Where(&InviteCode{ID: id}).First(&code).Error; err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+func (r *postgresInviteCodeRepository) FindByCreator(ctx context.Context, userID uuid.UUID) ([]InviteCode, error) {
+	var codes []InviteCode
+	if err := r.conn(ctx).Where("created_by = ?", userID).Order("created_at DESC").Find(&codes).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (r *postgresInviteCodeRepository) CountCreatedSince(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	var count int64
+	err := r.conn(ctx).Model(&InviteCode{}).
+		Where("created_by = ? AND created_at >= ?", userID, since).
+		Count(&count).Error
+	return int(count), err
+}
+
+func (r *postgresInviteCodeRepository) List(ctx context.Context, createdBy *uuid.UUID, offset int, limit int) ([]InviteCode, int64, error) {
+	query := r.conn(ctx).Model(&InviteCode{})
+	if createdBy != nil {
+		query = query.Where("created_by = ?", *createdBy)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var codes []InviteCode
+	if err := query.Order("created_at DESC, id").Offset(offset).Limit(limit).Find(&codes).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return codes, total, nil
+}
+
+func (r *postgresInviteCodeRepository) FindUses(ctx context.Context, codeID uuid.UUID) ([]InviteCodeUse, error) {
+	var uses []InviteCodeUse
+	if err := r.conn(ctx).Where(&InviteCodeUse{InviteCodeID: codeID}).Order("used_at").Find(&uses).Error; err != nil {
+		return nil, err
+	}
+
+	return uses, nil
+}
+
+func (r *postgresInviteCodeRepository) FindRedeemable(ctx context.Context, code string, now time.Time) (*InviteCode, error) {
+	var inviteCode InviteCode
+	err := r.conn(ctx).Where("revoked_at IS NULL AND expires_at > ? AND use_count < max_uses", now).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where(&InviteCode{Code: strings.ToUpper(strings.TrimSpace(code))}).
+		First(&inviteCode).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &inviteCode, nil
+}
+
+func (r *postgresInviteCodeRepository) Redeem(ctx context.Context, code *InviteCode, use *InviteCodeUse) error {
+	if err := r.conn(ctx).Model(code).Update("use_count", code.UseCount).Error; err != nil {
+		return err
+	}
+
+	return r.conn(ctx).Create(use).Error
+}
+
+type postgresAccountStatusChangeRepository struct {
+	postgresRepository
+}
+
+func (r *postgresAccountStatusChangeRepository) Create(ctx context.Context, change *AccountStatusChange) error {
+	return r.conn(ctx).Create(change).Error
+}
+
+func (r *postgresAccountStatusChangeRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]AccountStatusChange, error) {
+	var changes []AccountStatusChange
+	if err := r.conn(ctx).Where(&AccountStatusChange{UserID: userID}).Order("created_at").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+type postgresAuditEventRepository struct {
+	postgresRepository
+}
+
+func (r *postgresAuditEventRepository) Create(ctx context.Context, event *AuditEvent) error {
+	return r.conn(ctx).Create(event).Error
+}
+
+func (r *postgresAuditEventRepository) Find(ctx context.Context, filter AuditEventFilter, before *ListPosition, limit int) ([]AuditEvent, error) {
+	query := r.conn(ctx).Model(&AuditEvent{})
+
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.TargetUserID != nil {
+		query = query.Where("target_user_id = ?", *filter.TargetUserID)
+	}
+	if filter.Action != "" {
+		query = query.Where(&AuditEvent{Action: filter.Action})
+	}
+	if filter.Outcome != "" {
+		query = query.Where(&AuditEvent{Outcome: filter.Outcome})
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+	if before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", before.CreatedAt, before.ID)
+	}
+
+	var events []AuditEvent
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+type postgresOutboxEventRepository struct {
+	postgresRepository
+}
+
+func (r *postgresOutboxEventRepository) Create(ctx context.Context, event *OutboxEvent) error {
+	return r.conn(ctx).Create(event).Error
+}
+
+func (r *postgresOutboxEventRepository) Save(ctx context.Context, event *OutboxEvent) error {
+	updates := map[string]any{
+		"published_at":  event.PublishedAt,
+		"claimed_until": event.ClaimedUntil,
+		"dead_at":       event.DeadAt,
+		"attempts":      event.Attempts,
+		"last_error":    event.LastError,
+	}
+	return r.conn(ctx).Model(event).Updates(updates).Error
+}
+
+// FindUnpublished skips events locked by another transaction, so that
+// several relays can run concurrently.
+func (r *postgresOutboxEventRepository) FindUnpublished(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("published_at IS NULL AND dead_at IS NULL AND (claimed_until IS NULL OR claimed_until <= ?)", now).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *postgresOutboxEventRepository) Claim(ctx context.Context, ids []uuid.UUID, claimedUntil *time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.conn(ctx).Model(&OutboxEvent{}).Where("id IN ?", ids).Update("claimed_until", claimedUntil).Error
+}
+
+type postgresEmailChangeRequestRepository struct {
+	postgresRepository
+}
+
+func (r *postgresEmailChangeRequestRepository) Create(ctx context.Context, request *EmailChangeRequest) error {
+	return r.conn(ctx).Create(request).Error
+}
+
+func (r *postgresEmailChangeRequestRepository) Confirm(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	return r.resolve(ctx, id, "confirmed_at", now)
+}
+
+func (r *postgresEmailChangeRequestRepository) Cancel(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	return r.resolve(ctx, id, "cancelled_at", now)
+}
+
+// resolve sets column to now if the request is still pending at now.
+func (r *postgresEmailChangeRequestRepository) resolve(ctx context.Context, id uuid.UUID, column string, now time.Time) (bool, error) {
+	result := r.conn(ctx).Model(&EmailChangeRequest{}).
+		Where("id = ? AND confirmed_at IS NULL AND cancelled_at IS NULL AND token_expiry > ?", id, now).
+		Update(column, now)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *postgresEmailChangeRequestRepository) FindPendingByConfirmToken(ctx context.Context, confirmToken string, now time.Time) (*EmailChangeRequest, error) {
+	return r.findPending(ctx, &EmailChangeRequest{ConfirmToken: confirmToken}, now)
+}
+
+func (r *postgresEmailChangeRequestRepository) FindPendingByCancelToken(ctx context.Context, cancelToken string, now time.Time) (*EmailChangeRequest, error) {
+	return r.findPending(ctx, &EmailChangeRequest{CancelToken: cancelToken}, now)
+}
+
+func (r *postgresEmailChangeRequestRepository) findPending(ctx context.Context, condition *EmailChangeRequest, now time.Time) (*EmailChangeRequest, error) {
+	var request EmailChangeRequest
+	err := r.conn(ctx).Where(condition).
+		Where("confirmed_at IS NULL AND cancelled_at IS NULL AND token_expiry > ?", now).
+		First(&request).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func (r *postgresEmailChangeRequestRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]EmailChangeRequest, error) {
+	var requests []EmailChangeRequest
+	if err := r.conn(ctx).Where(&EmailChangeRequest{UserID: userID}).Order("created_at").Find(&requests).Error; err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+func (r *postgresEmailChangeRequestRepository) CancelPendingByUser(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	return r.conn(ctx).Model(&EmailChangeRequest{}).
+		Where("user_id = ? AND confirmed_at IS NULL AND cancelled_at IS NULL", userID).
+		Update("cancelled_at", at).Error
+}
+
+func (r *postgresEmailChangeRequestRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result := r.conn(ctx).Where("confirmed_at IS NULL AND cancelled_at IS NULL AND token_expiry < ?", before).Delete(&EmailChangeRequest{})
+	return int(result.RowsAffected), result.Error
+}
+
+type postgresUsernameHistoryRepository struct {
+	postgresRepository
+}
+
+func (r *postgresUsernameHistoryRepository) Create(ctx context.Context, history *UsernameHistory) error {
+	return r.conn(ctx).Create(history).Error
+}
+
+func (r *postgresUsernameHistoryRepository) FindLatestByUser(ctx context.Context, userID uuid.UUID) (*UsernameHistory, error) {
+	return r.latest(r.conn(ctx).Where(&UsernameHistory{UserID: userID}))
+}
+
+func (r *postgresUsernameHistoryRepository) FindLatestByOldUsername(ctx context.Context, oldUsernameCanonical string) (*UsernameHistory, error) {
+	if oldUsernameCanonical == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return r.latest(r.conn(ctx).Where("old_username_canonical = ?", oldUsernameCanonical))
+}
+
+func (r *postgresUsernameHistoryRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]UsernameHistory, error) {
+	var history []UsernameHistory
+	if err := r.conn(ctx).Where(&UsernameHistory{UserID: userID}).Order("changed_at").Find(&history).Error; err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func (r *postgresUsernameHistoryRepository) latest(query *gorm.DB) (*UsernameHistory, error) {
+	var history UsernameHistory
+	if err := query.Order("changed_at DESC").First(&history).Error; err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
+
+type postgresDataExportRepository struct {
+	postgresRepository
+}
+
+func (r *postgresDataExportRepository) Create(ctx context.Context, export *DataExport) error {
+	return r.conn(ctx).Create(export).Error
+}
+
+func (r *postgresDataExportRepository) Save(ctx context.Context, export *DataExport) error {
+	return r.conn(ctx).Save(export).Error
+}
+
+func (r *postgresDataExportRepository) FindByID(ctx context.Context, id uuid.UUID) (*DataExport, error) {
+	var export DataExport
+	if err := r.conn(ctx).Where(&DataExport{ID: id}).First(&export).Error; err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (r *postgresDataExportRepository) ExistsInProgress(ctx context.Context, userID uuid.UUID) (bool, error) {
+	return exists(r.conn(ctx).Model(&DataExport{}).Where("user_id = ? AND status IN ?", userID, []string{DataExportPending, DataExportProcessing}))
+}
+
+// FindPending skips exports locked by another transaction, so that several
+// workers can claim exports concurrently.
+func (r *postgresDataExportRepository) FindPending(ctx context.Context, limit int) ([]DataExport, error) {
+	var exports []DataExport
+	err := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ?", DataExportPending).
+		Order("created_at").
+		Limit(limit).
+		Find(&exports).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return exports, nil
+}
+
+func (r *postgresDataExportRepository) MarkProcessing(ctx context.Context, ids []uuid.UUID, startedAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.conn(ctx).Model(&DataExport{}).Where("id IN ?", ids).
+		Updates(map[string]any{"status": DataExportProcessing, "started_at": startedAt}).Error
+}
+
+func (r *postgresDataExportRepository) ResetStale(ctx context.Context, startedBefore time.Time) (int, error) {
+	result := r.conn(ctx).Model(&DataExport{}).
+		Where("status = ? AND started_at < ?", DataExportProcessing, startedBefore).
+		Updates(map[string]any{"status": DataExportPending, "started_at": nil})
+	return int(result.RowsAffected), result.Error
+}
+
+func (r *postgresDataExportRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result := r.conn(ctx).Where("expires_at < ?", before).Delete(&DataExport{})
+	return int(result.RowsAffected), result.Error
+}
+
+type postgresSAMLAssertionRepository struct {
+	postgresRepository
+}
+
+func (r *postgresSAMLAssertionRepository) Create(ctx context.Context, assertion *SAMLAssertion) error {
+	return r.conn(ctx).Create(assertion).Error
+}
+
+func (r *postgresSAMLAssertionRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	result := r.conn(ctx).Where("expires_at < ?", before).Delete(&SAMLAssertion{})
+	return int(result.RowsAffected), result.Error
+}
+
+type postgresWebhookEndpointRepository struct {
+	postgresRepository
+}
+
+func (r *postgresWebhookEndpointRepository) Create(ctx context.Context, endpoint *WebhookEndpoint) error {
+	return r.conn(ctx).Create(endpoint).Error
+}
+
+func (r *postgresWebhookEndpointRepository) Save(ctx context.Context, endpoint *WebhookEndpoint) error {
+	return r.conn(ctx).Save(endpoint).Error
+}
+
+func (r *postgresWebhookEndpointRepository) FindByID(ctx context.Context, id uuid.UUID) (*WebhookEndpoint, error) {
+	var endpoint WebhookEndpoint
+	if err := r.conn(ctx).Where(&WebhookEndpoint{ID: id}).First(&endpoint).Error; err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+func (r *postgresWebhookEndpointRepository) FindAll(ctx context.Context) ([]WebhookEndpoint, error) {
+	var endpoints []WebhookEndpoint
+	if err := r.conn(ctx).Order("created_at").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+func (r *postgresWebhookEndpointRepository) FindActive(ctx context.Context) ([]WebhookEndpoint, error) {
+	var endpoints []WebhookEndpoint
+	if err := r.conn(ctx).Where("active = ?", true).Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+
+	return endpoints, nil
+}
+
+// Delete leaves the deliveries to the foreign key, which cascades.
+func (r *postgresWebhookEndpointRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.conn(ctx).Where(&WebhookEndpoint{ID: id}).Delete(&WebhookEndpoint{}).Error
+}
+
+type postgresWebhookDeliveryRepository struct {
+	postgresRepository
+}
+
+func (r *postgresWebhookDeliveryRepository) Enqueue(ctx context.Context, deliveries []WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return r.conn(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+func (r *postgresWebhookDeliveryRepository) Save(ctx context.Context, delivery *WebhookDelivery) error {
+	return r.conn(ctx).Model(delivery).Updates(map[string]any{
+		"status":           delivery.Status,
+		"attempts":         delivery.Attempts,
+		"next_attempt_at":  delivery.NextAttemptAt,
+		"last_status_code": delivery.LastStatusCode,
+		"last_error":       delivery.LastError,
+		"delivered_at":     delivery.DeliveredAt,
+	}).Error
+}
+
+func (r *postgresWebhookDeliveryRepository) FindByID(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	if err := r.conn(ctx).Where(&WebhookDelivery{ID: id}).First(&delivery).Error; err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (r *postgresWebhookDeliveryRepository) FindByEndpoint(ctx context.Context, endpointID uuid.UUID, status string, offset int, limit int) ([]WebhookDelivery, int64, error) {
+	query := r.conn(ctx).Model(&WebhookDelivery{}).Where(&WebhookDelivery{EndpointID: endpointID})
+	if status != "" {
+		query = query.Where(&WebhookDelivery{Status: status})
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var deliveries []WebhookDelivery
+	if err := query.Order("created_at DESC, id").Offset(offset).Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return deliveries, total, nil
+}
+
+// FindDue skips deliveries locked by another transaction, so that several
+// workers can send deliveries concurrently.
+func (r *postgresWebhookDeliveryRepository) FindDue(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := r.conn(ctx).Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status IN ? AND next_attempt_at <= ?", []string{WebhookDeliveryPending, WebhookDeliveryRetrying}, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *postgresWebhookDeliveryRepository) Reschedule(ctx context.Context, ids []uuid.UUID, nextAttemptAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.conn(ctx).Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", nextAttemptAt).Error
+}
+
+func (r *postgresWebhookDeliveryRepository) CreateAttempt(ctx context.Context, attempt *WebhookDeliveryAttempt) error {
+	return r.conn(ctx).Create(attempt).Error
+}
+
+func (r *postgresWebhookDeliveryRepository) FindAttempts(ctx context.Context, deliveryID uuid.UUID) ([]WebhookDeliveryAttempt, error) {
+	var attempts []WebhookDeliveryAttempt
+	if err := r.conn(ctx).Where(&WebhookDeliveryAttempt{DeliveryID: deliveryID}).Order("created_at").Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func exists(query *gorm.DB) (bool, error) {
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
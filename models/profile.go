@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,7 +24,8 @@ type Profile struct {
 }
 
 func (p *Profile) CreateProfile(
-	db *gorm.DB,
+	ctx context.Context,
+	store Store,
 	userID uuid.UUID,
 	firstName string,
 	lastName string,
@@ -31,7 +33,7 @@ func (p *Profile) CreateProfile(
 	address string,
 	phone string,
 ) (*Profile, *errors.ApiError) {
-	err := db.Where(&Profile{UserID: userID}).First(&Profile{}).Error
+	_, err := store.Profiles().FindByUser(ctx, userID)
 	if err == nil {
 		return nil, errors.BadRequestError("profile for current user already exists")
 	}
@@ -39,7 +41,7 @@ func (p *Profile) CreateProfile(
 		return nil, errors.InternalServerError(err.Error())
 	}
 
-	if err := db.Where(&User{ID: userID}).First(&User{}).Error; err != nil {
+	if _, err := store.Users().FindByID(ctx, userID); err != nil {
 		if utils.IsRecordNotFound(err) {
 			return nil, errors.BadRequestError("user not found")
 		}
@@ -58,16 +60,22 @@ func (p *Profile) CreateProfile(
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
 	}
-	if err = db.Create(&profile).Error; err != nil {
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Profiles().Create(ctx, &profile); err != nil {
+			return err
+		}
+
+		return enqueueProfileUpdatedEvent(ctx, store, &profile)
+	})
+	if err != nil {
 		return nil, errors.InternalServerError(err.Error())
 	}
 
 	return &profile, nil
 }
 
-func (p *Profile) GetProfileByUser(db *gorm.DB, userID uuid.UUID) (*Profile, *errors.ApiError) {
-	var dbProfile Profile
-	err := db.Where(&Profile{UserID: userID}).First(&dbProfile).Error
+func (p *Profile) GetProfileByUser(ctx context.Context, store Store, userID uuid.UUID) (*Profile, *errors.ApiError) {
+	dbProfile, err := store.Profiles().FindByUser(ctx, userID)
 	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return nil, errors.BadRequestError("profile for user %v not found", userID)
@@ -76,11 +84,12 @@ func (p *Profile) GetProfileByUser(db *gorm.DB, userID uuid.UUID) (*Profile, *er
 		return nil, errors.InternalServerError(err.Error())
 	}
 
-	return &dbProfile, nil
+	return dbProfile, nil
 }
 
 func (p *Profile) UpdateProfileByUser(
-	db *gorm.DB,
+	ctx context.Context,
+	store Store,
 	userID uuid.UUID,
 	firstName string,
 	lastName string,
@@ -88,7 +97,7 @@ func (p *Profile) UpdateProfileByUser(
 	address string,
 	phone string,
 ) *errors.ApiError {
-	err := db.Where(&Profile{UserID: userID}).First(&p).Error
+	dbProfile, err := store.Profiles().FindByUser(ctx, userID)
 	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return errors.BadRequestError("profile for user %v not found", userID)
@@ -96,6 +105,7 @@ func (p *Profile) UpdateProfileByUser(
 
 		return errors.InternalServerError(err.Error())
 	}
+	*p = *dbProfile
 
 	p.FirstName = firstName
 	p.LastName = lastName
@@ -104,10 +114,24 @@ func (p *Profile) UpdateProfileByUser(
 	p.Phone = phone
 	p.UpdatedAt = time.Now().UTC()
 
-	err = db.Updates(&p).Error
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Profiles().Save(ctx, p); err != nil {
+			return err
+		}
+
+		return enqueueProfileUpdatedEvent(ctx, store, p)
+	})
 	if err != nil {
 		return errors.InternalServerError(err.Error())
 	}
 
 	return nil
 }
+
+func enqueueProfileUpdatedEvent(ctx context.Context, store Store, profile *Profile) error {
+	return enqueueEvent(ctx, store, UserProfileUpdatedEvent, profile.UserID, UserProfileUpdatedPayload{
+		UserID:    profile.UserID,
+		FirstName: profile.FirstName,
+		LastName:  profile.LastName,
+	})
+}
@@ -0,0 +1,401 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/scim"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"github.com/vantutran2k1/social-network-auth/validators"
+)
+
+const SCIMAuthProvider = "scim"
+
+// ProvisioningRequest holds the attributes an identity provider pushes for a user.
+type ProvisioningRequest struct {
+	Username   string
+	Email      string
+	Password   string
+	ExternalID *string
+	FirstName  string
+	LastName   string
+	Phone      string
+	Address    string
+	Active     bool
+}
+
+// SCIMUserAttributes are the SCIM attributes users can be filtered on, see
+// UserRepository.FindBySCIMFilter.
+var SCIMUserAttributes = map[string]scim.AttributeKind{
+	"id":                scim.StringAttribute,
+	"externalid":        scim.StringAttribute,
+	"username":          scim.StringAttribute,
+	"emails":            scim.StringAttribute,
+	"emails.value":      scim.StringAttribute,
+	"name.givenname":    scim.StringAttribute,
+	"name.familyname":   scim.StringAttribute,
+	"active":            scim.BooleanAttribute,
+	"meta.created":      scim.DateTimeAttribute,
+	"meta.lastmodified": scim.DateTimeAttribute,
+}
+
+// IsProvisioned tells whether the user is managed by an identity provider,
+// either provisioned through SCIM or known to the provider by an external
+// ID. SCIM only operates on such users.
+func (user *User) IsProvisioned() bool {
+	return user.AuthProvider == SCIMAuthProvider || user.ExternalID != nil
+}
+
+// GetUserWithProfile returns the user and their profile, including
+// deactivated (soft-deleted) ones. The profile is nil if the user has none.
+func (user *User) GetUserWithProfile(ctx context.Context, store Store, userID uuid.UUID) (*User, *Profile, *errors.ApiError) {
+	dbUser, err := store.Users().FindByIDWithDeleted(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, nil, errors.NotFoundError("user %v not found", userID)
+		}
+
+		return nil, nil, errors.InternalServerError(err.Error())
+	}
+
+	profile, err := getProfileWithDeleted(ctx, store, userID)
+	if err != nil {
+		return nil, nil, errors.InternalServerError(err.Error())
+	}
+
+	return dbUser, profile, nil
+}
+
+// GetProvisionedUserWithProfile is GetUserWithProfile for users managed by an
+// identity provider. Other users are not found.
+func (user *User) GetProvisionedUserWithProfile(ctx context.Context, store Store, userID uuid.UUID) (*User, *Profile, *errors.ApiError) {
+	if err := user.loadProvisioned(ctx, store, userID); err != nil {
+		return nil, nil, err
+	}
+
+	profile, err := getProfileWithDeleted(ctx, store, userID)
+	if err != nil {
+		return nil, nil, errors.InternalServerError(err.Error())
+	}
+
+	return user, profile, nil
+}
+
+// ListUsersWithProfiles returns a page of the users managed by an identity
+// provider, including deactivated ones, matching the filter, or all of them
+// if it is nil.
+func (user *User) ListUsersWithProfiles(
+	ctx context.Context,
+	store Store,
+	filter scim.Filter,
+	offset int,
+	limit int,
+) ([]User, map[uuid.UUID]*Profile, int64, *errors.ApiError) {
+	users, total, err := store.Users().FindBySCIMFilter(ctx, filter, offset, limit)
+	if err != nil {
+		return nil, nil, 0, errors.InternalServerError(err.Error())
+	}
+
+	userIDs := make([]uuid.UUID, 0, len(users))
+	for _, u := range users {
+		userIDs = append(userIDs, u.ID)
+	}
+
+	dbProfiles, err := store.Profiles().FindByUsersWithDeleted(ctx, userIDs)
+	if err != nil {
+		return nil, nil, 0, errors.InternalServerError(err.Error())
+	}
+
+	profiles := make(map[uuid.UUID]*Profile, len(dbProfiles))
+	for i := range dbProfiles {
+		profiles[dbProfiles[i].UserID] = &dbProfiles[i]
+	}
+
+	return users, profiles, total, nil
+}
+
+func (user *User) CreateProvisionedUser(ctx context.Context, store Store, policy *validators.RegistrationPolicy, request ProvisioningRequest) (*Profile, *errors.ApiError) {
+	if err := checkRegistrationPolicy(policy, nil, request.Username, request.Email); err != nil {
+		return nil, err
+	}
+	if err := checkUsernameAndEmailAvailable(ctx, store, request.Username, request.Email, uuid.Nil); err != nil {
+		return nil, err
+	}
+	if err := checkProvisionedUsername(ctx, store, request.Username, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	password, err := hashProvisionedPassword(ctx, request.Password)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	user.ID = uuid.New()
+	user.Username = request.Username
+	user.Email = request.Email
+	user.setCanonicalIdentity()
+	user.Password = password
+	user.Level = BRONZE
+	user.Status = AccountActive
+	user.AuthProvider = SCIMAuthProvider
+	user.ExternalID = request.ExternalID
+	user.CreatedAt = time.Now().UTC()
+	user.UpdatedAt = time.Now().UTC()
+
+	var profile *Profile
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Create(ctx, user); err != nil {
+			return err
+		}
+
+		if err := enqueueUserRegisteredEvent(ctx, store, user); err != nil {
+			return err
+		}
+
+		p, err := saveProvisionedProfile(ctx, store, user.ID, nil, request)
+		if err != nil {
+			return err
+		}
+		profile = p
+
+		if !request.Active {
+			// A new user has no token to revoke, so no token cache is
+			// needed.
+			return deactivateUser(ctx, store, nil, user.ID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	if !request.Active {
+		return user.reloadWithProfile(ctx, store, user.ID)
+	}
+
+	return profile, nil
+}
+
+// UpdateProvisionedUser replaces the user's attributes with the given ones.
+// Setting Active to false deactivates the user and revokes all their tokens.
+func (user *User) UpdateProvisionedUser(
+	ctx context.Context,
+	store Store,
+	cache TokenCache,
+	policy *validators.RegistrationPolicy,
+	userID uuid.UUID,
+	request ProvisioningRequest,
+) (*Profile, *errors.ApiError) {
+	if err := user.loadProvisioned(ctx, store, userID); err != nil {
+		return nil, err
+	}
+
+	if apiErr := checkRegistrationPolicy(policy, user, request.Username, request.Email); apiErr != nil {
+		return nil, apiErr
+	}
+
+	if apiErr := checkUsernameAndEmailAvailable(ctx, store, request.Username, request.Email, userID); apiErr != nil {
+		return nil, apiErr
+	}
+	// Users keep their username even if it became confusable with another
+	// one since they took it.
+	if utils.CanonicalizeUsername(request.Username) != user.UsernameCanonical {
+		if apiErr := checkProvisionedUsername(ctx, store, request.Username, userID); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+
+	existingProfile, err := getProfileWithDeleted(ctx, store, userID)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	wasActive := !user.DeletedAt.Valid
+	user.Username = request.Username
+	user.Email = request.Email
+	user.setCanonicalIdentity()
+	user.ExternalID = request.ExternalID
+	user.UpdatedAt = time.Now().UTC()
+	if request.Password != "" {
+		password, err := hashProvisionedPassword(ctx, request.Password)
+		if err != nil {
+			return nil, errors.InternalServerError(err.Error())
+		}
+		user.Password = password
+	}
+
+	var profile *Profile
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Save(ctx, user); err != nil {
+			return err
+		}
+
+		p, err := saveProvisionedProfile(ctx, store, userID, existingProfile, request)
+		if err != nil {
+			return err
+		}
+		profile = p
+
+		switch {
+		case wasActive && !request.Active:
+			return deactivateUser(ctx, store, cache, userID)
+		case !wasActive && request.Active:
+			return reactivateUser(ctx, store, userID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	if wasActive != request.Active {
+		return user.reloadWithProfile(ctx, store, userID)
+	}
+
+	return profile, nil
+}
+
+func (user *User) DeleteProvisionedUser(ctx context.Context, store Store, userID uuid.UUID) *errors.ApiError {
+	if err := user.loadProvisioned(ctx, store, userID); err != nil {
+		return err
+	}
+
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		return purgeUser(ctx, store, userID)
+	})
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// loadProvisioned loads the user, soft-deleted or not, as long as they are
+// managed by an identity provider, so that SCIM can not touch the accounts
+// users registered themselves.
+func (user *User) loadProvisioned(ctx context.Context, store Store, userID uuid.UUID) *errors.ApiError {
+	if err := user.loadWithDeleted(ctx, store, userID); err != nil {
+		return err
+	}
+	if !user.IsProvisioned() {
+		return errors.NotFoundError("user %v not found", userID)
+	}
+
+	return nil
+}
+
+func (user *User) reloadWithProfile(ctx context.Context, store Store, userID uuid.UUID) (*Profile, *errors.ApiError) {
+	dbUser, profile, err := user.GetUserWithProfile(ctx, store, userID)
+	if err != nil {
+		return nil, err
+	}
+	*user = *dbUser
+
+	return profile, nil
+}
+
+func saveProvisionedProfile(ctx context.Context, store Store, userID uuid.UUID, profile *Profile, request ProvisioningRequest) (*Profile, error) {
+	if profile == nil {
+		if request.FirstName == "" && request.LastName == "" && request.Phone == "" && request.Address == "" {
+			return nil, nil
+		}
+
+		profile = &Profile{
+			ID:        uuid.New(),
+			UserID:    userID,
+			FirstName: request.FirstName,
+			LastName:  request.LastName,
+			Phone:     request.Phone,
+			Address:   request.Address,
+			CreatedAt: time.Now().UTC(),
+			UpdatedAt: time.Now().UTC(),
+		}
+		if err := store.Profiles().Create(ctx, profile); err != nil {
+			return nil, err
+		}
+
+		return profile, enqueueProfileUpdatedEvent(ctx, store, profile)
+	}
+
+	profile.FirstName = request.FirstName
+	profile.LastName = request.LastName
+	profile.Phone = request.Phone
+	profile.Address = request.Address
+	profile.UpdatedAt = time.Now().UTC()
+
+	if err := store.Profiles().Save(ctx, profile); err != nil {
+		return nil, err
+	}
+
+	return profile, enqueueProfileUpdatedEvent(ctx, store, profile)
+}
+
+// getProfileWithDeleted returns nil if the user has no profile.
+func getProfileWithDeleted(ctx context.Context, store Store, userID uuid.UUID) (*Profile, error) {
+	profile, err := store.Profiles().FindByUserWithDeleted(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+// checkUsernameAndEmailAvailable also looks at soft-deleted users, since the
+// unique constraints on users cover them too.
+func checkUsernameAndEmailAvailable(ctx context.Context, store Store, username string, email string, excludeUserID uuid.UUID) *errors.ApiError {
+	taken, err := store.Users().ExistsWithUsernameOrEmail(ctx, utils.CanonicalizeUsername(username), utils.CanonicalizeEmail(email), excludeUserID)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if taken {
+		return errors.ConflictError("username or email already exists")
+	}
+
+	return nil
+}
+
+// checkProvisionedUsername applies the checks of usernames chosen by users
+// to the ones pushed by identity providers.
+func checkProvisionedUsername(ctx context.Context, store Store, username string, userID uuid.UUID) *errors.ApiError {
+	if err := checkUsernameNotHeld(ctx, store, username, userID); err != nil {
+		return err
+	}
+
+	return checkUsernameNotConfusable(ctx, store, username, userID)
+}
+
+// checkRegistrationPolicy applies the registration policy to the username
+// and email an identity provider gives a user. current is the user they
+// replace the ones of, if any: users keep what they have even if the policy
+// changed since they got it.
+func checkRegistrationPolicy(policy *validators.RegistrationPolicy, current *User, username string, email string) *errors.ApiError {
+	var errs []validators.ErrorMessage
+	if current == nil || utils.CanonicalizeUsername(username) != current.UsernameCanonical {
+		errs = append(errs, policy.ValidateUsername("username", username)...)
+	}
+	if current == nil || utils.CanonicalizeEmail(email) != current.EmailCanonical {
+		errs = append(errs, policy.ValidateEmail("email", email)...)
+	}
+
+	if len(errs) > 0 {
+		return errors.BadRequestError("%s: %s", errs[0].Field, errs[0].Message)
+	}
+
+	return nil
+}
+
+func hashProvisionedPassword(ctx context.Context, password string) (string, error) {
+	if password == "" {
+		return generateUnusablePassword(ctx)
+	}
+
+	return utils.HashPassword(ctx, password)
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// ExpiredDataPurge counts the rows PurgeExpiredData deleted.
+type ExpiredDataPurge struct {
+	EmailChangeRequests int64
+	DataExports         int64
+	SAMLAssertions      int64
+}
+
+// PurgeExpiredData deletes the pending email changes, data exports and used
+// SAML assertions that expired before the given time. Tokens are purged in
+// batches by PurgeExpiredTokens and PurgeExpiredPasswordResetTokens.
+func PurgeExpiredData(ctx context.Context, store Store, before time.Time) (*ExpiredDataPurge, error) {
+	purge := &ExpiredDataPurge{}
+
+	deleted, err := store.EmailChangeRequests().DeleteExpired(ctx, before)
+	if err != nil {
+		return purge, err
+	}
+	purge.EmailChangeRequests = int64(deleted)
+
+	deleted, err = store.DataExports().DeleteExpired(ctx, before)
+	if err != nil {
+		return purge, err
+	}
+	purge.DataExports = int64(deleted)
+
+	deleted, err = store.SAMLAssertions().DeleteExpired(ctx, before)
+	if err != nil {
+		return purge, err
+	}
+	purge.SAMLAssertions = int64(deleted)
+
+	return purge, nil
+}
+
+// PurgeExpiredTokens deletes the tokens that expired before the given time,
+// at most batchSize per statement so that locks are held briefly, and
+// returns how many were deleted.
+func PurgeExpiredTokens(ctx context.Context, store Store, before time.Time, batchSize int) (int, error) {
+	return purgeInBatches(ctx, batchSize, func(ctx context.Context, limit int) (int, error) {
+		return store.Tokens().DeleteExpired(ctx, before, limit)
+	})
+}
+
+// PurgeExpiredPasswordResetTokens deletes the password reset tokens that
+// expired before the given time, at most batchSize per statement, and
+// returns how many were deleted.
+func PurgeExpiredPasswordResetTokens(ctx context.Context, store Store, before time.Time, batchSize int) (int, error) {
+	return purgeInBatches(ctx, batchSize, func(ctx context.Context, limit int) (int, error) {
+		return store.PasswordResetTokens().DeleteExpired(ctx, before, limit)
+	})
+}
+
+// purgeInBatches calls deleteBatch until it deletes less than a full batch
+// or the context is done.
+func purgeInBatches(ctx context.Context, batchSize int, deleteBatch func(ctx context.Context, limit int) (int, error)) (int, error) {
+	purged := 0
+	for {
+		deleted, err := deleteBatch(ctx, batchSize)
+		purged += deleted
+		if err != nil {
+			return purged, err
+		}
+		if deleted < batchSize {
+			return purged, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+	}
+}
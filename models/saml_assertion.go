@@ -0,0 +1,35 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+// SAMLAssertion is an assertion a user signed in with. It is kept until it
+// expires, so that an assertion captured on its way to the service can not
+// be used to sign in again.
+type SAMLAssertion struct {
+	IdentityProvider string    `gorm:"primary_key"`
+	AssertionID      string    `gorm:"primary_key"`
+	ExpiresAt        time.Time `gorm:"not null"`
+}
+
+// Consume records that the assertion was used, and fails if it already was.
+func (a *SAMLAssertion) Consume(ctx context.Context, store Store) *errors.ApiError {
+	if a.AssertionID == "" {
+		return errors.UnauthorizedError("assertion has no ID")
+	}
+
+	if err := store.SAMLAssertions().Create(ctx, a); err != nil {
+		if utils.IsUniqueViolation(err) {
+			return errors.UnauthorizedError("assertion was already used")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,289 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/scim"
+	"github.com/vantutran2k1/social-network-auth/transaction"
+)
+
+// Store gives access to the repositories everything the service keeps is
+// persisted through. Implementations return gorm.ErrRecordNotFound when a lookup finds
+// nothing, so that callers can keep using utils.IsRecordNotFound.
+//
+// Repository methods run in the transaction their context carries, if any,
+// see WithTransaction.
+type Store interface {
+	Users() UserRepository
+	Tokens() TokenRepository
+	Profiles() ProfileRepository
+	PasswordResetTokens() PasswordResetTokenRepository
+	InviteCodes() InviteCodeRepository
+	AccountStatusChanges() AccountStatusChangeRepository
+	AuditEvents() AuditEventRepository
+	OutboxEvents() OutboxEventRepository
+	EmailChangeRequests() EmailChangeRequestRepository
+	UsernameHistory() UsernameHistoryRepository
+	DataExports() DataExportRepository
+	WebhookEndpoints() WebhookEndpointRepository
+	WebhookDeliveries() WebhookDeliveryRepository
+	SAMLAssertions() SAMLAssertionRepository
+
+	// WithTransaction runs fn in a transaction carried by the context fn is
+	// given, whose changes are committed if fn returns nil and rolled back
+	// otherwise. A transaction started with a context that already carries
+	// one is nested in it, and only its own changes are rolled back if it
+	// fails. See transaction.TransactionManager for the options.
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error, opts ...transaction.Option) error
+}
+
+// UserRepository looks users up by their canonical username and email, see
+// utils.CanonicalizeUsername and utils.CanonicalizeEmail. Soft-deleted users
+// are only returned by the methods that say so.
+type UserRepository interface {
+	Create(ctx context.Context, user *User) error
+	// Save updates the user, soft-deleted or not, DeletedAt included.
+	Save(ctx context.Context, user *User) error
+	FindByID(ctx context.Context, id uuid.UUID) (*User, error)
+	// FindByIDForUpdate returns the user and keeps other transactions from
+	// locking them until the transaction ends, which serializes changes
+	// bounded by a per-user limit.
+	FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*User, error)
+	// FindByIDWithDeleted also returns soft-deleted users.
+	FindByIDWithDeleted(ctx context.Context, id uuid.UUID) (*User, error)
+	// FindByIDsWithDeleted returns the users with the given IDs, soft-deleted
+	// or not, in no particular order.
+	FindByIDsWithDeleted(ctx context.Context, ids []uuid.UUID) ([]User, error)
+	FindByUsername(ctx context.Context, usernameCanonical string) (*User, error)
+	// FindByUsernameWithDeleted also returns soft-deleted users.
+	FindByUsernameWithDeleted(ctx context.Context, usernameCanonical string) (*User, error)
+	FindByEmail(ctx context.Context, emailCanonical string) (*User, error)
+	FindByUsernameOrEmail(ctx context.Context, usernameCanonical string, emailCanonical string) (*User, error)
+	FindByExternalID(ctx context.Context, provider string, externalID string) (*User, error)
+	// ExistsWithUsernameOrEmail tells whether a user other than exceptID has
+	// the given username or email. It also considers soft-deleted users,
+	// whose username and email can still be restored. An empty username or
+	// email matches no user.
+	ExistsWithUsernameOrEmail(ctx context.Context, usernameCanonical string, emailCanonical string, exceptID uuid.UUID) (bool, error)
+	// ExistsWithUsernameSkeleton tells whether a user other than exceptID,
+	// soft-deleted or not, has the given username skeleton.
+	ExistsWithUsernameSkeleton(ctx context.Context, skeleton string, exceptID uuid.UUID) (bool, error)
+	// IsUsernameHeld tells whether the username was released by a user other
+	// than exceptID and is still within its hold period at now.
+	IsUsernameHeld(ctx context.Context, usernameCanonical string, exceptID uuid.UUID, now time.Time) (bool, error)
+	// List returns up to limit users matching the filter, soft-deleted or
+	// not, oldest first, that come after after if it is not nil.
+	List(ctx context.Context, filter UserFilter, after *ListPosition, limit int) ([]User, error)
+	// FindBySCIMFilter returns a page of the users managed by an identity
+	// provider (see User.IsProvisioned), soft-deleted or not, matching the
+	// filter, oldest first, along with how many match in total. A nil filter
+	// matches all of them. The filter can refer to the attributes in
+	// SCIMUserAttributes.
+	FindBySCIMFilter(ctx context.Context, filter scim.Filter, offset int, limit int) ([]User, int64, error)
+	// LiftExpiredSuspension makes the user active for the given reason if
+	// they are suspended until now or earlier, and tells whether it did.
+	LiftExpiredSuspension(ctx context.Context, id uuid.UUID, reason string, now time.Time) (bool, error)
+	// FindScheduledForPurge returns the IDs of up to limit users whose purge
+	// was scheduled at or before now.
+	FindScheduledForPurge(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error)
+	// Purge permanently deletes the user along with everything that
+	// references them.
+	Purge(ctx context.Context, id uuid.UUID) error
+}
+
+type TokenRepository interface {
+	Create(ctx context.Context, token *Token) error
+	// Save updates the given tokens.
+	Save(ctx context.Context, tokens ...*Token) error
+	FindByToken(ctx context.Context, tokenString string) (*Token, error)
+	// FindActiveByUser returns the tokens of the user that have not expired
+	// at now.
+	FindActiveByUser(ctx context.Context, userID uuid.UUID, now time.Time) ([]*Token, error)
+	// FindByUser returns all tokens of the user, oldest first.
+	FindByUser(ctx context.Context, userID uuid.UUID) ([]Token, error)
+	// DeleteExpired deletes up to limit tokens that expired before the given
+	// time and returns how many were deleted.
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+type ProfileRepository interface {
+	Create(ctx context.Context, profile *Profile) error
+	// Save updates the profile, soft-deleted or not, but for DeletedAt.
+	Save(ctx context.Context, profile *Profile) error
+	FindByUser(ctx context.Context, userID uuid.UUID) (*Profile, error)
+	// FindByUserWithDeleted also returns soft-deleted profiles.
+	FindByUserWithDeleted(ctx context.Context, userID uuid.UUID) (*Profile, error)
+	// FindByUsersWithDeleted returns the profiles of the given users,
+	// soft-deleted or not.
+	FindByUsersWithDeleted(ctx context.Context, userIDs []uuid.UUID) ([]Profile, error)
+	// SetDeletedByUser soft-deletes the profile of the user at deletedAt, or
+	// restores it if deletedAt is nil. Users without a profile are ignored.
+	SetDeletedByUser(ctx context.Context, userID uuid.UUID, deletedAt *time.Time) error
+}
+
+type PasswordResetTokenRepository interface {
+	Create(ctx context.Context, token *PasswordResetToken) error
+	// FindValid returns the token of the user that has not expired at now.
+	FindValid(ctx context.Context, userID uuid.UUID, tokenString string, now time.Time) (*PasswordResetToken, error)
+	// FindByUser returns all tokens of the user, oldest first.
+	FindByUser(ctx context.Context, userID uuid.UUID) ([]PasswordResetToken, error)
+	// DeleteExpired deletes up to limit tokens that expired before the given
+	// time and returns how many were deleted.
+	DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+type InviteCodeRepository interface {
+	Create(ctx context.Context, code *InviteCode) error
+	Save(ctx context.Context, code *InviteCode) error
+	FindByID(ctx context.Context, id uuid.UUID) (*InviteCode, error)
+	// FindByCreator returns the codes created by the user, most recent first.
+	FindByCreator(ctx context.Context, userID uuid.UUID) ([]InviteCode, error)
+	// CountCreatedSince counts the codes the user created at or after since,
+	// revoked, expired and used up ones included.
+	CountCreatedSince(ctx context.Context, userID uuid.UUID, since time.Time) (int, error)
+	// List returns a page of the codes, most recent first, optionally only
+	// those created by createdBy, along with how many there are in total.
+	List(ctx context.Context, createdBy *uuid.UUID, offset int, limit int) ([]InviteCode, int64, error)
+	// FindUses returns the uses of the code, oldest first.
+	FindUses(ctx context.Context, codeID uuid.UUID) ([]InviteCodeUse, error)
+	// FindRedeemable returns the usable invite code with the given code and
+	// keeps others from redeeming it until the transaction ends, so that
+	// concurrent registrations can not exceed MaxUses.
+	FindRedeemable(ctx context.Context, code string, now time.Time) (*InviteCode, error)
+	// Redeem saves the use count of code along with the use.
+	Redeem(ctx context.Context, code *InviteCode, use *InviteCodeUse) error
+}
+
+type AccountStatusChangeRepository interface {
+	Create(ctx context.Context, change *AccountStatusChange) error
+	// FindByUser returns the changes of the user, oldest first.
+	FindByUser(ctx context.Context, userID uuid.UUID) ([]AccountStatusChange, error)
+}
+
+type AuditEventRepository interface {
+	Create(ctx context.Context, event *AuditEvent) error
+	// Find returns up to limit events matching the filter, most recent
+	// first, that come after before if it is not nil.
+	Find(ctx context.Context, filter AuditEventFilter, before *ListPosition, limit int) ([]AuditEvent, error)
+}
+
+type OutboxEventRepository interface {
+	Create(ctx context.Context, event *OutboxEvent) error
+	Save(ctx context.Context, event *OutboxEvent) error
+	// FindUnpublished returns up to limit unpublished events that are
+	// neither dead nor claimed at now, in the order they were written, and
+	// keeps other transactions from fetching them until the transaction
+	// ends.
+	FindUnpublished(ctx context.Context, now time.Time, limit int) ([]OutboxEvent, error)
+	// Claim sets the time until which the events are claimed, or releases
+	// them if claimedUntil is nil.
+	Claim(ctx context.Context, ids []uuid.UUID, claimedUntil *time.Time) error
+}
+
+type EmailChangeRequestRepository interface {
+	Create(ctx context.Context, request *EmailChangeRequest) error
+	// Confirm marks the request confirmed at now if it is neither confirmed,
+	// cancelled nor expired then, and tells whether it did. A confirmation
+	// and a cancellation racing each other can not both succeed.
+	Confirm(ctx context.Context, id uuid.UUID, now time.Time) (bool, error)
+	// Cancel is Confirm for cancellations.
+	Cancel(ctx context.Context, id uuid.UUID, now time.Time) (bool, error)
+	// FindPendingByConfirmToken returns the request that is neither
+	// confirmed, cancelled nor expired at now with the given confirm token.
+	FindPendingByConfirmToken(ctx context.Context, confirmToken string, now time.Time) (*EmailChangeRequest, error)
+	// FindPendingByCancelToken is FindPendingByConfirmToken for cancel tokens.
+	FindPendingByCancelToken(ctx context.Context, cancelToken string, now time.Time) (*EmailChangeRequest, error)
+	// FindByUser returns the requests of the user, oldest first.
+	FindByUser(ctx context.Context, userID uuid.UUID) ([]EmailChangeRequest, error)
+	// CancelPendingByUser cancels the requests of the user that are neither
+	// confirmed nor cancelled yet.
+	CancelPendingByUser(ctx context.Context, userID uuid.UUID, at time.Time) error
+	// DeleteExpired deletes the requests that were neither confirmed nor
+	// cancelled and expired before the given time, and returns how many
+	// were deleted.
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+type UsernameHistoryRepository interface {
+	Create(ctx context.Context, history *UsernameHistory) error
+	// FindLatestByUser returns the last username change of the user.
+	FindLatestByUser(ctx context.Context, userID uuid.UUID) (*UsernameHistory, error)
+	// FindLatestByOldUsername returns the last change away from the given
+	// username.
+	FindLatestByOldUsername(ctx context.Context, oldUsernameCanonical string) (*UsernameHistory, error)
+	// FindByUser returns the username changes of the user, oldest first.
+	FindByUser(ctx context.Context, userID uuid.UUID) ([]UsernameHistory, error)
+}
+
+type DataExportRepository interface {
+	Create(ctx context.Context, export *DataExport) error
+	Save(ctx context.Context, export *DataExport) error
+	FindByID(ctx context.Context, id uuid.UUID) (*DataExport, error)
+	// ExistsInProgress tells whether the user has an export that is pending
+	// or processing.
+	ExistsInProgress(ctx context.Context, userID uuid.UUID) (bool, error)
+	// FindPending returns up to limit pending exports, oldest first, and
+	// keeps other transactions from fetching them until the transaction
+	// ends.
+	FindPending(ctx context.Context, limit int) ([]DataExport, error)
+	// MarkProcessing marks the exports as processing since startedAt.
+	MarkProcessing(ctx context.Context, ids []uuid.UUID, startedAt time.Time) error
+	// ResetStale makes the exports processing since before the given time
+	// pending again and returns how many there were.
+	ResetStale(ctx context.Context, startedBefore time.Time) (int, error)
+	// DeleteExpired deletes the exports that expired before the given time
+	// and returns how many were deleted.
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+type WebhookEndpointRepository interface {
+	Create(ctx context.Context, endpoint *WebhookEndpoint) error
+	Save(ctx context.Context, endpoint *WebhookEndpoint) error
+	FindByID(ctx context.Context, id uuid.UUID) (*WebhookEndpoint, error)
+	// FindAll returns the endpoints, oldest first.
+	FindAll(ctx context.Context) ([]WebhookEndpoint, error)
+	FindActive(ctx context.Context) ([]WebhookEndpoint, error)
+	// Delete deletes the endpoint along with its deliveries.
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type WebhookDeliveryRepository interface {
+	// Enqueue creates the deliveries, but for those whose event is already
+	// queued for their endpoint.
+	Enqueue(ctx context.Context, deliveries []WebhookDelivery) error
+	// Save updates the status and the outcome of the last attempt of the
+	// delivery.
+	Save(ctx context.Context, delivery *WebhookDelivery) error
+	FindByID(ctx context.Context, id uuid.UUID) (*WebhookDelivery, error)
+	// FindByEndpoint returns a page of the deliveries to the endpoint, most
+	// recent first, optionally only those with the given status, along with
+	// how many there are in total.
+	FindByEndpoint(ctx context.Context, endpointID uuid.UUID, status string, offset int, limit int) ([]WebhookDelivery, int64, error)
+	// FindDue returns up to limit deliveries waiting to be sent whose next
+	// attempt is due at now, earliest first, and keeps other transactions
+	// from fetching them until the transaction ends.
+	FindDue(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	// Reschedule sets the time of the next attempt of the deliveries.
+	Reschedule(ctx context.Context, ids []uuid.UUID, nextAttemptAt time.Time) error
+	CreateAttempt(ctx context.Context, attempt *WebhookDeliveryAttempt) error
+	// FindAttempts returns the attempts of the delivery, oldest first.
+	FindAttempts(ctx context.Context, deliveryID uuid.UUID) ([]WebhookDeliveryAttempt, error)
+}
+
+type SAMLAssertionRepository interface {
+	// Create fails with a unique violation if the identity provider's
+	// assertion was already recorded.
+	Create(ctx context.Context, assertion *SAMLAssertion) error
+	// DeleteExpired deletes the assertions that expired before the given
+	// time and returns how many were deleted.
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// ListPosition is the position of a row in a list ordered by creation time
+// and ID, which cursors of paginated lists encode.
+type ListPosition struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
@@ -1,15 +1,17 @@
 package models
 
 import (
-	"os"
-	"strconv"
+	"context"
+	"log"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
 	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/metrics"
+	"github.com/vantutran2k1/social-network-auth/transaction"
 	"github.com/vantutran2k1/social-network-auth/utils"
-	"gorm.io/gorm"
 )
 
 type Token struct {
@@ -18,52 +20,80 @@ type Token struct {
 	Token     string    `json:"token" gorm:"not null"`
 	IssuedAt  time.Time `json:"issued_at" gorm:"not null"`
 	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+	// ImpersonatorID is set on tokens an admin uses to act as the user.
+	ImpersonatorID *uuid.UUID `json:"impersonator_id,omitempty"`
 }
 
-func (token *Token) CreateLoginToken(db *gorm.DB, userID uuid.UUID) (*Token, *errors.ApiError) {
-	expirationAfter, err := strconv.Atoi(os.Getenv("JWT_EXPIRATION_MINUTES"))
-	if err != nil {
-		return nil, errors.InternalServerError(err.Error())
-	}
+// TokenCache remembers the tokens found valid, so that Validate does not
+// need a database lookup. Tokens revoked through Revoke and
+// RevokeUserActiveTokens are evicted from it on every replica.
+//
+// Only tokens of active users are cached. A user becoming inactive has all
+// their tokens revoked, so a cached token needs no status check either.
+type TokenCache interface {
+	// IsValid tells whether the token is cached as valid.
+	IsValid(ctx context.Context, tokenString string) bool
+	// Add caches a token found valid, until it expires at the latest.
+	Add(ctx context.Context, token *Token)
+	// Revoke evicts the tokens from the cache.
+	Revoke(ctx context.Context, tokens ...*Token) error
+}
 
-	expirationTime := time.Now().UTC().Add(time.Duration(expirationAfter) * time.Minute)
-	claims := &utils.Claims{
-		UserID:         userID,
-		StandardClaims: jwt.StandardClaims{ExpiresAt: expirationTime.Unix()},
+func (token *Token) CreateLoginToken(ctx context.Context, store Store, cfg *config.TokenConfig, userID uuid.UUID) (*Token, *errors.ApiError) {
+	return createToken(ctx, store, []byte(cfg.JWTKey), userID, nil, cfg.Expiration)
+}
+
+// CreateImpersonationToken issues a short-lived token that lets the admin
+// impersonatorID act as userID. The token is marked with the impersonator, so
+// that sensitive actions can be refused and every request audited.
+func (token *Token) CreateImpersonationToken(ctx context.Context, store Store, cfg *config.TokenConfig, userID uuid.UUID, impersonatorID uuid.UUID) (*Token, *errors.ApiError) {
+	if userID == impersonatorID {
+		return nil, errors.BadRequestError("can not impersonate yourself")
 	}
-	tokenJwt := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := tokenJwt.SignedString(utils.JwtKey)
+
+	user, err := store.Users().FindByID(ctx, userID)
 	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, errors.NotFoundError("user %v not found", userID)
+		}
+
 		return nil, errors.InternalServerError(err.Error())
 	}
 
-	t := Token{
-		ID:        uuid.New(),
-		UserID:    userID,
-		Token:     tokenString,
-		IssuedAt:  time.Now().UTC(),
-		ExpiresAt: expirationTime,
+	if user.IsAdmin {
+		return nil, errors.BadRequestError("admins can not be impersonated")
 	}
-	if err := db.Create(&t).Error; err != nil {
-		return nil, errors.InternalServerError(err.Error())
+
+	if err := user.CheckStatus(ctx, store); err != nil {
+		return nil, err
 	}
 
-	return &t, nil
+	return createToken(ctx, store, []byte(cfg.JWTKey), userID, &impersonatorID, cfg.ImpersonationExpiration)
 }
 
-func (token *Token) Validate(db *gorm.DB, tokenString string) bool {
-	var dbToken Token
-	err := db.Where(&Token{Token: tokenString}).First(&dbToken).Error
-	if err != nil {
-		return false
+// Validate fails unless the token is saved, has not expired and belongs to
+// an active user, see CheckStatus.
+func (token *Token) Validate(ctx context.Context, store Store, cache TokenCache, tokenString string) *errors.ApiError {
+	if cache.IsValid(ctx, tokenString) {
+		return nil
 	}
 
-	return !dbToken.isExpired()
+	dbToken, err := store.Tokens().FindByToken(ctx, tokenString)
+	if err != nil || dbToken.isExpired() {
+		return errors.UnauthorizedError("token not found or expired")
+	}
+
+	var user User
+	if err := user.CheckUserStatus(ctx, store, dbToken.UserID); err != nil {
+		return err
+	}
+
+	cache.Add(ctx, dbToken)
+	return nil
 }
 
-func (token *Token) Revoke(db *gorm.DB, tokenString string) *errors.ApiError {
-	var dbToken Token
-	err := db.Where(&Token{Token: tokenString}).First(&dbToken).Error
+func (token *Token) Revoke(ctx context.Context, store Store, cache TokenCache, tokenString string) *errors.ApiError {
+	dbToken, err := store.Tokens().FindByToken(ctx, tokenString)
 	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return errors.BadRequestError("token not found")
@@ -74,54 +104,132 @@ func (token *Token) Revoke(db *gorm.DB, tokenString string) *errors.ApiError {
 
 	dbToken.ExpiresAt = time.Now().UTC()
 
-	if err := db.Save(&dbToken).Error; err != nil {
+	if err := store.Tokens().Save(ctx, dbToken); err != nil {
 		return errors.InternalServerError(err.Error())
 	}
 
+	if err := evictRevokedTokens(ctx, cache, dbToken); err != nil {
+		return err
+	}
+	metrics.TokenRevocations.Inc()
+
 	return nil
 }
 
-func (token *Token) RevokeUserActiveTokens(db *gorm.DB, userID uuid.UUID) *errors.ApiError {
-	activeTokens, err := token.getActiveTokensByUser(db, userID)
+func (token *Token) RevokeUserActiveTokens(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID) *errors.ApiError {
+	return token.revokeUserActiveTokens(ctx, store, cache, userID, uuid.Nil)
+}
+
+// RevokeUserActiveTokensExcept revokes all active tokens of the user but the
+// one with the given ID, typically the session making the request.
+func (token *Token) RevokeUserActiveTokensExcept(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID, exceptTokenID uuid.UUID) *errors.ApiError {
+	return token.revokeUserActiveTokens(ctx, store, cache, userID, exceptTokenID)
+}
+
+func (token *Token) GetByTokenString(ctx context.Context, store Store, tokenString string) (*Token, *errors.ApiError) {
+	dbToken, err := store.Tokens().FindByToken(ctx, tokenString)
 	if err != nil {
-		return err
+		if utils.IsRecordNotFound(err) {
+			return nil, errors.BadRequestError("token not found")
+		}
+
+		return nil, errors.InternalServerError(err.Error())
 	}
 
-	if len(activeTokens) == 0 {
-		return nil
+	return dbToken, nil
+}
+
+func (token *Token) revokeUserActiveTokens(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID, exceptTokenID uuid.UUID) *errors.ApiError {
+	activeTokens, err := token.getActiveTokensByUser(ctx, store, userID)
+	if err != nil {
+		return err
 	}
 
+	tokensToRevoke := make([]*Token, 0, len(activeTokens))
 	for _, t := range activeTokens {
-		t.ExpiresAt = time.Now().UTC()
+		if t.ID != exceptTokenID {
+			t.ExpiresAt = time.Now().UTC()
+			tokensToRevoke = append(tokensToRevoke, t)
+		}
 	}
 
-	if err := db.Save(activeTokens).Error; err != nil {
+	if len(tokensToRevoke) == 0 {
+		return nil
+	}
+
+	if err := store.Tokens().Save(ctx, tokensToRevoke...); err != nil {
 		return errors.InternalServerError(err.Error())
 	}
 
+	if err := evictRevokedTokens(ctx, cache, tokensToRevoke...); err != nil {
+		return err
+	}
+	metrics.TokenRevocations.Add(float64(len(tokensToRevoke)))
+
 	return nil
 }
 
-func (token *Token) getActiveTokensByUser(db *gorm.DB, userID uuid.UUID) ([]*Token, *errors.ApiError) {
-	var tokens []*Token
-	if err := db.Where(&Token{UserID: userID}).Find(&tokens).Error; err != nil {
-		if utils.IsRecordNotFound(err) {
-			return []*Token{}, nil
+// evictRevokedTokens evicts the tokens from the cache once the transaction
+// ctx carries, if any, commits. Evicting them earlier would let a concurrent
+// request find them still valid in the database and cache them again.
+func evictRevokedTokens(ctx context.Context, cache TokenCache, tokens ...*Token) *errors.ApiError {
+	if transaction.CommitHooksFrom(ctx) == nil {
+		if err := cache.Revoke(ctx, tokens...); err != nil {
+			return errors.InternalServerError(err.Error())
 		}
 
-		return nil, errors.InternalServerError(err.Error())
+		return nil
 	}
 
-	activeTokens := make([]*Token, 0, len(tokens))
-	for _, t := range tokens {
-		if !t.isExpired() {
-			activeTokens = append(activeTokens, t)
+	// The revocation is committed by then, so a failure to evict can only
+	// be logged. Other replicas evict the tokens when their local cache
+	// entries expire at the latest.
+	transaction.AfterCommit(ctx, func() {
+		if err := cache.Revoke(ctx, tokens...); err != nil {
+			log.Printf("failed to evict revoked tokens from the cache: %v", err)
 		}
+	})
+
+	return nil
+}
+
+func (token *Token) getActiveTokensByUser(ctx context.Context, store Store, userID uuid.UUID) ([]*Token, *errors.ApiError) {
+	tokens, err := store.Tokens().FindActiveByUser(ctx, userID, time.Now().UTC())
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
 	}
 
-	return activeTokens, nil
+	return tokens, nil
 }
 
 func (token *Token) isExpired() bool {
 	return time.Now().UTC().After(token.ExpiresAt)
 }
+
+func createToken(ctx context.Context, store Store, jwtKey []byte, userID uuid.UUID, impersonatorID *uuid.UUID, expiration time.Duration) (*Token, *errors.ApiError) {
+	expirationTime := time.Now().UTC().Add(expiration)
+	claims := &utils.Claims{
+		UserID:         userID,
+		ImpersonatorID: impersonatorID,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expirationTime.Unix()},
+	}
+	tokenJwt := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := tokenJwt.SignedString(jwtKey)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	t := Token{
+		ID:             uuid.New(),
+		UserID:         userID,
+		Token:          tokenString,
+		IssuedAt:       time.Now().UTC(),
+		ExpiresAt:      expirationTime,
+		ImpersonatorID: impersonatorID,
+	}
+	if err := store.Tokens().Create(ctx, &t); err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return &t, nil
+}
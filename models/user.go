@@ -2,85 +2,139 @@ package models
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"text/template"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/vantutran2k1/social-network-auth/errors"
-	"github.com/vantutran2k1/social-network-auth/transaction"
+	"github.com/vantutran2k1/social-network-auth/metrics"
 	"github.com/vantutran2k1/social-network-auth/utils"
-	"golang.org/x/crypto/bcrypt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+var tracer = otel.Tracer("github.com/vantutran2k1/social-network-auth/models")
+
 type User struct {
-	ID        uuid.UUID `json:"id" gorm:"primary_key"`
-	Username  string    `json:"username" gorm:"unique;not null"`
-	Password  string    `json:"-" gorm:"not null"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Level     Level     `json:"level" gorm:"not null"`
-	CreatedAt time.Time `json:"created_at" gorm:"not null;autoCreateTime:false"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"not null;autoUpdateTime:false"`
-	DeletedAt gorm.DeletedAt
+	ID                uuid.UUID     `json:"id" gorm:"primary_key"`
+	Username          string        `json:"username" gorm:"unique;not null"`
+	Password          string        `json:"-" gorm:"not null"`
+	Email             string        `json:"email" gorm:"unique;not null"`
+	UsernameCanonical string        `json:"-" gorm:"unique;not null"`
+	EmailCanonical    string        `json:"-" gorm:"unique;not null"`
+	UsernameSkeleton  string        `json:"-"`
+	Level             Level         `json:"level" gorm:"not null"`
+	AuthProvider      string        `json:"auth_provider" gorm:"not null"`
+	ExternalID        *string       `json:"-"`
+	ScheduledPurgeAt  *time.Time    `json:"scheduled_purge_at,omitempty"`
+	ReferredBy        *uuid.UUID    `json:"referred_by,omitempty"`
+	Status            AccountStatus `json:"status" gorm:"not null"`
+	StatusReason      string        `json:"status_reason,omitempty"`
+	SuspendedUntil    *time.Time    `json:"suspended_until,omitempty"`
+	IsAdmin           bool          `json:"is_admin" gorm:"not null"`
+	CreatedAt         time.Time     `json:"created_at" gorm:"not null;autoCreateTime:false"`
+	UpdatedAt         time.Time     `json:"updated_at" gorm:"not null;autoUpdateTime:false"`
+	DeletedAt         gorm.DeletedAt
 }
 
 func (user *User) Register(
-	db *gorm.DB,
+	ctx context.Context,
+	store Store,
 	username string,
 	password string,
 	email string,
+	inviteCode string,
 ) *errors.ApiError {
-	err := db.Where(&User{Username: username}).Or(&User{Email: email}).First(&User{}).Error
-	if err == nil {
+	taken, err := store.Users().ExistsWithUsernameOrEmail(ctx, utils.CanonicalizeUsername(username), utils.CanonicalizeEmail(email), uuid.Nil)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if taken {
 		return errors.BadRequestError("username or email already exists")
 	}
 
-	if !utils.IsRecordNotFound(err) {
-		return errors.InternalServerError(err.Error())
+	if err := checkUsernameNotHeld(ctx, store, username, uuid.Nil); err != nil {
+		return err
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := checkUsernameNotConfusable(ctx, store, username, uuid.Nil); err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(ctx, password)
 	if err != nil {
 		return errors.InternalServerError(err.Error())
 	}
-	user.Password = string(hashedPassword)
+	user.Password = hashedPassword
 
 	user.ID = uuid.New()
 	user.Level = BRONZE
+	user.Status = AccountActive
+	user.AuthProvider = LocalAuthProvider
 	user.Username = username
 	user.Email = email
+	user.setCanonicalIdentity()
 
 	user.CreatedAt = time.Now().UTC()
 	user.UpdatedAt = time.Now().UTC()
 
-	if err := db.Create(&user).Error; err != nil {
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		var code *InviteCode
+		if inviteCode != "" {
+			c, err := store.InviteCodes().FindRedeemable(ctx, inviteCode, time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			code = c
+			user.ReferredBy = code.CreatedBy
+		}
+
+		if err := store.Users().Create(ctx, user); err != nil {
+			return err
+		}
+
+		if code != nil {
+			if err := code.redeem(ctx, store, user.ID); err != nil {
+				return err
+			}
+		}
+
+		return enqueueUserRegisteredEvent(ctx, store, user)
+	})
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.BadRequestError("invalid or expired invite code")
+		}
+
 		return errors.InternalServerError(err.Error())
 	}
+	metrics.Registrations.Inc()
 
 	return nil
 }
 
-func (user *User) Authenticate(db *gorm.DB, username string, password string) (*User, *errors.ApiError) {
-	var dbUser User
-	if err := db.Where(&User{Username: username}).First(&dbUser).Error; err != nil {
-		if utils.IsRecordNotFound(err) {
-			return nil, errors.BadRequestError("user %s not found", username)
-		}
-
-		return nil, errors.InternalServerError(err.Error())
+func (user *User) Authenticate(ctx context.Context, store Store, authenticator Authenticator, username string, password string) (*User, *errors.ApiError) {
+	dbUser, err := authenticator.Authenticate(ctx, store, username, password)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(password)); err != nil {
-		return nil, errors.BadRequestError("invalid password")
+	if err := dbUser.CheckStatus(ctx, store); err != nil {
+		return nil, err
 	}
 
-	return &dbUser, nil
+	return dbUser, nil
 }
 
-func (user *User) UpdateLevel(db *gorm.DB, userID uuid.UUID, levelName string) *errors.ApiError {
-	err := db.Where(&User{ID: userID}).First(&user).Error
+func (user *User) UpdateLevel(ctx context.Context, store Store, userID uuid.UUID, levelName string) *errors.ApiError {
+	dbUser, err := store.Users().FindByID(ctx, userID)
 	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return errors.BadRequestError("user %v not found", userID)
@@ -88,32 +142,48 @@ func (user *User) UpdateLevel(db *gorm.DB, userID uuid.UUID, levelName string) *
 
 		return errors.InternalServerError(err.Error())
 	}
+	*user = *dbUser
 
 	level := GetLevelFromName(levelName)
 	if user.Level == level {
 		return nil
 	}
+	oldLevel := user.Level
 	user.Level = level
 
 	user.UpdatedAt = time.Now().UTC()
 
-	if err := db.Save(user).Error; err != nil {
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Save(ctx, user); err != nil {
+			return err
+		}
+
+		return enqueueEvent(ctx, store, UserLevelChangedEvent, user.ID, UserLevelChangedPayload{
+			UserID:   user.ID,
+			OldLevel: oldLevel,
+			NewLevel: level,
+		})
+	})
+	if err != nil {
 		return errors.InternalServerError(err.Error())
 	}
+	metrics.LevelChanges.WithLabelValues(string(level)).Inc()
 
 	return nil
 }
 
-func (user *User) UpdatePassword(db *gorm.DB, currentPassword string, newPassword string) *errors.ApiError {
-	if err := db.Where(&User{ID: user.ID}).First(&user).Error; err != nil {
+func (user *User) UpdatePassword(ctx context.Context, store Store, cache TokenCache, currentPassword string, newPassword string) *errors.ApiError {
+	dbUser, err := store.Users().FindByID(ctx, user.ID)
+	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return errors.BadRequestError("user not found")
 		}
 
 		return errors.InternalServerError(err.Error())
 	}
+	*user = *dbUser
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(currentPassword)); err != nil {
+	if err := utils.ComparePassword(ctx, user.Password, currentPassword); err != nil {
 		return errors.BadRequestError("invalid password")
 	}
 
@@ -121,28 +191,29 @@ func (user *User) UpdatePassword(db *gorm.DB, currentPassword string, newPasswor
 		return errors.BadRequestError("new password can not be the same as current one")
 	}
 
-	if err := user.updatePassword(db, newPassword); err != nil {
+	if err := user.updatePassword(ctx, store, cache, newPassword); err != nil {
 		return errors.InternalServerError(err.Error())
 	}
 
 	return nil
 }
 
-func (user *User) ResetPassword(db *gorm.DB, email string, resetToken string, newPassword string, confirmPassword string) *errors.ApiError {
+func (user *User) ResetPassword(ctx context.Context, store Store, cache TokenCache, email string, resetToken string, newPassword string, confirmPassword string) *errors.ApiError {
 	if newPassword != confirmPassword {
 		return errors.BadRequestError("comfirm password does not match with new password")
 	}
 
-	var u User
-	if err := db.Where(&User{Email: email}).First(&u).Error; err != nil {
+	u, err := store.Users().FindByEmail(ctx, utils.CanonicalizeEmail(email))
+	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return errors.BadRequestError("can not find user with email %v", email)
 		}
 
 		return errors.InternalServerError(err.Error())
 	}
+	user.ID = u.ID
 
-	if err := db.Where("token = ? AND token_expiry > ? AND user_id = ?", resetToken, time.Now().UTC(), u.ID).First(&PasswordResetToken{}).Error; err != nil {
+	if _, err := store.PasswordResetTokens().FindValid(ctx, u.ID, resetToken, time.Now().UTC()); err != nil {
 		if utils.IsRecordNotFound(err) {
 			return errors.BadRequestError("invalid or expired token")
 		}
@@ -150,16 +221,23 @@ func (user *User) ResetPassword(db *gorm.DB, email string, resetToken string, ne
 		return errors.InternalServerError(err.Error())
 	}
 
-	if err := u.updatePassword(db, newPassword); err != nil {
+	if err := u.updatePassword(ctx, store, cache, newPassword); err != nil {
 		return errors.InternalServerError(err.Error())
 	}
+	metrics.PasswordResetsCompleted.Inc()
 
 	return nil
 }
 
-func (user *User) GetUserByUsernameOrEmail(db *gorm.DB, userIdentity string) (*User, *errors.ApiError) {
-	var dbUser User
-	if err := db.Where(&User{Username: userIdentity}).Or(&User{Email: userIdentity}).First(&dbUser).Error; err != nil {
+func (user *User) GetUserByUsernameOrEmail(ctx context.Context, store Store, userIdentity string) (*User, *errors.ApiError) {
+	usernameCanonical := utils.CanonicalizeUsername(userIdentity)
+	emailCanonical := utils.CanonicalizeEmail(userIdentity)
+	if usernameCanonical == "" || emailCanonical == "" {
+		return nil, errors.BadRequestError("user not found")
+	}
+
+	dbUser, err := store.Users().FindByUsernameOrEmail(ctx, usernameCanonical, emailCanonical)
+	if err != nil {
 		if utils.IsRecordNotFound(err) {
 			return nil, errors.BadRequestError("user not found")
 		}
@@ -167,62 +245,233 @@ func (user *User) GetUserByUsernameOrEmail(db *gorm.DB, userIdentity string) (*U
 		return nil, errors.InternalServerError(err.Error())
 	}
 
-	return &dbUser, nil
+	return dbUser, nil
 }
 
-func (user *User) SendResetPasswordEmail(db *gorm.DB, email string, resetToken string) *errors.ApiError {
-	body, err := parsePasswordResetTemplate(resetToken)
+func (user *User) SendResetPasswordEmail(ctx context.Context, mailer utils.Mailer, email string, resetToken string) *errors.ApiError {
+	body, err := parsePasswordResetTemplate(ctx, resetToken)
 	if err != nil {
 		return errors.InternalServerError(err.Error())
 	}
 
 	subject := "Password Reset Request"
-	return utils.SendEmail(email, subject, body)
+	return mailer.SendEmail(ctx, email, subject, body)
+}
+
+// setCanonicalIdentity derives the columns usernames and emails are compared
+// by. It must be called whenever Username or Email change.
+func (user *User) setCanonicalIdentity() {
+	user.UsernameCanonical = utils.CanonicalizeUsername(user.Username)
+	user.EmailCanonical = utils.CanonicalizeEmail(user.Email)
+	user.UsernameSkeleton = utils.UsernameSkeleton(user.Username)
 }
 
-func parsePasswordResetTemplate(resetToken string) (string, error) {
-	htmlFilePath := filepath.Join("email", "password_reset", "password_reset.html")
+// canonicalIdentityLockID is the key of the advisory lock held while
+// backfilling canonical identities, so that replicas starting at the same
+// time do not create the unique indexes twice.
+const canonicalIdentityLockID = 4729301859
+
+// BackfillCanonicalIdentities fills in the canonical usernames, emails and
+// username skeletons of users created before they were introduced, using
+// the same canonicalization as the rest of the application. It then makes
+// the canonical columns unique, failing with the colliding values if two
+// users have the same canonical username or email, so that they can be
+// resolved by hand first.
+func (user *User) BackfillCanonicalIdentities(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", canonicalIdentityLockID).Error; err != nil {
+			return err
+		}
+
+		var users []User
+		err := tx.Unscoped().
+			Where("username_canonical IS NULL OR email_canonical IS NULL OR username_skeleton IS NULL").
+			FindInBatches(&users, 500, func(batchTx *gorm.DB, batch int) error {
+				for _, u := range users {
+					u.setCanonicalIdentity()
+					err := tx.Unscoped().Model(&User{}).Where("id = ?", u.ID).Updates(map[string]any{
+						"username_canonical": u.UsernameCanonical,
+						"email_canonical":    u.EmailCanonical,
+						"username_skeleton":  u.UsernameSkeleton,
+					}).Error
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			}).Error
+		if err != nil {
+			return err
+		}
+
+		var history []UsernameHistory
+		err = tx.Where("old_username_canonical IS NULL").FindInBatches(&history, 500, func(batchTx *gorm.DB, batch int) error {
+			for _, h := range history {
+				err := tx.Model(&UsernameHistory{}).Where("id = ?", h.ID).
+					Update("old_username_canonical", utils.CanonicalizeUsername(h.OldUsername)).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		for _, column := range []string{"username_canonical", "email_canonical"} {
+			var collisions []string
+			err := tx.Unscoped().Model(&User{}).Group(column).Having("COUNT(*) > 1").Limit(20).Pluck(column, &collisions).Error
+			if err != nil {
+				return err
+			}
+			if len(collisions) > 0 {
+				return fmt.Errorf("users share the same %s: %s", column, strings.Join(collisions, ", "))
+			}
+		}
+
+		statements := []string{
+			"ALTER TABLE users ALTER COLUMN username_canonical SET NOT NULL",
+			"ALTER TABLE users ALTER COLUMN email_canonical SET NOT NULL",
+			"ALTER TABLE username_history ALTER COLUMN old_username_canonical SET NOT NULL",
+			"CREATE UNIQUE INDEX IF NOT EXISTS users_username_canonical_idx ON users (username_canonical)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS users_email_canonical_idx ON users (email_canonical)",
+		}
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// checkUsernameNotConfusable fails if a user other than userID has a
+// username that looks like the given one.
+func checkUsernameNotConfusable(ctx context.Context, store Store, username string, userID uuid.UUID) *errors.ApiError {
+	confusable, err := store.Users().ExistsWithUsernameSkeleton(ctx, utils.UsernameSkeleton(username), userID)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if confusable {
+		return errors.BadRequestError("username is too similar to an existing username")
+	}
+
+	return nil
+}
+
+func parsePasswordResetTemplate(ctx context.Context, resetToken string) (string, error) {
+	return parseEmailTemplate(ctx, "password_reset", struct{ ResetToken string }{ResetToken: resetToken})
+}
+
+// parseEmailTemplate renders email/<name>/<name>.html with the stylesheet
+// next to it inlined, since most email clients ignore linked stylesheets.
+func parseEmailTemplate(ctx context.Context, name string, data any) (string, error) {
+	_, span := tracer.Start(ctx, "email.RenderTemplate", trace.WithAttributes(attribute.String("email.template", name)))
+	defer span.End()
+
+	htmlFilePath := filepath.Join("email", name, name+".html")
 	htmlContent, err := os.ReadFile(htmlFilePath)
 	if err != nil {
 		return "", err
 	}
 
-	cssFilePath := filepath.Join("email", "password_reset", "password_reset.css")
+	cssFilePath := filepath.Join("email", name, name+".css")
 	cssContent, err := os.ReadFile(cssFilePath)
 	if err != nil {
 		return "", err
 	}
 
-	htmlWithCSS := bytes.Replace(htmlContent, []byte(`<link rel="stylesheet" href="password_reset.css">`), []byte("<style>"+string(cssContent)+"</style>"), 1)
-	tmpl, err := template.New("passwordReset").Parse(string(htmlWithCSS))
+	link := fmt.Sprintf(`<link rel="stylesheet" href="%s.css" />`, name)
+	htmlWithCSS := bytes.Replace(htmlContent, []byte(link), []byte("<style>"+string(cssContent)+"</style>"), 1)
+	tmpl, err := template.New(name).Parse(string(htmlWithCSS))
 	if err != nil {
 		return "", err
 	}
 
 	var body bytes.Buffer
-	if err := tmpl.Execute(&body, struct{ ResetToken string }{ResetToken: resetToken}); err != nil {
+	if err := tmpl.Execute(&body, data); err != nil {
 		return "", err
 	}
 
 	return body.String(), nil
 }
 
-func (user *User) updatePassword(db *gorm.DB, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (user *User) updatePassword(ctx context.Context, store Store, cache TokenCache, password string) error {
+	hashedPassword, err := utils.HashPassword(ctx, password)
 	if err != nil {
 		return err
 	}
-	user.Password = string(hashedPassword)
+	user.Password = hashedPassword
 	user.UpdatedAt = time.Now().UTC()
 
-	err = transaction.TxManager.WithTransaction(func(tx *gorm.DB) error {
+	return store.WithTransaction(ctx, func(ctx context.Context) error {
 		token := &Token{}
-		if err := token.RevokeUserActiveTokens(db, user.ID); err != nil {
+		if err := token.RevokeUserActiveTokens(ctx, store, cache, user.ID); err != nil {
 			return err
 		}
 
-		return db.Save(user).Error
+		return store.Users().Save(ctx, user)
 	})
+}
+
+// deactivateUser soft-deletes the user and their profile and revokes all
+// their active tokens.
+func deactivateUser(ctx context.Context, store Store, cache TokenCache, userID uuid.UUID) error {
+	user, err := store.Users().FindByIDWithDeleted(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	user.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	if err := store.Users().Save(ctx, user); err != nil {
+		return err
+	}
+
+	if err := store.Profiles().SetDeletedByUser(ctx, userID, &now); err != nil {
+		return err
+	}
+
+	token := &Token{}
+	if err := token.RevokeUserActiveTokens(ctx, store, cache, userID); err != nil {
+		return err
+	}
+
+	return enqueueEvent(ctx, store, UserDeletedEvent, userID, UserDeletedPayload{
+		UserID:           userID,
+		ScheduledPurgeAt: user.ScheduledPurgeAt,
+	})
+}
+
+// reactivateUser undoes deactivateUser, along with a scheduled purge.
+func reactivateUser(ctx context.Context, store Store, userID uuid.UUID) error {
+	user, err := store.Users().FindByIDWithDeleted(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	user.DeletedAt = gorm.DeletedAt{}
+	user.ScheduledPurgeAt = nil
+	if err := store.Users().Save(ctx, user); err != nil {
+		return err
+	}
+
+	if err := store.Profiles().SetDeletedByUser(ctx, userID, nil); err != nil {
+		return err
+	}
+
+	return enqueueEvent(ctx, store, UserRestoredEvent, userID, UserIDPayload{UserID: userID})
+}
+
+// purgeUser permanently deletes the user and everything that references them.
+func purgeUser(ctx context.Context, store Store, userID uuid.UUID) error {
+	if err := store.Users().Purge(ctx, userID); err != nil {
+		return err
+	}
 
-	return err
+	return enqueueEvent(ctx, store, UserPurgedEvent, userID, UserIDPayload{UserID: userID})
 }
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+// UsernameHistory records a username change. The old username stays reserved
+// for the user until HeldUntil, so nobody else can take over their links.
+type UsernameHistory struct {
+	ID                   uuid.UUID `json:"id" gorm:"primary_key"`
+	UserID               uuid.UUID `json:"user_id" gorm:"not null"`
+	OldUsername          string    `json:"old_username" gorm:"not null"`
+	OldUsernameCanonical string    `json:"-" gorm:"not null"`
+	NewUsername          string    `json:"new_username" gorm:"not null"`
+	ChangedAt            time.Time `json:"changed_at" gorm:"not null"`
+	HeldUntil            time.Time `json:"held_until" gorm:"not null"`
+}
+
+func (UsernameHistory) TableName() string {
+	return "username_history"
+}
+
+// ChangeUsername renames the user, unless they already did so within the
+// change cooldown. Their old username is held for the hold period before
+// someone else can claim it.
+func (user *User) ChangeUsername(ctx context.Context, store Store, cfg *config.UsernameConfig, userID uuid.UUID, newUsername string) *errors.ApiError {
+	dbUser, err := store.Users().FindByID(ctx, userID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.BadRequestError("user not found")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*user = *dbUser
+
+	if user.Username == newUsername {
+		return errors.BadRequestError("new username can not be the same as current one")
+	}
+
+	lastChange, err := store.UsernameHistory().FindLatestByUser(ctx, userID)
+	if err == nil {
+		nextChangeAt := lastChange.ChangedAt.Add(cfg.ChangeCooldown)
+		if time.Now().UTC().Before(nextChangeAt) {
+			return errors.BadRequestError("username can not be changed again before %v", nextChangeAt.Format(time.RFC3339))
+		}
+	} else if !utils.IsRecordNotFound(err) {
+		return errors.InternalServerError(err.Error())
+	}
+
+	taken, err := store.Users().ExistsWithUsernameOrEmail(ctx, utils.CanonicalizeUsername(newUsername), "", userID)
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if taken {
+		return errors.ConflictError("username already exists")
+	}
+
+	if err := checkUsernameNotHeld(ctx, store, newUsername, userID); err != nil {
+		return err
+	}
+
+	if err := checkUsernameNotConfusable(ctx, store, newUsername, userID); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	history := UsernameHistory{
+		ID:                   uuid.New(),
+		UserID:               userID,
+		OldUsername:          user.Username,
+		OldUsernameCanonical: user.UsernameCanonical,
+		NewUsername:          newUsername,
+		ChangedAt:            now,
+		HeldUntil:            now.Add(cfg.Hold),
+	}
+
+	user.Username = newUsername
+	user.setCanonicalIdentity()
+	user.UpdatedAt = now
+
+	err = store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.Users().Save(ctx, user); err != nil {
+			return err
+		}
+
+		return store.UsernameHistory().Create(ctx, &history)
+	})
+	if err != nil {
+		if utils.IsUniqueViolation(err) {
+			return errors.ConflictError("username already exists")
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// ResolveUsername returns the user currently or previously known by username,
+// so that links using an old username keep working.
+func (user *User) ResolveUsername(ctx context.Context, store Store, username string) (*User, *errors.ApiError) {
+	canonicalUsername := utils.CanonicalizeUsername(username)
+	dbUser, err := store.Users().FindByUsername(ctx, canonicalUsername)
+	if err == nil {
+		return dbUser, nil
+	}
+	if !utils.IsRecordNotFound(err) {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	history, err := store.UsernameHistory().FindLatestByOldUsername(ctx, canonicalUsername)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, errors.NotFoundError("user %s not found", username)
+		}
+
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	dbUser, err = store.Users().FindByID(ctx, history.UserID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return nil, errors.NotFoundError("user %s not found", username)
+		}
+
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return dbUser, nil
+}
+
+// checkUsernameNotHeld fails if username was recently released by a user
+// other than userID and is still within its hold period.
+func checkUsernameNotHeld(ctx context.Context, store Store, username string, userID uuid.UUID) *errors.ApiError {
+	held, err := store.Users().IsUsernameHeld(ctx, utils.CanonicalizeUsername(username), userID, time.Now().UTC())
+	if err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+	if held {
+		return errors.ConflictError("username %s is reserved", username)
+	}
+
+	return nil
+}
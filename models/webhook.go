@@ -0,0 +1,348 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+const (
+	WebhookDeliveryPending   = "PENDING"
+	WebhookDeliverySucceeded = "SUCCEEDED"
+	WebhookDeliveryRetrying  = "RETRYING"
+	WebhookDeliveryDead      = "DEAD"
+)
+
+// WebhookEndpoint is a partner URL that receives the events listed in
+// EventTypes, or all events if it is empty. Deliveries are signed with Secret.
+type WebhookEndpoint struct {
+	ID          uuid.UUID  `json:"id" gorm:"primary_key"`
+	URL         string     `json:"url" gorm:"not null"`
+	Secret      string     `json:"-" gorm:"not null"`
+	EventTypes  []string   `json:"event_types" gorm:"serializer:json;not null"`
+	Description string     `json:"description"`
+	Active      bool       `json:"active" gorm:"not null"`
+	CreatedBy   *uuid.UUID `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"not null;autoCreateTime:false"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"not null;autoUpdateTime:false"`
+}
+
+// WebhookDelivery is an event to be sent to an endpoint. Failed deliveries
+// are retried until they succeed or run out of attempts and become dead.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id" gorm:"primary_key"`
+	EndpointID     uuid.UUID       `json:"endpoint_id" gorm:"not null"`
+	EventID        uuid.UUID       `json:"event_id" gorm:"not null"`
+	EventType      string          `json:"event_type" gorm:"not null"`
+	Payload        json.RawMessage `json:"payload" gorm:"type:jsonb;not null"`
+	Status         string          `json:"status" gorm:"not null"`
+	Attempts       int             `json:"attempts" gorm:"not null"`
+	NextAttemptAt  time.Time       `json:"next_attempt_at" gorm:"not null"`
+	LastStatusCode *int            `json:"last_status_code"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at" gorm:"not null;autoCreateTime:false"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// WebhookDeliveryAttempt logs a single attempt to send a delivery.
+type WebhookDeliveryAttempt struct {
+	ID         uuid.UUID `json:"id" gorm:"primary_key"`
+	DeliveryID uuid.UUID `json:"delivery_id" gorm:"not null"`
+	StatusCode *int      `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at" gorm:"not null;autoCreateTime:false"`
+}
+
+// CreateWebhookEndpoint registers an endpoint. A secret is generated if
+// none is given.
+func (w *WebhookEndpoint) CreateWebhookEndpoint(
+	ctx context.Context,
+	store Store,
+	createdBy uuid.UUID,
+	url string,
+	secret string,
+	eventTypes []string,
+	description string,
+) *errors.ApiError {
+	if secret == "" {
+		s, err := generateWebhookSecret()
+		if err != nil {
+			return errors.InternalServerError(err.Error())
+		}
+		secret = s
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	w.ID = uuid.New()
+	w.URL = url
+	w.Secret = secret
+	w.EventTypes = eventTypes
+	w.Description = description
+	w.Active = true
+	w.CreatedBy = &createdBy
+	w.CreatedAt = time.Now().UTC()
+	w.UpdatedAt = time.Now().UTC()
+	if err := store.WebhookEndpoints().Create(ctx, w); err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+func (w *WebhookEndpoint) GetWebhookEndpoint(ctx context.Context, store Store, endpointID uuid.UUID) *errors.ApiError {
+	endpoint, err := store.WebhookEndpoints().FindByID(ctx, endpointID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("webhook endpoint %v not found", endpointID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*w = *endpoint
+
+	return nil
+}
+
+func (w *WebhookEndpoint) ListWebhookEndpoints(ctx context.Context, store Store) ([]WebhookEndpoint, *errors.ApiError) {
+	endpoints, err := store.WebhookEndpoints().FindAll(ctx)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return endpoints, nil
+}
+
+// UpdateWebhookEndpoint replaces the endpoint's settings. The secret is only
+// changed if a new one is given.
+func (w *WebhookEndpoint) UpdateWebhookEndpoint(
+	ctx context.Context,
+	store Store,
+	endpointID uuid.UUID,
+	url string,
+	secret string,
+	eventTypes []string,
+	description string,
+	active bool,
+) *errors.ApiError {
+	if err := w.GetWebhookEndpoint(ctx, store, endpointID); err != nil {
+		return err
+	}
+
+	if secret != "" {
+		w.Secret = secret
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	w.URL = url
+	w.EventTypes = eventTypes
+	w.Description = description
+	w.Active = active
+	w.UpdatedAt = time.Now().UTC()
+	if err := store.WebhookEndpoints().Save(ctx, w); err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// DeleteWebhookEndpoint deletes the endpoint along with its delivery log.
+func (w *WebhookEndpoint) DeleteWebhookEndpoint(ctx context.Context, store Store, endpointID uuid.UUID) *errors.ApiError {
+	if err := w.GetWebhookEndpoint(ctx, store, endpointID); err != nil {
+		return err
+	}
+
+	if err := store.WebhookEndpoints().Delete(ctx, endpointID); err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+// Accepts reports whether the endpoint subscribed to the event type.
+func (w *WebhookEndpoint) Accepts(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range w.EventTypes {
+		if t == "*" || t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EnqueueWebhookDeliveries creates a delivery of the event for each active
+// endpoint subscribed to it. Enqueueing the same event again is a no-op.
+func EnqueueWebhookDeliveries(ctx context.Context, store Store, eventID uuid.UUID, eventType string, payload []byte) error {
+	endpoints, err := store.WebhookEndpoints().FindActive(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var deliveries []WebhookDelivery
+	for _, endpoint := range endpoints {
+		if !endpoint.Accepts(eventType) {
+			continue
+		}
+
+		deliveries = append(deliveries, WebhookDelivery{
+			ID:            uuid.New(),
+			EndpointID:    endpoint.ID,
+			EventID:       eventID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        WebhookDeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	return store.WebhookDeliveries().Enqueue(ctx, deliveries)
+}
+
+// ClaimDueWebhookDeliveries returns up to limit deliveries that are due and
+// pushes their next attempt back by lease, so that other workers leave them
+// alone while they are being sent.
+func ClaimDueWebhookDeliveries(ctx context.Context, store Store, limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := store.WithTransaction(ctx, func(ctx context.Context) error {
+		due, err := store.WebhookDeliveries().FindDue(ctx, time.Now().UTC(), limit)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, 0, len(due))
+		for _, d := range due {
+			ids = append(ids, d.ID)
+		}
+		if err := store.WebhookDeliveries().Reschedule(ctx, ids, time.Now().UTC().Add(lease)); err != nil {
+			return err
+		}
+
+		deliveries = due
+		return nil
+	})
+
+	return deliveries, err
+}
+
+// RecordAttempt logs an attempt and updates the delivery with its result.
+// On failure, the delivery is retried after retryAfter, or becomes dead if
+// retryAfter is nil.
+func (d *WebhookDelivery) RecordAttempt(ctx context.Context, store Store, statusCode *int, attemptErr error, duration time.Duration, retryAfter *time.Duration) error {
+	now := time.Now().UTC()
+	attempt := WebhookDeliveryAttempt{
+		ID:         uuid.New(),
+		DeliveryID: d.ID,
+		StatusCode: statusCode,
+		DurationMs: duration.Milliseconds(),
+		CreatedAt:  now,
+	}
+
+	d.Attempts++
+	d.LastStatusCode = statusCode
+	d.LastError = ""
+	switch {
+	case attemptErr == nil:
+		d.Status = WebhookDeliverySucceeded
+		d.DeliveredAt = &now
+	case retryAfter != nil:
+		attempt.Error = attemptErr.Error()
+		d.LastError = attempt.Error
+		d.Status = WebhookDeliveryRetrying
+		d.NextAttemptAt = now.Add(*retryAfter)
+	default:
+		attempt.Error = attemptErr.Error()
+		d.LastError = attempt.Error
+		d.Status = WebhookDeliveryDead
+	}
+
+	return store.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := store.WebhookDeliveries().CreateAttempt(ctx, &attempt); err != nil {
+			return err
+		}
+
+		return store.WebhookDeliveries().Save(ctx, d)
+	})
+}
+
+// ListWebhookDeliveries returns a page of the endpoint's deliveries, most
+// recent first, optionally only those with the given status.
+func (d *WebhookDelivery) ListWebhookDeliveries(ctx context.Context, store Store, endpointID uuid.UUID, status string, offset int, limit int) ([]WebhookDelivery, int64, *errors.ApiError) {
+	deliveries, total, err := store.WebhookDeliveries().FindByEndpoint(ctx, endpointID, status, offset, limit)
+	if err != nil {
+		return nil, 0, errors.InternalServerError(err.Error())
+	}
+
+	return deliveries, total, nil
+}
+
+func (d *WebhookDelivery) GetWebhookDelivery(ctx context.Context, store Store, deliveryID uuid.UUID) ([]WebhookDeliveryAttempt, *errors.ApiError) {
+	if err := d.load(ctx, store, deliveryID); err != nil {
+		return nil, err
+	}
+
+	attempts, err := store.WebhookDeliveries().FindAttempts(ctx, deliveryID)
+	if err != nil {
+		return nil, errors.InternalServerError(err.Error())
+	}
+
+	return attempts, nil
+}
+
+// Redeliver queues the delivery to be sent again right away with a fresh
+// set of attempts, whatever its current status.
+func (d *WebhookDelivery) Redeliver(ctx context.Context, store Store, deliveryID uuid.UUID) *errors.ApiError {
+	if err := d.load(ctx, store, deliveryID); err != nil {
+		return err
+	}
+
+	d.Status = WebhookDeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now().UTC()
+	if err := store.WebhookDeliveries().Save(ctx, d); err != nil {
+		return errors.InternalServerError(err.Error())
+	}
+
+	return nil
+}
+
+func (d *WebhookDelivery) load(ctx context.Context, store Store, deliveryID uuid.UUID) *errors.ApiError {
+	delivery, err := store.WebhookDeliveries().FindByID(ctx, deliveryID)
+	if err != nil {
+		if utils.IsRecordNotFound(err) {
+			return errors.NotFoundError("webhook delivery %v not found", deliveryID)
+		}
+
+		return errors.InternalServerError(err.Error())
+	}
+	*d = *delivery
+
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(bytes), nil
+}
@@ -1,30 +1,119 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/vantutran2k1/social-network-auth/config"
 	"github.com/vantutran2k1/social-network-auth/controllers"
 	"github.com/vantutran2k1/social-network-auth/middlewares"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func SetupRouter() *gin.Engine {
+// Handlers are the handlers the API is served by.
+type Handlers struct {
+	Auth       *controllers.AuthHandler
+	Admin      *controllers.AdminHandler
+	Audit      *controllers.AuditHandler
+	DataExport *controllers.DataExportHandler
+	InviteCode *controllers.InviteCodeHandler
+	Profile    *controllers.ProfileHandler
+	SAML       *controllers.SAMLHandler
+	SCIM       *controllers.SCIMHandler
+	Webhook    *controllers.WebhookHandler
+	// Metrics serves the Prometheus metrics.
+	Metrics http.Handler
+}
+
+// SetupRouter routes the API to h. Tokens are verified with the key in cfg
+// against the ones saved in store or cached in tokenCache.
+func SetupRouter(h *Handlers, store models.Store, tokenCache models.TokenCache, cfg *config.Config) *gin.Engine {
 	router := gin.Default()
+	router.Use(otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/metrics"
+	})))
+	router.Use(middlewares.RequestIDMiddleware())
+	router.Use(middlewares.MetricsMiddleware())
+
+	router.GET("/metrics", gin.WrapH(h.Metrics))
+
+	requireAuth := middlewares.AuthMiddleware(store, tokenCache, []byte(cfg.Tokens.JWTKey))
+	requireAdmin := middlewares.AdminMiddleware(store)
+
+	router.POST("/api/auth/register", h.Auth.Register)
+	router.POST("/api/auth/login", h.Auth.Login)
+	router.POST("/api/auth/logout", requireAuth, h.Auth.Logout)
+
+	router.GET("/api/auth/saml/login", h.SAML.SAMLLogin)
+	router.GET("/api/auth/saml/:idp/metadata", h.SAML.SAMLMetadata)
+	router.POST("/api/auth/saml/:idp/acs", h.SAML.SAMLAssertionConsumer)
+
+	router.DELETE("/api/auth/account", requireAuth, middlewares.BlockImpersonationMiddleware(), h.Auth.DeleteAccount)
+	router.POST("/api/auth/account/restore", h.Auth.RestoreAccount)
+
+	router.POST("/api/auth/data-export", requireAuth, middlewares.BlockImpersonationMiddleware(), h.DataExport.RequestDataExport)
+	router.GET("/api/auth/data-export/:id", requireAuth, middlewares.BlockImpersonationMiddleware(), h.DataExport.GetDataExport)
+	router.GET("/api/auth/data-export/:id/download", h.DataExport.DownloadDataExport)
+
+	router.PUT("/api/auth/password", requireAuth, middlewares.BlockImpersonationMiddleware(), h.Auth.UpdatePassword)
+
+	router.PUT("/api/auth/email", requireAuth, middlewares.BlockImpersonationMiddleware(), h.Auth.UpdateEmail)
+	router.POST("/api/auth/email/confirm", h.Auth.ConfirmEmailChange)
+	router.POST("/api/auth/email/cancel", h.Auth.CancelEmailChange)
+
+	router.PUT("/api/auth/username", requireAuth, middlewares.BlockImpersonationMiddleware(), h.Auth.UpdateUsername)
+	router.GET("/api/auth/username/resolve", h.Auth.ResolveUsername)
+
+	router.POST("/api/auth/password-reset-token", h.Auth.CreateResetPasswordToken)
+	router.POST("/api/auth/password-reset-email", h.Auth.SendResetPasswordEmail)
+	router.PUT("/api/auth/password-reset", h.Auth.ResetPassword)
+
+	router.PATCH("/api/auth/level", requireAuth, requireAdmin, h.Auth.UpdateUserLevel)
+
+	router.GET("/api/auth/security-activity", requireAuth, h.Audit.GetSecurityActivity)
 
-	router.POST("/api/auth/register", controllers.Register)
-	router.POST("/api/auth/login", controllers.Login)
-	router.POST("/api/auth/logout", middlewares.AuthMiddleware(), controllers.Logout)
+	router.POST("/api/auth/invite-codes", requireAuth, h.InviteCode.CreateInviteCode)
+	router.GET("/api/auth/invite-codes", requireAuth, h.InviteCode.GetInviteCodes)
+	router.DELETE("/api/auth/invite-codes/:id", requireAuth, h.InviteCode.RevokeInviteCode)
 
-	router.PUT("/api/auth/password", middlewares.AuthMiddleware(), controllers.UpdatePassword)
+	router.GET("/api/profiles", h.Profile.GetProfile)
+	router.GET("/api/profiles/me", requireAuth, h.Profile.GetCurrentProfile)
+	router.POST("/api/profiles", requireAuth, h.Profile.CreateProfile)
+	router.PUT("/api/profiles", requireAuth, h.Profile.UpdateCurrentProfile)
 
-	router.POST("/api/auth/password-reset-token", controllers.CreateResetPasswordToken)
-	router.POST("/api/auth/password-reset-email", controllers.SendResetPasswordEmail)
-	router.PUT("/api/auth/password-reset", controllers.ResetPassword)
+	adminRoutes := router.Group("/api/admin", requireAuth, requireAdmin)
+	adminRoutes.GET("/invite-codes", h.InviteCode.AdminListInviteCodes)
+	adminRoutes.GET("/invite-codes/:id/uses", h.InviteCode.AdminGetInviteCodeUses)
+	adminRoutes.DELETE("/invite-codes/:id", h.InviteCode.RevokeInviteCode)
+	adminRoutes.GET("/audit-events", h.Audit.AdminListAuditEvents)
+	adminRoutes.GET("/users", h.Admin.AdminListUsers)
+	adminRoutes.GET("/users/:id", h.Admin.AdminGetUser)
+	adminRoutes.POST("/users/:id/logout", h.Admin.AdminForceLogout)
+	adminRoutes.POST("/users/:id/password-reset-email", h.Admin.AdminSendPasswordResetEmail)
+	adminRoutes.POST("/users/:id/restore", h.Admin.AdminRestoreUser)
+	adminRoutes.POST("/users/:id/impersonate", h.Admin.AdminImpersonateUser)
+	adminRoutes.POST("/users/:id/suspend", h.Admin.AdminSuspendUser)
+	adminRoutes.POST("/users/:id/ban", h.Admin.AdminBanUser)
+	adminRoutes.POST("/users/:id/reinstate", h.Admin.AdminReinstateUser)
+	adminRoutes.GET("/users/:id/status-history", h.Admin.AdminGetUserStatusHistory)
 
-	router.PATCH("/api/auth/level", controllers.UpdateUserLevel)
+	adminRoutes.POST("/webhooks", h.Webhook.AdminCreateWebhookEndpoint)
+	adminRoutes.GET("/webhooks", h.Webhook.AdminListWebhookEndpoints)
+	adminRoutes.GET("/webhooks/:id", h.Webhook.AdminGetWebhookEndpoint)
+	adminRoutes.PUT("/webhooks/:id", h.Webhook.AdminUpdateWebhookEndpoint)
+	adminRoutes.DELETE("/webhooks/:id", h.Webhook.AdminDeleteWebhookEndpoint)
+	adminRoutes.GET("/webhooks/:id/deliveries", h.Webhook.AdminListWebhookDeliveries)
+	adminRoutes.GET("/webhook-deliveries/:id", h.Webhook.AdminGetWebhookDelivery)
+	adminRoutes.POST("/webhook-deliveries/:id/redeliver", h.Webhook.AdminRedeliverWebhook)
 
-	router.GET("/api/profiles", controllers.GetProfile)
-	router.GET("/api/profiles/me", middlewares.AuthMiddleware(), controllers.GetCurrentProfile)
-	router.POST("/api/profiles", middlewares.AuthMiddleware(), controllers.CreateProfile)
-	router.PUT("/api/profiles", middlewares.AuthMiddleware(), controllers.UpdateCurrentProfile)
+	scimRoutes := router.Group("/scim/v2", middlewares.SCIMAuthMiddleware(cfg.SCIM.BearerToken))
+	scimRoutes.GET("/Users", h.SCIM.SCIMListUsers)
+	scimRoutes.GET("/Users/:id", h.SCIM.SCIMGetUser)
+	scimRoutes.POST("/Users", h.SCIM.SCIMCreateUser)
+	scimRoutes.PUT("/Users/:id", h.SCIM.SCIMReplaceUser)
+	scimRoutes.PATCH("/Users/:id", h.SCIM.SCIMPatchUser)
+	scimRoutes.DELETE("/Users/:id", h.SCIM.SCIMDeleteUser)
 
 	return router
 }
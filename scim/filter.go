@@ -0,0 +1,481 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+type AttributeKind int
+
+const (
+	StringAttribute AttributeKind = iota
+	BooleanAttribute
+	DateTimeAttribute
+)
+
+// Filter is a parsed SCIM filter expression, see ParseFilter. It is either
+// a LogicalFilter, a NotFilter or an AttributeFilter.
+type Filter interface {
+	isFilter()
+}
+
+// LogicalFilter combines two filters with "and" or "or".
+type LogicalFilter struct {
+	Operator string
+	Left     Filter
+	Right    Filter
+}
+
+type NotFilter struct {
+	Filter Filter
+}
+
+// AttributeFilter compares an attribute, given by its normalized path, to
+// Value with one of the SCIM operators, e.g. "eq" or "pr". Value is a
+// string, a bool or a time.Time depending on Kind, or nil for "pr" and for
+// comparisons with null.
+type AttributeFilter struct {
+	Attribute string
+	Kind      AttributeKind
+	Operator  string
+	Value     any
+}
+
+func (LogicalFilter) isFilter()   {}
+func (NotFilter) isFilter()       {}
+func (AttributeFilter) isFilter() {}
+
+// ParseFilter parses a SCIM filter expression (RFC 7644 section 3.4.2.2).
+// Attribute names are looked up case-insensitively in attributes, which
+// maps the filterable attributes to their kind.
+func ParseFilter(filter string, attributes map[string]AttributeKind) (Filter, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, attributes: attributes}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token %q", p.tokens[p.pos].value)
+	}
+
+	return f, nil
+}
+
+// ToSQL translates the filter into a SQL condition and its arguments.
+// columns maps each attribute to the SQL expression it is stored in.
+func ToSQL(f Filter, columns map[string]string) (string, []any) {
+	var args []any
+	condition := toSQL(f, columns, &args)
+	return condition, args
+}
+
+func toSQL(f Filter, columns map[string]string, args *[]any) string {
+	switch f := f.(type) {
+	case LogicalFilter:
+		return fmt.Sprintf("(%s %s %s)", toSQL(f.Left, columns, args), strings.ToUpper(f.Operator), toSQL(f.Right, columns, args))
+	case NotFilter:
+		return fmt.Sprintf("NOT (%s)", toSQL(f.Filter, columns, args))
+	case AttributeFilter:
+		return attributeToSQL(f, columns[f.Attribute], args)
+	}
+
+	panic(fmt.Sprintf("unknown filter %T", f))
+}
+
+func attributeToSQL(f AttributeFilter, column string, args *[]any) string {
+	switch {
+	case f.Operator == "pr" && f.Kind == StringAttribute:
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column, column)
+	case f.Operator == "pr":
+		return fmt.Sprintf("%s IS NOT NULL", column)
+	case f.Operator == "eq" && f.Value == nil:
+		return fmt.Sprintf("%s IS NULL", column)
+	case f.Operator == "ne" && f.Value == nil:
+		return fmt.Sprintf("%s IS NOT NULL", column)
+	}
+
+	placeholder := "?"
+	if f.Kind == StringAttribute {
+		column = fmt.Sprintf("LOWER(%s)", column)
+		placeholder = "LOWER(?)"
+	}
+
+	switch f.Operator {
+	case "co", "sw", "ew":
+		pattern := utils.EscapeLike(f.Value.(string))
+		switch f.Operator {
+		case "co":
+			pattern = "%" + pattern + "%"
+		case "sw":
+			pattern = pattern + "%"
+		case "ew":
+			pattern = "%" + pattern
+		}
+		*args = append(*args, pattern)
+		return fmt.Sprintf("%s LIKE %s", column, placeholder)
+	}
+
+	*args = append(*args, f.Value)
+	return fmt.Sprintf("%s %s %s", column, sqlOperators[f.Operator], placeholder)
+}
+
+var sqlOperators = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+// Match evaluates the filter against a resource whose attributes value
+// returns: a string, a bool, a time.Time, or nil when the attribute is not
+// set. Like in SQL, comparisons with an attribute that is not set are false,
+// and strings are compared case-insensitively.
+func Match(f Filter, value func(attribute string) any) bool {
+	switch f := f.(type) {
+	case LogicalFilter:
+		if f.Operator == "and" {
+			return Match(f.Left, value) && Match(f.Right, value)
+		}
+		return Match(f.Left, value) || Match(f.Right, value)
+	case NotFilter:
+		return !Match(f.Filter, value)
+	case AttributeFilter:
+		return matchAttribute(f, value(f.Attribute))
+	}
+
+	panic(fmt.Sprintf("unknown filter %T", f))
+}
+
+func matchAttribute(f AttributeFilter, actual any) bool {
+	switch {
+	case f.Operator == "pr":
+		s, isString := actual.(string)
+		return actual != nil && (!isString || s != "")
+	case f.Operator == "eq" && f.Value == nil:
+		return actual == nil
+	case f.Operator == "ne" && f.Value == nil:
+		return actual != nil
+	case actual == nil:
+		return false
+	}
+
+	switch actual := actual.(type) {
+	case string:
+		a, v := strings.ToLower(actual), strings.ToLower(fmt.Sprint(f.Value))
+		switch f.Operator {
+		case "co":
+			return strings.Contains(a, v)
+		case "sw":
+			return strings.HasPrefix(a, v)
+		case "ew":
+			return strings.HasSuffix(a, v)
+		}
+		return compare(strings.Compare(a, v), f.Operator)
+	case bool:
+		v, _ := f.Value.(bool)
+		return compare(boolCompare(actual, v), f.Operator)
+	case time.Time:
+		v, ok := f.Value.(time.Time)
+		if !ok {
+			return false
+		}
+		return compare(actual.Compare(v), f.Operator)
+	}
+
+	return false
+}
+
+// compare applies a comparison operator to the result of comparing an
+// attribute to a value, as returned by strings.Compare.
+func compare(c int, operator string) bool {
+	switch operator {
+	case "eq":
+		return c == 0
+	case "ne":
+		return c != 0
+	case "gt":
+		return c > 0
+	case "ge":
+		return c >= 0
+	case "lt":
+		return c < 0
+	case "le":
+		return c <= 0
+	}
+
+	return false
+}
+
+func boolCompare(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// NormalizeAttributePath strips the core schema prefix and any value filter
+// from an attribute path, e.g. `emails[type eq "work"].value` becomes `emails.value`.
+func NormalizeAttributePath(path string) string {
+	path = strings.TrimPrefix(path, UserSchema+":")
+
+	if start := strings.Index(path, "["); start >= 0 {
+		if end := strings.Index(path, "]"); end > start {
+			path = path[:start] + path[end+1:]
+		}
+	}
+
+	return strings.ToLower(path)
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	stringToken
+	openParenToken
+	closeParenToken
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+}
+
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	runes := []rune(filter)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: openParenToken, value: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: closeParenToken, value: ")"})
+			i++
+		case r == '"':
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+			tokens = append(tokens, token{kind: stringToken, value: sb.String()})
+			i++
+		default:
+			start := i
+			depth := 0
+			for ; i < len(runes); i++ {
+				if runes[i] == '[' {
+					depth++
+				} else if runes[i] == ']' {
+					depth--
+				} else if depth == 0 && (unicode.IsSpace(runes[i]) || runes[i] == '(' || runes[i] == ')') {
+					break
+				}
+			}
+			tokens = append(tokens, token{kind: wordToken, value: string(runes[start:i])})
+		}
+	}
+
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens     []token
+	pos        int
+	attributes map[string]AttributeKind
+}
+
+func (p *filterParser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = LogicalFilter{Operator: "or", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (Filter, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("and") {
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = LogicalFilter{Operator: "and", Left: left, Right: right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseTerm() (Filter, error) {
+	if p.acceptKeyword("not") {
+		inner, err := p.parseGroup()
+		if err != nil {
+			return nil, err
+		}
+		return NotFilter{Filter: inner}, nil
+	}
+
+	if p.peek() != nil && p.peek().kind == openParenToken {
+		return p.parseGroup()
+	}
+
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseGroup() (Filter, error) {
+	if t := p.next(); t == nil || t.kind != openParenToken {
+		return nil, fmt.Errorf("expected '('")
+	}
+
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.next(); t == nil || t.kind != closeParenToken {
+		return nil, fmt.Errorf("expected ')'")
+	}
+
+	return inner, nil
+}
+
+func (p *filterParser) parseComparison() (Filter, error) {
+	path := p.next()
+	if path == nil || path.kind != wordToken {
+		return nil, fmt.Errorf("expected attribute path")
+	}
+
+	name := NormalizeAttributePath(path.value)
+	kind, ok := p.attributes[name]
+	if !ok {
+		return nil, fmt.Errorf("attribute %q is not filterable", path.value)
+	}
+
+	op := p.next()
+	if op == nil || op.kind != wordToken {
+		return nil, fmt.Errorf("expected operator after %q", path.value)
+	}
+
+	f := AttributeFilter{Attribute: name, Kind: kind, Operator: strings.ToLower(op.value)}
+	if f.Operator == "pr" {
+		return f, nil
+	}
+
+	valueToken := p.next()
+	if valueToken == nil || (valueToken.kind != stringToken && valueToken.kind != wordToken) {
+		return nil, fmt.Errorf("expected value after %q", op.value)
+	}
+
+	value, err := parseValue(valueToken, kind)
+	if err != nil {
+		return nil, err
+	}
+	f.Value = value
+
+	switch f.Operator {
+	case "eq", "ne":
+	case "co", "sw", "ew":
+		if _, ok := value.(string); !ok || kind != StringAttribute {
+			return nil, fmt.Errorf("operator %q requires a string attribute", op.value)
+		}
+	case "gt", "ge", "lt", "le":
+		if value == nil || kind == BooleanAttribute {
+			return nil, fmt.Errorf("operator %q can not be used with this value", op.value)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", op.value)
+	}
+
+	return f, nil
+}
+
+func (p *filterParser) peek() *token {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+
+	return &p.tokens[p.pos]
+}
+
+func (p *filterParser) next() *token {
+	t := p.peek()
+	if t != nil {
+		p.pos++
+	}
+
+	return t
+}
+
+func (p *filterParser) acceptKeyword(keyword string) bool {
+	t := p.peek()
+	if t == nil || t.kind != wordToken || !strings.EqualFold(t.value, keyword) {
+		return false
+	}
+
+	p.pos++
+	return true
+}
+
+// parseValue parses a value compared to an attribute of the given kind: a
+// string, a bool, or a time.Time for date-times given as RFC 3339 strings.
+// null can be compared to any attribute. No attribute holds numbers.
+func parseValue(t *token, kind AttributeKind) (any, error) {
+	if t.kind == wordToken && strings.EqualFold(t.value, "null") {
+		return nil, nil
+	}
+
+	switch kind {
+	case StringAttribute:
+		if t.kind != stringToken {
+			return nil, fmt.Errorf("invalid value %q for a string attribute", t.value)
+		}
+		return t.value, nil
+	case BooleanAttribute:
+		if t.kind != wordToken || (!strings.EqualFold(t.value, "true") && !strings.EqualFold(t.value, "false")) {
+			return nil, fmt.Errorf("invalid value %q for a boolean attribute", t.value)
+		}
+		return strings.EqualFold(t.value, "true"), nil
+	case DateTimeAttribute:
+		if t.kind != stringToken {
+			return nil, fmt.Errorf("invalid value %q for a date-time attribute", t.value)
+		}
+		v, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date-time %q", t.value)
+		}
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("invalid value %q", t.value)
+}
@@ -0,0 +1,223 @@
+package scim_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/scim"
+)
+
+var testAttributes = map[string]scim.AttributeKind{
+	"username":     scim.StringAttribute,
+	"emails.value": scim.StringAttribute,
+	"active":       scim.BooleanAttribute,
+	"meta.created": scim.DateTimeAttribute,
+}
+
+var testColumns = map[string]string{
+	"username":     "users.username",
+	"emails.value": "users.email",
+	"active":       "(users.deleted_at IS NULL)",
+	"meta.created": "users.created_at",
+}
+
+func TestParseFilter(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter string
+		want   scim.Filter
+	}{
+		{
+			name:   "string equality",
+			filter: `userName eq "Alice"`,
+			want:   scim.AttributeFilter{Attribute: "username", Kind: scim.StringAttribute, Operator: "eq", Value: "Alice"},
+		},
+		{
+			name:   "schema prefix and value filter",
+			filter: `urn:ietf:params:scim:schemas:core:2.0:User:emails[type eq "work"].value co "example"`,
+			want:   scim.AttributeFilter{Attribute: "emails.value", Kind: scim.StringAttribute, Operator: "co", Value: "example"},
+		},
+		{
+			name:   "present",
+			filter: `userName pr`,
+			want:   scim.AttributeFilter{Attribute: "username", Kind: scim.StringAttribute, Operator: "pr"},
+		},
+		{
+			name:   "null",
+			filter: `meta.created eq null`,
+			want:   scim.AttributeFilter{Attribute: "meta.created", Kind: scim.DateTimeAttribute, Operator: "eq"},
+		},
+		{
+			name:   "boolean",
+			filter: `active eq False`,
+			want:   scim.AttributeFilter{Attribute: "active", Kind: scim.BooleanAttribute, Operator: "eq", Value: false},
+		},
+		{
+			name:   "date-time",
+			filter: `meta.created gt "2024-05-01T12:30:00Z"`,
+			want:   scim.AttributeFilter{Attribute: "meta.created", Kind: scim.DateTimeAttribute, Operator: "gt", Value: created},
+		},
+		{
+			name:   "precedence and grouping",
+			filter: `not (active eq true) or userName sw "a" and (userName ew "z")`,
+			want: scim.LogicalFilter{
+				Operator: "or",
+				Left:     scim.NotFilter{Filter: scim.AttributeFilter{Attribute: "active", Kind: scim.BooleanAttribute, Operator: "eq", Value: true}},
+				Right: scim.LogicalFilter{
+					Operator: "and",
+					Left:     scim.AttributeFilter{Attribute: "username", Kind: scim.StringAttribute, Operator: "sw", Value: "a"},
+					Right:    scim.AttributeFilter{Attribute: "username", Kind: scim.StringAttribute, Operator: "ew", Value: "z"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := scim.ParseFilter(tt.filter, testAttributes)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilter() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterRejectsInvalidFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+	}{
+		{name: "empty", filter: ``},
+		{name: "unknown attribute", filter: `password eq "secret"`},
+		{name: "unknown operator", filter: `userName like "a"`},
+		{name: "missing value", filter: `userName eq`},
+		{name: "unterminated string", filter: `userName eq "alice`},
+		{name: "unbalanced parenthesis", filter: `(userName eq "alice"`},
+		{name: "trailing token", filter: `userName eq "alice" "bob"`},
+		{name: "number for a string", filter: `userName eq 5`},
+		{name: "boolean for a string", filter: `userName eq true`},
+		{name: "number for a date-time", filter: `meta.created gt 5`},
+		{name: "invalid date-time", filter: `meta.created eq "not-a-date"`},
+		{name: "boolean for a date-time", filter: `meta.created eq true`},
+		{name: "string for a boolean", filter: `active eq "true"`},
+		{name: "number for a boolean", filter: `active eq 1`},
+		{name: "ordering a boolean", filter: `active gt false`},
+		{name: "ordering null", filter: `userName lt null`},
+		{name: "substring of a date-time", filter: `meta.created co "2024"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if f, err := scim.ParseFilter(tt.filter, testAttributes); err == nil {
+				t.Errorf("ParseFilter() = %#v, want an error", f)
+			}
+		})
+	}
+}
+
+func TestToSQL(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		filter   string
+		wantSQL  string
+		wantArgs []any
+	}{
+		{
+			name:     "strings are compared case-insensitively",
+			filter:   `userName eq "Alice"`,
+			wantSQL:  `LOWER(users.username) = LOWER(?)`,
+			wantArgs: []any{"Alice"},
+		},
+		{
+			name:     "LIKE wildcards are escaped",
+			filter:   `emails.value co "a%b_c\\d"`,
+			wantSQL:  `LOWER(users.email) LIKE LOWER(?)`,
+			wantArgs: []any{`%a\%b\_c\\d%`},
+		},
+		{
+			name:     "starts with",
+			filter:   `userName sw "al"`,
+			wantSQL:  `LOWER(users.username) LIKE LOWER(?)`,
+			wantArgs: []any{"al%"},
+		},
+		{
+			name:    "present string",
+			filter:  `userName pr`,
+			wantSQL: `(users.username IS NOT NULL AND users.username <> '')`,
+		},
+		{
+			name:    "not null",
+			filter:  `meta.created ne null`,
+			wantSQL: `users.created_at IS NOT NULL`,
+		},
+		{
+			name:     "date-time",
+			filter:   `meta.created ge "2024-05-01T12:30:00Z"`,
+			wantSQL:  `users.created_at >= ?`,
+			wantArgs: []any{created},
+		},
+		{
+			name:     "logical operators",
+			filter:   `not (active eq true) and (userName eq "a" or userName eq "b")`,
+			wantSQL:  `(NOT ((users.deleted_at IS NULL) = ?) AND (LOWER(users.username) = LOWER(?) OR LOWER(users.username) = LOWER(?)))`,
+			wantArgs: []any{true, "a", "b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := scim.ParseFilter(tt.filter, testAttributes)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+
+			sql, args := scim.ToSQL(f, testColumns)
+			if sql != tt.wantSQL {
+				t.Errorf("ToSQL() sql = %s, want %s", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("ToSQL() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	attributes := map[string]any{
+		"username":     "Alice",
+		"emails.value": "alice@example.com",
+		"active":       true,
+		"meta.created": created,
+	}
+	value := func(attribute string) any { return attributes[attribute] }
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: `userName eq "alice"`, want: true},
+		{filter: `emails.value ew "@EXAMPLE.com"`, want: true},
+		{filter: `active eq false`, want: false},
+		{filter: `meta.created gt "2024-05-01T12:00:00Z"`, want: true},
+		{filter: `meta.created lt "2024-05-01T14:00:00+02:00"`, want: false},
+		{filter: `meta.created eq null`, want: false},
+		{filter: `not (userName sw "b") and active pr`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := scim.ParseFilter(tt.filter, testAttributes)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if got := scim.Match(f, value); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas" binding:"required"`
+	Operations []PatchOperation `json:"Operations" binding:"required,min=1"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies the patch operations to u in order.
+func (r *PatchRequest) Apply(u *User) error {
+	for _, operation := range r.Operations {
+		op := strings.ToLower(operation.Op)
+		switch op {
+		case "add", "replace", "remove":
+		default:
+			return fmt.Errorf("unsupported patch operation %q", operation.Op)
+		}
+
+		if operation.Path == "" {
+			if op == "remove" {
+				return fmt.Errorf("remove operation requires a path")
+			}
+
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &values); err != nil {
+				return fmt.Errorf("patch value without path must be an object")
+			}
+			for path, value := range values {
+				if err := applyPath(u, op, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if err := applyPath(u, op, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyPath(u *User, op string, path string, value json.RawMessage) error {
+	remove := op == "remove"
+	normalized := NormalizeAttributePath(path)
+
+	switch normalized {
+	case "username":
+		if remove {
+			return fmt.Errorf("userName can not be removed")
+		}
+		return json.Unmarshal(value, &u.UserName)
+	case "externalid":
+		if remove {
+			u.ExternalID = ""
+			return nil
+		}
+		return json.Unmarshal(value, &u.ExternalID)
+	case "password":
+		if remove {
+			return fmt.Errorf("password can not be removed")
+		}
+		return json.Unmarshal(value, &u.Password)
+	case "active":
+		if remove {
+			return fmt.Errorf("active can not be removed")
+		}
+		active, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+		return nil
+	case "name":
+		if remove {
+			u.Name = nil
+			return nil
+		}
+		return json.Unmarshal(value, &u.Name)
+	case "name.givenname", "name.familyname":
+		if u.Name == nil {
+			u.Name = &Name{}
+		}
+		target := &u.Name.GivenName
+		if normalized == "name.familyname" {
+			target = &u.Name.FamilyName
+		}
+		if remove {
+			*target = ""
+			return nil
+		}
+		return json.Unmarshal(value, target)
+	case "emails":
+		if remove {
+			return fmt.Errorf("emails can not be removed")
+		}
+		return json.Unmarshal(value, &u.Emails)
+	case "emails.value":
+		if remove {
+			return fmt.Errorf("emails can not be removed")
+		}
+		return setSingleValue(&u.Emails, value)
+	case "phonenumbers":
+		if remove {
+			u.PhoneNumbers = nil
+			return nil
+		}
+		return json.Unmarshal(value, &u.PhoneNumbers)
+	case "phonenumbers.value":
+		if remove {
+			u.PhoneNumbers = nil
+			return nil
+		}
+		return setSingleValue(&u.PhoneNumbers, value)
+	case "addresses":
+		if remove {
+			u.Addresses = nil
+			return nil
+		}
+		return json.Unmarshal(value, &u.Addresses)
+	case "addresses.formatted":
+		if remove {
+			u.Addresses = nil
+			return nil
+		}
+		var formatted string
+		if err := json.Unmarshal(value, &formatted); err != nil {
+			return err
+		}
+		u.Addresses = []Address{{Formatted: formatted, Primary: true}}
+		return nil
+	}
+
+	return fmt.Errorf("attribute %q can not be patched", path)
+}
+
+func setSingleValue(values *[]MultiValued, value json.RawMessage) error {
+	var v string
+	if err := json.Unmarshal(value, &v); err != nil {
+		return err
+	}
+
+	*values = []MultiValued{{Value: v, Primary: true}}
+	return nil
+}
+
+// parseBool accepts both JSON booleans and the string form some identity
+// providers send, e.g. "False".
+func parseBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, fmt.Errorf("invalid boolean value")
+	}
+
+	return strconv.ParseBool(s)
+}
@@ -0,0 +1,192 @@
+package sso
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/samlsp"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+// IdentityProvider pairs a tenant's SAML identity provider with the service
+// provider we expose to it.
+type IdentityProvider struct {
+	Config          config.SAMLIdentityProviderConfig
+	ServiceProvider *saml.ServiceProvider
+
+	registry *Registry
+}
+
+// Registry holds the identity providers tenants sign in with.
+type Registry struct {
+	providers         map[string]*IdentityProvider
+	providersByDomain map[string]*IdentityProvider
+}
+
+// NewRegistry loads the identity providers configured in cfg. The registry
+// is empty when SAML is not enabled.
+func NewRegistry(cfg *config.SAMLConfig) (*Registry, error) {
+	r := &Registry{
+		providers:         map[string]*IdentityProvider{},
+		providersByDomain: map[string]*IdentityProvider{},
+	}
+
+	if !cfg.Enabled() {
+		return r, nil
+	}
+
+	baseURL, err := url.Parse(cfg.SPBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML service provider base URL: %w", err)
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(cfg.SPCertFile, cfg.SPKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can not load SAML service provider key pair: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("SAML service provider key must be an RSA key")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	idps, err := cfg.LoadIdentityProviders()
+	if err != nil {
+		return nil, fmt.Errorf("can not load SAML identity providers: %w", err)
+	}
+
+	httpClient := &http.Client{Timeout: cfg.MetadataTimeout}
+	for _, idp := range idps {
+		metadata, err := loadIDPMetadata(httpClient, idp)
+		if err != nil {
+			return nil, fmt.Errorf("can not load metadata of identity provider %s: %w", idp.Name, err)
+		}
+
+		sp := &saml.ServiceProvider{
+			Key:               key,
+			Certificate:       cert,
+			MetadataURL:       *baseURL.JoinPath("api", "auth", "saml", idp.Name, "metadata"),
+			AcsURL:            *baseURL.JoinPath("api", "auth", "saml", idp.Name, "acs"),
+			IDPMetadata:       metadata,
+			HTTPClient:        httpClient,
+			AllowIDPInitiated: idp.AllowIDPInitiated,
+			SignatureMethod:   "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256",
+		}
+
+		provider := &IdentityProvider{Config: idp, ServiceProvider: sp, registry: r}
+		r.providers[idp.Name] = provider
+		for _, domain := range idp.EmailDomains {
+			r.providersByDomain[strings.ToLower(domain)] = provider
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Registry) GetIdentityProvider(name string) *IdentityProvider {
+	return r.providers[name]
+}
+
+func (r *Registry) GetIdentityProviderByEmail(email string) *IdentityProvider {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return nil
+	}
+
+	return r.providersByDomain[strings.ToLower(email[at+1:])]
+}
+
+// GetIdentity maps a verified assertion to an external identity using the
+// provider's attribute mapping.
+func (p *IdentityProvider) GetIdentity(assertion *saml.Assertion) (*models.ExternalIdentity, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, fmt.Errorf("assertion has no subject")
+	}
+	nameID := assertion.Subject.NameID.Value
+
+	m := p.Config.Attributes
+	identity := &models.ExternalIdentity{
+		Provider:   models.SAMLAuthProvider,
+		ExternalID: p.Config.Name + ":" + nameID,
+		Username:   getAttribute(assertion, m.Username),
+		Email:      getAttribute(assertion, m.Email),
+		FirstName:  getAttribute(assertion, m.FirstName),
+		LastName:   getAttribute(assertion, m.LastName),
+	}
+	if identity.Email == "" && strings.Contains(nameID, "@") {
+		identity.Email = nameID
+	}
+	if identity.Username == "" {
+		identity.Username = nameID
+	}
+
+	// An identity provider may only assert users of the domains it owns.
+	if len(p.Config.EmailDomains) > 0 && p.registry.GetIdentityProviderByEmail(identity.Email) != p {
+		return nil, fmt.Errorf("email %s does not belong to identity provider %s", identity.Email, p.Config.Name)
+	}
+
+	return identity, nil
+}
+
+// Assertion returns the record that keeps the assertion from being used again
+// until the service provider would reject it anyway.
+func (p *IdentityProvider) Assertion(assertion *saml.Assertion) *models.SAMLAssertion {
+	expiresAt := assertion.IssueInstant.Add(saml.MaxIssueDelay)
+	if assertion.Conditions != nil && !assertion.Conditions.NotOnOrAfter.IsZero() {
+		expiresAt = assertion.Conditions.NotOnOrAfter.Add(saml.MaxClockSkew)
+	}
+
+	return &models.SAMLAssertion{
+		IdentityProvider: p.Config.Name,
+		AssertionID:      assertion.ID,
+		ExpiresAt:        expiresAt.UTC(),
+	}
+}
+
+// loadIDPMetadata fetches the metadata of identity providers configured by
+// URL with httpClient, whose timeout bounds the request.
+func loadIDPMetadata(httpClient *http.Client, idp config.SAMLIdentityProviderConfig) (*saml.EntityDescriptor, error) {
+	if idp.MetadataFile != "" {
+		content, err := os.ReadFile(idp.MetadataFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return samlsp.ParseMetadata(content)
+	}
+
+	metadataURL, err := url.Parse(idp.MetadataURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return samlsp.FetchMetadata(context.Background(), httpClient, *metadataURL)
+}
+
+func getAttribute(assertion *saml.Assertion, name string) string {
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+
+			if len(attr.Values) > 0 {
+				return attr.Values[0].Value
+			}
+		}
+	}
+
+	return ""
+}
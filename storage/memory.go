@@ -0,0 +1,1567 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/scim"
+	"github.com/vantutran2k1/social-network-auth/transaction"
+	"github.com/vantutran2k1/social-network-auth/utils"
+	"gorm.io/gorm"
+)
+
+// MemoryStore keeps everything in memory, for local development and tests.
+// It is not meant for production: a single lock guards all the data, so
+// requests are served one store operation at a time, and transactions are
+// serialized, holding that lock until they end. Each transaction also takes
+// a copy of the whole dataset when it begins, which is restored to roll it
+// back, so its cost grows with the amount of data kept.
+type MemoryStore struct {
+	mu   *sync.Mutex
+	data *memoryData
+}
+
+// memoryTxKey marks the contexts of the transactions of a store, whose
+// operations run under the lock the transaction already holds.
+type memoryTxKey struct {
+	store *MemoryStore
+}
+
+type memoryData struct {
+	users                map[uuid.UUID]models.User
+	tokens               map[uuid.UUID]models.Token
+	profiles             map[uuid.UUID]models.Profile
+	passwordResetTokens  map[uuid.UUID]models.PasswordResetToken
+	inviteCodes          map[uuid.UUID]models.InviteCode
+	inviteCodeUses       []models.InviteCodeUse
+	accountStatusChanges []models.AccountStatusChange
+	auditEvents          []models.AuditEvent
+	outboxEvents         []models.OutboxEvent
+	emailChangeRequests  map[uuid.UUID]models.EmailChangeRequest
+	usernameHistory      []models.UsernameHistory
+	dataExports          map[uuid.UUID]models.DataExport
+	webhookEndpoints     map[uuid.UUID]models.WebhookEndpoint
+	webhookDeliveries    map[uuid.UUID]models.WebhookDelivery
+	webhookAttempts      []models.WebhookDeliveryAttempt
+	samlAssertions       map[samlAssertionKey]models.SAMLAssertion
+}
+
+type samlAssertionKey struct {
+	identityProvider string
+	assertionID      string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu: &sync.Mutex{},
+		data: &memoryData{
+			users:               map[uuid.UUID]models.User{},
+			tokens:              map[uuid.UUID]models.Token{},
+			profiles:            map[uuid.UUID]models.Profile{},
+			passwordResetTokens: map[uuid.UUID]models.PasswordResetToken{},
+			inviteCodes:         map[uuid.UUID]models.InviteCode{},
+			emailChangeRequests: map[uuid.UUID]models.EmailChangeRequest{},
+			dataExports:         map[uuid.UUID]models.DataExport{},
+			webhookEndpoints:    map[uuid.UUID]models.WebhookEndpoint{},
+			webhookDeliveries:   map[uuid.UUID]models.WebhookDelivery{},
+			samlAssertions:      map[samlAssertionKey]models.SAMLAssertion{},
+		},
+	}
+}
+
+func (s *MemoryStore) Users() models.UserRepository {
+	return &memoryUserRepository{store: s}
+}
+
+func (s *MemoryStore) Tokens() models.TokenRepository {
+	return &memoryTokenRepository{store: s}
+}
+
+func (s *MemoryStore) Profiles() models.ProfileRepository {
+	return &memoryProfileRepository{store: s}
+}
+
+func (s *MemoryStore) PasswordResetTokens() models.PasswordResetTokenRepository {
+	return &memoryPasswordResetTokenRepository{store: s}
+}
+
+func (s *MemoryStore) InviteCodes() models.InviteCodeRepository {
+	return &memoryInviteCodeRepository{store: s}
+}
+
+func (s *MemoryStore) AccountStatusChanges() models.AccountStatusChangeRepository {
+	return &memoryAccountStatusChangeRepository{store: s}
+}
+
+func (s *MemoryStore) AuditEvents() models.AuditEventRepository {
+	return &memoryAuditEventRepository{store: s}
+}
+
+func (s *MemoryStore) OutboxEvents() models.OutboxEventRepository {
+	return &memoryOutboxEventRepository{store: s}
+}
+
+func (s *MemoryStore) EmailChangeRequests() models.EmailChangeRequestRepository {
+	return &memoryEmailChangeRequestRepository{store: s}
+}
+
+func (s *MemoryStore) UsernameHistory() models.UsernameHistoryRepository {
+	return &memoryUsernameHistoryRepository{store: s}
+}
+
+func (s *MemoryStore) DataExports() models.DataExportRepository {
+	return &memoryDataExportRepository{store: s}
+}
+
+func (s *MemoryStore) WebhookEndpoints() models.WebhookEndpointRepository {
+	return &memoryWebhookEndpointRepository{store: s}
+}
+
+func (s *MemoryStore) WebhookDeliveries() models.WebhookDeliveryRepository {
+	return &memoryWebhookDeliveryRepository{store: s}
+}
+
+func (s *MemoryStore) SAMLAssertions() models.SAMLAssertionRepository {
+	return &memorySAMLAssertionRepository{store: s}
+}
+
+// WithTransaction ignores opts: transactions are serializable anyway. A
+// nested transaction takes its own copy of the data, like a savepoint.
+func (s *MemoryStore) WithTransaction(ctx context.Context, fn func(ctx context.Context) error, opts ...transaction.Option) error {
+	if s.inTransaction(ctx) {
+		hooks := transaction.CommitHooksFrom(ctx)
+		savepoint := hooks.Savepoint()
+		if err := s.run(ctx, fn); err != nil {
+			hooks.RollbackTo(savepoint)
+			return err
+		}
+
+		return nil
+	}
+
+	ctx, hooks := transaction.WithCommitHooks(context.WithValue(ctx, memoryTxKey{store: s}, true))
+	err := func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.run(ctx, fn)
+	}()
+	if err != nil {
+		return err
+	}
+
+	// The hooks run without the lock, as they may use the store.
+	hooks.Run()
+	return nil
+}
+
+// run runs fn, restoring the data as it was if fn fails.
+func (s *MemoryStore) run(ctx context.Context, fn func(ctx context.Context) error) error {
+	snapshot := s.data.clone()
+	if err := fn(ctx); err != nil {
+		*s.data = *snapshot
+		return err
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) inTransaction(ctx context.Context) bool {
+	return ctx != nil && ctx.Value(memoryTxKey{store: s}) != nil
+}
+
+// lock takes the store's lock, unless ctx belongs to a transaction that
+// already holds it, and returns the function releasing it.
+func (s *MemoryStore) lock(ctx context.Context) func() {
+	if s.inTransaction(ctx) {
+		return func() {}
+	}
+
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// clone copies the data deep enough for the copy to be restored after the
+// original is changed. Stored values are replaced, never modified in place.
+func (d *memoryData) clone() *memoryData {
+	return &memoryData{
+		users:                cloneMap(d.users),
+		tokens:               cloneMap(d.tokens),
+		profiles:             cloneMap(d.profiles),
+		passwordResetTokens:  cloneMap(d.passwordResetTokens),
+		inviteCodes:          cloneMap(d.inviteCodes),
+		inviteCodeUses:       append([]models.InviteCodeUse(nil), d.inviteCodeUses...),
+		accountStatusChanges: append([]models.AccountStatusChange(nil), d.accountStatusChanges...),
+		auditEvents:          append([]models.AuditEvent(nil), d.auditEvents...),
+		outboxEvents:         append([]models.OutboxEvent(nil), d.outboxEvents...),
+		emailChangeRequests:  cloneMap(d.emailChangeRequests),
+		usernameHistory:      append([]models.UsernameHistory(nil), d.usernameHistory...),
+		dataExports:          cloneMap(d.dataExports),
+		webhookEndpoints:     cloneMap(d.webhookEndpoints),
+		webhookDeliveries:    cloneMap(d.webhookDeliveries),
+		webhookAttempts:      append([]models.WebhookDeliveryAttempt(nil), d.webhookAttempts...),
+		samlAssertions:       cloneMap(d.samlAssertions),
+	}
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+
+	return c
+}
+
+// duplicateKeyError wraps utils.ErrDuplicateKey, so that callers handle it
+// like the unique violations reported by Postgres.
+func duplicateKeyError(entity string, field string) error {
+	return fmt.Errorf("%w: %s %s", utils.ErrDuplicateKey, entity, field)
+}
+
+type memoryUserRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	defer r.store.lock(ctx)()
+
+	if _, ok := r.store.data.users[user.ID]; ok {
+		return duplicateKeyError("user", "id")
+	}
+
+	return r.put(user)
+}
+
+func (r *memoryUserRepository) Save(ctx context.Context, user *models.User) error {
+	defer r.store.lock(ctx)()
+
+	return r.put(user)
+}
+
+// put stores the user, keeping usernames and emails unique like the users
+// table does.
+func (r *memoryUserRepository) put(user *models.User) error {
+	for _, u := range r.store.data.users {
+		if u.ID == user.ID {
+			continue
+		}
+		if u.UsernameCanonical == user.UsernameCanonical {
+			return duplicateKeyError("user", "username")
+		}
+		if u.EmailCanonical == user.EmailCanonical {
+			return duplicateKeyError("user", "email")
+		}
+	}
+
+	r.store.data.users[user.ID] = *user
+	return nil
+}
+
+func (r *memoryUserRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return r.find(ctx, false, func(u *models.User) bool { return u.ID == id })
+}
+
+// FindByIDForUpdate needs no row lock: the transaction it is called in holds
+// the store's lock until it ends.
+func (r *memoryUserRepository) FindByIDForUpdate(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return r.FindByID(ctx, id)
+}
+
+func (r *memoryUserRepository) FindByIDWithDeleted(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return r.find(ctx, true, func(u *models.User) bool { return u.ID == id })
+}
+
+func (r *memoryUserRepository) FindByIDsWithDeleted(ctx context.Context, ids []uuid.UUID) ([]models.User, error) {
+	defer r.store.lock(ctx)()
+
+	var users []models.User
+	for _, id := range ids {
+		if u, ok := r.store.data.users[id]; ok {
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+func (r *memoryUserRepository) FindByUsername(ctx context.Context, usernameCanonical string) (*models.User, error) {
+	return r.find(ctx, false, func(u *models.User) bool { return u.UsernameCanonical == usernameCanonical })
+}
+
+func (r *memoryUserRepository) FindByUsernameWithDeleted(ctx context.Context, usernameCanonical string) (*models.User, error) {
+	return r.find(ctx, true, func(u *models.User) bool { return u.UsernameCanonical == usernameCanonical })
+}
+
+func (r *memoryUserRepository) FindByEmail(ctx context.Context, emailCanonical string) (*models.User, error) {
+	return r.find(ctx, false, func(u *models.User) bool { return u.EmailCanonical == emailCanonical })
+}
+
+func (r *memoryUserRepository) FindByUsernameOrEmail(ctx context.Context, usernameCanonical string, emailCanonical string) (*models.User, error) {
+	return r.find(ctx, false, func(u *models.User) bool {
+		return u.UsernameCanonical == usernameCanonical || u.EmailCanonical == emailCanonical
+	})
+}
+
+func (r *memoryUserRepository) FindByExternalID(ctx context.Context, provider string, externalID string) (*models.User, error) {
+	return r.find(ctx, false, func(u *models.User) bool {
+		return u.AuthProvider == provider && u.ExternalID != nil && *u.ExternalID == externalID
+	})
+}
+
+func (r *memoryUserRepository) ExistsWithUsernameOrEmail(ctx context.Context, usernameCanonical string, emailCanonical string, exceptID uuid.UUID) (bool, error) {
+	_, err := r.find(ctx, true, func(u *models.User) bool {
+		return u.ID != exceptID && (u.UsernameCanonical == usernameCanonical || u.EmailCanonical == emailCanonical)
+	})
+	return found(err)
+}
+
+func (r *memoryUserRepository) ExistsWithUsernameSkeleton(ctx context.Context, skeleton string, exceptID uuid.UUID) (bool, error) {
+	_, err := r.find(ctx, true, func(u *models.User) bool { return u.UsernameSkeleton == skeleton && u.ID != exceptID })
+	return found(err)
+}
+
+func (r *memoryUserRepository) IsUsernameHeld(ctx context.Context, usernameCanonical string, exceptID uuid.UUID, now time.Time) (bool, error) {
+	defer r.store.lock(ctx)()
+
+	for _, h := range r.store.data.usernameHistory {
+		if h.OldUsernameCanonical == usernameCanonical && h.HeldUntil.After(now) && h.UserID != exceptID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *memoryUserRepository) List(ctx context.Context, filter models.UserFilter, after *models.ListPosition, limit int) ([]models.User, error) {
+	defer r.store.lock(ctx)()
+
+	var users []models.User
+	for _, u := range r.store.data.users {
+		if matchesUserFilter(&u, filter) && (after == nil || positionBefore(after.CreatedAt, after.ID, &models.ListPosition{CreatedAt: u.CreatedAt, ID: u.ID})) {
+			users = append(users, u)
+		}
+	}
+
+	sortOldestFirst(users, func(u *models.User) (time.Time, uuid.UUID) { return u.CreatedAt, u.ID })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+
+	return users, nil
+}
+
+func matchesUserFilter(u *models.User, filter models.UserFilter) bool {
+	if filter.Username != "" && !strings.Contains(u.UsernameCanonical, utils.CanonicalizeUsername(filter.Username)) {
+		return false
+	}
+	if filter.Email != "" && !strings.Contains(u.EmailCanonical, utils.CanonicalizeEmail(filter.Email)) {
+		return false
+	}
+	if filter.Level != models.UNKNOWN && u.Level != filter.Level {
+		return false
+	}
+	if filter.Status != "" && u.Status != filter.Status {
+		return false
+	}
+	if filter.CreatedAfter != nil && u.CreatedAt.Before(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.CreatedBefore != nil && !u.CreatedAt.Before(*filter.CreatedBefore) {
+		return false
+	}
+	if filter.Deleted != nil && u.DeletedAt.Valid != *filter.Deleted {
+		return false
+	}
+
+	return true
+}
+
+func (r *memoryUserRepository) FindBySCIMFilter(ctx context.Context, filter scim.Filter, offset int, limit int) ([]models.User, int64, error) {
+	defer r.store.lock(ctx)()
+
+	profiles := make(map[uuid.UUID]models.Profile, len(r.store.data.profiles))
+	for _, p := range r.store.data.profiles {
+		profiles[p.UserID] = p
+	}
+
+	var users []models.User
+	for _, u := range r.store.data.users {
+		if !u.IsProvisioned() {
+			continue
+		}
+
+		p, hasProfile := profiles[u.ID]
+		if filter == nil || scim.Match(filter, func(attribute string) any { return scimUserAttribute(&u, &p, hasProfile, attribute) }) {
+			users = append(users, u)
+		}
+	}
+
+	sortOldestFirst(users, func(u *models.User) (time.Time, uuid.UUID) { return u.CreatedAt, u.ID })
+	return page(users, offset, limit), int64(len(users)), nil
+}
+
+// scimUserAttribute returns the value of one of models.SCIMUserAttributes
+// for scim.Match. Profile attributes are not set for users without a
+// profile.
+func scimUserAttribute(u *models.User, p *models.Profile, hasProfile bool, attribute string) any {
+	switch attribute {
+	case "id":
+		return u.ID.String()
+	case "externalid":
+		if u.ExternalID == nil {
+			return nil
+		}
+		return *u.ExternalID
+	case "username":
+		return u.Username
+	case "emails", "emails.value":
+		return u.Email
+	case "active":
+		return !u.DeletedAt.Valid
+	case "meta.created":
+		return u.CreatedAt
+	case "meta.lastmodified":
+		return u.UpdatedAt
+	}
+
+	if !hasProfile {
+		return nil
+	}
+
+	switch attribute {
+	case "name.givenname":
+		return p.FirstName
+	case "name.familyname":
+		return p.LastName
+	}
+
+	return nil
+}
+
+func (r *memoryUserRepository) LiftExpiredSuspension(ctx context.Context, id uuid.UUID, reason string, now time.Time) (bool, error) {
+	defer r.store.lock(ctx)()
+
+	u, ok := r.store.data.users[id]
+	if !ok || u.DeletedAt.Valid || u.Status != models.AccountSuspended || u.SuspendedUntil == nil || u.SuspendedUntil.After(now) {
+		return false, nil
+	}
+
+	u.Status = models.AccountActive
+	u.StatusReason = reason
+	u.SuspendedUntil = nil
+	u.UpdatedAt = now
+	r.store.data.users[id] = u
+	return true, nil
+}
+
+func (r *memoryUserRepository) FindScheduledForPurge(ctx context.Context, now time.Time, limit int) ([]uuid.UUID, error) {
+	defer r.store.lock(ctx)()
+
+	var ids []uuid.UUID
+	for _, u := range r.store.data.users {
+		if len(ids) == limit {
+			break
+		}
+		if u.ScheduledPurgeAt != nil && !u.ScheduledPurgeAt.After(now) {
+			ids = append(ids, u.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+func (r *memoryUserRepository) Purge(ctx context.Context, id uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	d := r.store.data
+	deleteWhere(d.tokens, func(t models.Token) bool { return t.UserID == id })
+	deleteWhere(d.passwordResetTokens, func(t models.PasswordResetToken) bool { return t.UserID == id })
+	deleteWhere(d.dataExports, func(e models.DataExport) bool { return e.UserID == id })
+	deleteWhere(d.emailChangeRequests, func(r models.EmailChangeRequest) bool { return r.UserID == id })
+	deleteWhere(d.profiles, func(p models.Profile) bool { return p.UserID == id })
+	d.usernameHistory = filterOut(d.usernameHistory, func(h models.UsernameHistory) bool { return h.UserID == id })
+	d.inviteCodeUses = filterOut(d.inviteCodeUses, func(u models.InviteCodeUse) bool { return u.UserID == id })
+	d.accountStatusChanges = filterOut(d.accountStatusChanges, func(c models.AccountStatusChange) bool { return c.UserID == id })
+	delete(d.users, id)
+
+	return nil
+}
+
+// find returns the first user matching the filter. Users are checked in no
+// particular order, which is fine as long as the filter matches one user.
+func (r *memoryUserRepository) find(ctx context.Context, withDeleted bool, filter func(u *models.User) bool) (*models.User, error) {
+	defer r.store.lock(ctx)()
+
+	for _, u := range r.store.data.users {
+		if !withDeleted && u.DeletedAt.Valid {
+			continue
+		}
+		if filter(&u) {
+			return &u, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+type memoryTokenRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryTokenRepository) Create(ctx context.Context, token *models.Token) error {
+	defer r.store.lock(ctx)()
+
+	if _, ok := r.store.data.tokens[token.ID]; ok {
+		return duplicateKeyError("token", "id")
+	}
+
+	r.store.data.tokens[token.ID] = *token
+	return nil
+}
+
+func (r *memoryTokenRepository) Save(ctx context.Context, tokens ...*models.Token) error {
+	defer r.store.lock(ctx)()
+
+	for _, t := range tokens {
+		r.store.data.tokens[t.ID] = *t
+	}
+
+	return nil
+}
+
+func (r *memoryTokenRepository) FindByToken(ctx context.Context, tokenString string) (*models.Token, error) {
+	defer r.store.lock(ctx)()
+
+	for _, t := range r.store.data.tokens {
+		if t.Token == tokenString {
+			return &t, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryTokenRepository) FindActiveByUser(ctx context.Context, userID uuid.UUID, now time.Time) ([]*models.Token, error) {
+	defer r.store.lock(ctx)()
+
+	var tokens []*models.Token
+	for _, t := range r.store.data.tokens {
+		if t.UserID == userID && t.ExpiresAt.After(now) {
+			token := t
+			tokens = append(tokens, &token)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (r *memoryTokenRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.Token, error) {
+	defer r.store.lock(ctx)()
+
+	var tokens []models.Token
+	for _, t := range r.store.data.tokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].IssuedAt.Before(tokens[j].IssuedAt) })
+	return tokens, nil
+}
+
+func (r *memoryTokenRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	defer r.store.lock(ctx)()
+
+	deleted := 0
+	for id, t := range r.store.data.tokens {
+		if deleted == limit {
+			break
+		}
+		if t.ExpiresAt.Before(before) {
+			delete(r.store.data.tokens, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type memoryProfileRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryProfileRepository) Create(ctx context.Context, profile *models.Profile) error {
+	defer r.store.lock(ctx)()
+
+	for _, p := range r.store.data.profiles {
+		if p.ID == profile.ID {
+			return duplicateKeyError("profile", "id")
+		}
+		if p.UserID == profile.UserID {
+			return duplicateKeyError("profile", "user_id")
+		}
+	}
+
+	r.store.data.profiles[profile.ID] = *profile
+	return nil
+}
+
+// Save leaves an unknown date of birth as it is, like Create.
+func (r *memoryProfileRepository) Save(ctx context.Context, profile *models.Profile) error {
+	defer r.store.lock(ctx)()
+
+	stored, ok := r.store.data.profiles[profile.ID]
+	if !ok {
+		return nil
+	}
+
+	stored.FirstName = profile.FirstName
+	stored.LastName = profile.LastName
+	stored.Address = profile.Address
+	stored.Phone = profile.Phone
+	stored.UpdatedAt = profile.UpdatedAt
+	if profile.DateOfBirth != "" {
+		stored.DateOfBirth = profile.DateOfBirth
+	}
+
+	r.store.data.profiles[profile.ID] = stored
+	return nil
+}
+
+func (r *memoryProfileRepository) FindByUser(ctx context.Context, userID uuid.UUID) (*models.Profile, error) {
+	return r.find(ctx, false, userID)
+}
+
+func (r *memoryProfileRepository) FindByUserWithDeleted(ctx context.Context, userID uuid.UUID) (*models.Profile, error) {
+	return r.find(ctx, true, userID)
+}
+
+func (r *memoryProfileRepository) FindByUsersWithDeleted(ctx context.Context, userIDs []uuid.UUID) ([]models.Profile, error) {
+	defer r.store.lock(ctx)()
+
+	ids := make(map[uuid.UUID]bool, len(userIDs))
+	for _, id := range userIDs {
+		ids[id] = true
+	}
+
+	var profiles []models.Profile
+	for _, p := range r.store.data.profiles {
+		if ids[p.UserID] {
+			profiles = append(profiles, p)
+		}
+	}
+
+	return profiles, nil
+}
+
+func (r *memoryProfileRepository) SetDeletedByUser(ctx context.Context, userID uuid.UUID, deletedAt *time.Time) error {
+	defer r.store.lock(ctx)()
+
+	for id, p := range r.store.data.profiles {
+		if p.UserID != userID {
+			continue
+		}
+
+		p.DeletedAt = gorm.DeletedAt{}
+		if deletedAt != nil {
+			p.DeletedAt = gorm.DeletedAt{Time: *deletedAt, Valid: true}
+		}
+		r.store.data.profiles[id] = p
+	}
+
+	return nil
+}
+
+func (r *memoryProfileRepository) find(ctx context.Context, withDeleted bool, userID uuid.UUID) (*models.Profile, error) {
+	defer r.store.lock(ctx)()
+
+	for _, p := range r.store.data.profiles {
+		if p.UserID == userID && (withDeleted || !p.DeletedAt.Valid) {
+			return &p, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+type memoryPasswordResetTokenRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryPasswordResetTokenRepository) Create(ctx context.Context, token *models.PasswordResetToken) error {
+	defer r.store.lock(ctx)()
+
+	if _, ok := r.store.data.passwordResetTokens[token.ID]; ok {
+		return duplicateKeyError("password reset token", "id")
+	}
+
+	r.store.data.passwordResetTokens[token.ID] = *token
+	return nil
+}
+
+func (r *memoryPasswordResetTokenRepository) FindValid(ctx context.Context, userID uuid.UUID, tokenString string, now time.Time) (*models.PasswordResetToken, error) {
+	defer r.store.lock(ctx)()
+
+	for _, t := range r.store.data.passwordResetTokens {
+		if t.UserID == userID && t.Token == tokenString && t.TokenExpiry.After(now) {
+			return &t, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryPasswordResetTokenRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.PasswordResetToken, error) {
+	defer r.store.lock(ctx)()
+
+	var tokens []models.PasswordResetToken
+	for _, t := range r.store.data.passwordResetTokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens, nil
+}
+
+func (r *memoryPasswordResetTokenRepository) DeleteExpired(ctx context.Context, before time.Time, limit int) (int, error) {
+	defer r.store.lock(ctx)()
+
+	deleted := 0
+	for id, t := range r.store.data.passwordResetTokens {
+		if deleted == limit {
+			break
+		}
+		if t.TokenExpiry.Before(before) {
+			delete(r.store.data.passwordResetTokens, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type memoryInviteCodeRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryInviteCodeRepository) Create(ctx context.Context, code *models.InviteCode) error {
+	defer r.store.lock(ctx)()
+
+	for _, c := range r.store.data.inviteCodes {
+		if c.ID == code.ID {
+			return duplicateKeyError("invite code", "id")
+		}
+		if c.Code == code.Code {
+			return duplicateKeyError("invite code", "code")
+		}
+	}
+
+	r.store.data.inviteCodes[code.ID] = *code
+	return nil
+}
+
+func (r *memoryInviteCodeRepository) Save(ctx context.Context, code *models.InviteCode) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.inviteCodes[code.ID] = *code
+	return nil
+}
+
+func (r *memoryInviteCodeRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.InviteCode, error) {
+	defer r.store.lock(ctx)()
+
+	code, ok := r.store.data.inviteCodes[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &code, nil
+}
+
+func (r *memoryInviteCodeRepository) FindByCreator(ctx context.Context, userID uuid.UUID) ([]models.InviteCode, error) {
+	defer r.store.lock(ctx)()
+
+	var codes []models.InviteCode
+	for _, c := range r.store.data.inviteCodes {
+		if c.CreatedBy != nil && *c.CreatedBy == userID {
+			codes = append(codes, c)
+		}
+	}
+
+	sortMostRecentFirst(codes, func(c *models.InviteCode) (time.Time, uuid.UUID) { return c.CreatedAt, c.ID })
+	return codes, nil
+}
+
+func (r *memoryInviteCodeRepository) CountCreatedSince(ctx context.Context, userID uuid.UUID, since time.Time) (int, error) {
+	defer r.store.lock(ctx)()
+
+	count := 0
+	for _, c := range r.store.data.inviteCodes {
+		if c.CreatedBy != nil && *c.CreatedBy == userID && !c.CreatedAt.Before(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *memoryInviteCodeRepository) List(ctx context.Context, createdBy *uuid.UUID, offset int, limit int) ([]models.InviteCode, int64, error) {
+	defer r.store.lock(ctx)()
+
+	var codes []models.InviteCode
+	for _, c := range r.store.data.inviteCodes {
+		if createdBy == nil || (c.CreatedBy != nil && *c.CreatedBy == *createdBy) {
+			codes = append(codes, c)
+		}
+	}
+
+	sortMostRecentFirst(codes, func(c *models.InviteCode) (time.Time, uuid.UUID) { return c.CreatedAt, c.ID })
+	return page(codes, offset, limit), int64(len(codes)), nil
+}
+
+func (r *memoryInviteCodeRepository) FindUses(ctx context.Context, codeID uuid.UUID) ([]models.InviteCodeUse, error) {
+	defer r.store.lock(ctx)()
+
+	var uses []models.InviteCodeUse
+	for _, u := range r.store.data.inviteCodeUses {
+		if u.InviteCodeID == codeID {
+			uses = append(uses, u)
+		}
+	}
+
+	sort.SliceStable(uses, func(i, j int) bool { return uses[i].UsedAt.Before(uses[j].UsedAt) })
+	return uses, nil
+}
+
+// FindRedeemable needs no row lock: the transaction it is called in holds
+// the store's lock until it ends.
+func (r *memoryInviteCodeRepository) FindRedeemable(ctx context.Context, code string, now time.Time) (*models.InviteCode, error) {
+	defer r.store.lock(ctx)()
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for _, c := range r.store.data.inviteCodes {
+		if c.Code == code && c.RevokedAt == nil && c.ExpiresAt.After(now) && c.UseCount < c.MaxUses {
+			return &c, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryInviteCodeRepository) Redeem(ctx context.Context, code *models.InviteCode, use *models.InviteCodeUse) error {
+	defer r.store.lock(ctx)()
+
+	stored, ok := r.store.data.inviteCodes[code.ID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	stored.UseCount = code.UseCount
+
+	r.store.data.inviteCodes[code.ID] = stored
+	r.store.data.inviteCodeUses = append(r.store.data.inviteCodeUses, *use)
+	return nil
+}
+
+type memoryAccountStatusChangeRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryAccountStatusChangeRepository) Create(ctx context.Context, change *models.AccountStatusChange) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.accountStatusChanges = append(r.store.data.accountStatusChanges, *change)
+	return nil
+}
+
+func (r *memoryAccountStatusChangeRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.AccountStatusChange, error) {
+	defer r.store.lock(ctx)()
+
+	var changes []models.AccountStatusChange
+	for _, c := range r.store.data.accountStatusChanges {
+		if c.UserID == userID {
+			changes = append(changes, c)
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].CreatedAt.Before(changes[j].CreatedAt) })
+	return changes, nil
+}
+
+type memoryAuditEventRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryAuditEventRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.auditEvents = append(r.store.data.auditEvents, *event)
+	return nil
+}
+
+func (r *memoryAuditEventRepository) Find(ctx context.Context, filter models.AuditEventFilter, before *models.ListPosition, limit int) ([]models.AuditEvent, error) {
+	defer r.store.lock(ctx)()
+
+	var events []models.AuditEvent
+	for _, e := range r.store.data.auditEvents {
+		if matchesAuditEventFilter(&e, filter) && (before == nil || positionBefore(e.CreatedAt, e.ID, before)) {
+			events = append(events, e)
+		}
+	}
+
+	// Most recent first.
+	sort.Slice(events, func(i, j int) bool {
+		return positionBefore(events[j].CreatedAt, events[j].ID, &models.ListPosition{CreatedAt: events[i].CreatedAt, ID: events[i].ID})
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+func matchesAuditEventFilter(e *models.AuditEvent, filter models.AuditEventFilter) bool {
+	if filter.ActorID != nil && (e.ActorID == nil || *e.ActorID != *filter.ActorID) {
+		return false
+	}
+	if filter.TargetUserID != nil && (e.TargetUserID == nil || *e.TargetUserID != *filter.TargetUserID) {
+		return false
+	}
+	if filter.Action != "" && e.Action != filter.Action {
+		return false
+	}
+	if filter.Outcome != "" && e.Outcome != filter.Outcome {
+		return false
+	}
+	if filter.From != nil && e.CreatedAt.Before(*filter.From) {
+		return false
+	}
+	if filter.To != nil && !e.CreatedAt.Before(*filter.To) {
+		return false
+	}
+
+	return true
+}
+
+// positionBefore tells whether a row comes before position when ordered by
+// creation time and ID, comparing IDs the way Postgres compares UUIDs.
+func positionBefore(createdAt time.Time, id uuid.UUID, position *models.ListPosition) bool {
+	if !createdAt.Equal(position.CreatedAt) {
+		return createdAt.Before(position.CreatedAt)
+	}
+
+	return id.String() < position.ID.String()
+}
+
+type memoryOutboxEventRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryOutboxEventRepository) Create(ctx context.Context, event *models.OutboxEvent) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.outboxEvents = append(r.store.data.outboxEvents, *event)
+	return nil
+}
+
+func (r *memoryOutboxEventRepository) Save(ctx context.Context, event *models.OutboxEvent) error {
+	defer r.store.lock(ctx)()
+
+	for i := range r.store.data.outboxEvents {
+		if r.store.data.outboxEvents[i].ID == event.ID {
+			r.store.data.outboxEvents[i] = *event
+			return nil
+		}
+	}
+
+	return gorm.ErrRecordNotFound
+}
+
+// FindUnpublished returns events in the order they were written, which is
+// the order they are kept in.
+func (r *memoryOutboxEventRepository) FindUnpublished(ctx context.Context, now time.Time, limit int) ([]models.OutboxEvent, error) {
+	defer r.store.lock(ctx)()
+
+	var events []models.OutboxEvent
+	for _, e := range r.store.data.outboxEvents {
+		if e.PublishedAt == nil && e.DeadAt == nil && (e.ClaimedUntil == nil || !e.ClaimedUntil.After(now)) {
+			events = append(events, e)
+		}
+		if len(events) == limit {
+			break
+		}
+	}
+
+	return events, nil
+}
+
+func (r *memoryOutboxEventRepository) Claim(ctx context.Context, ids []uuid.UUID, claimedUntil *time.Time) error {
+	defer r.store.lock(ctx)()
+
+	claimed := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		claimed[id] = true
+	}
+	for i := range r.store.data.outboxEvents {
+		if e := &r.store.data.outboxEvents[i]; claimed[e.ID] {
+			e.ClaimedUntil = claimedUntil
+		}
+	}
+
+	return nil
+}
+
+type memoryEmailChangeRequestRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryEmailChangeRequestRepository) Create(ctx context.Context, request *models.EmailChangeRequest) error {
+	defer r.store.lock(ctx)()
+
+	if _, ok := r.store.data.emailChangeRequests[request.ID]; ok {
+		return duplicateKeyError("email change request", "id")
+	}
+
+	r.store.data.emailChangeRequests[request.ID] = *request
+	return nil
+}
+
+func (r *memoryEmailChangeRequestRepository) Confirm(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	return r.resolve(ctx, id, now, func(request *models.EmailChangeRequest) { request.ConfirmedAt = &now })
+}
+
+func (r *memoryEmailChangeRequestRepository) Cancel(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	return r.resolve(ctx, id, now, func(request *models.EmailChangeRequest) { request.CancelledAt = &now })
+}
+
+// resolve applies set to the request if it is still pending at now.
+func (r *memoryEmailChangeRequestRepository) resolve(ctx context.Context, id uuid.UUID, now time.Time, set func(request *models.EmailChangeRequest)) (bool, error) {
+	defer r.store.lock(ctx)()
+
+	request, ok := r.store.data.emailChangeRequests[id]
+	if !ok || !isPendingEmailChange(&request) || !request.TokenExpiry.After(now) {
+		return false, nil
+	}
+
+	set(&request)
+	r.store.data.emailChangeRequests[id] = request
+	return true, nil
+}
+
+func (r *memoryEmailChangeRequestRepository) FindPendingByConfirmToken(ctx context.Context, confirmToken string, now time.Time) (*models.EmailChangeRequest, error) {
+	return r.findPending(ctx, now, func(request *models.EmailChangeRequest) bool { return request.ConfirmToken == confirmToken })
+}
+
+func (r *memoryEmailChangeRequestRepository) FindPendingByCancelToken(ctx context.Context, cancelToken string, now time.Time) (*models.EmailChangeRequest, error) {
+	return r.findPending(ctx, now, func(request *models.EmailChangeRequest) bool { return request.CancelToken == cancelToken })
+}
+
+func (r *memoryEmailChangeRequestRepository) findPending(ctx context.Context, now time.Time, filter func(request *models.EmailChangeRequest) bool) (*models.EmailChangeRequest, error) {
+	defer r.store.lock(ctx)()
+
+	for _, request := range r.store.data.emailChangeRequests {
+		if isPendingEmailChange(&request) && request.TokenExpiry.After(now) && filter(&request) {
+			return &request, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryEmailChangeRequestRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.EmailChangeRequest, error) {
+	defer r.store.lock(ctx)()
+
+	var requests []models.EmailChangeRequest
+	for _, request := range r.store.data.emailChangeRequests {
+		if request.UserID == userID {
+			requests = append(requests, request)
+		}
+	}
+
+	sort.Slice(requests, func(i, j int) bool { return requests[i].CreatedAt.Before(requests[j].CreatedAt) })
+	return requests, nil
+}
+
+func (r *memoryEmailChangeRequestRepository) CancelPendingByUser(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	defer r.store.lock(ctx)()
+
+	for id, request := range r.store.data.emailChangeRequests {
+		if request.UserID == userID && isPendingEmailChange(&request) {
+			request.CancelledAt = &at
+			r.store.data.emailChangeRequests[id] = request
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryEmailChangeRequestRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	defer r.store.lock(ctx)()
+
+	return deleteWhere(r.store.data.emailChangeRequests, func(request models.EmailChangeRequest) bool {
+		return isPendingEmailChange(&request) && request.TokenExpiry.Before(before)
+	}), nil
+}
+
+func isPendingEmailChange(request *models.EmailChangeRequest) bool {
+	return request.ConfirmedAt == nil && request.CancelledAt == nil
+}
+
+type memoryUsernameHistoryRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryUsernameHistoryRepository) Create(ctx context.Context, history *models.UsernameHistory) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.usernameHistory = append(r.store.data.usernameHistory, *history)
+	return nil
+}
+
+func (r *memoryUsernameHistoryRepository) FindLatestByUser(ctx context.Context, userID uuid.UUID) (*models.UsernameHistory, error) {
+	return r.latest(ctx, func(h *models.UsernameHistory) bool { return h.UserID == userID })
+}
+
+func (r *memoryUsernameHistoryRepository) FindLatestByOldUsername(ctx context.Context, oldUsernameCanonical string) (*models.UsernameHistory, error) {
+	return r.latest(ctx, func(h *models.UsernameHistory) bool { return h.OldUsernameCanonical == oldUsernameCanonical })
+}
+
+func (r *memoryUsernameHistoryRepository) FindByUser(ctx context.Context, userID uuid.UUID) ([]models.UsernameHistory, error) {
+	defer r.store.lock(ctx)()
+
+	var history []models.UsernameHistory
+	for _, h := range r.store.data.usernameHistory {
+		if h.UserID == userID {
+			history = append(history, h)
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool { return history[i].ChangedAt.Before(history[j].ChangedAt) })
+	return history, nil
+}
+
+func (r *memoryUsernameHistoryRepository) latest(ctx context.Context, filter func(h *models.UsernameHistory) bool) (*models.UsernameHistory, error) {
+	defer r.store.lock(ctx)()
+
+	var latest *models.UsernameHistory
+	for _, h := range r.store.data.usernameHistory {
+		if filter(&h) && (latest == nil || !h.ChangedAt.Before(latest.ChangedAt)) {
+			history := h
+			latest = &history
+		}
+	}
+
+	if latest == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return latest, nil
+}
+
+type memoryDataExportRepository struct {
+	store *MemoryStore
+}
+
+// Create keeps a single export per user in progress, like the partial
+// unique index on data_exports.
+func (r *memoryDataExportRepository) Create(ctx context.Context, export *models.DataExport) error {
+	defer r.store.lock(ctx)()
+
+	if _, ok := r.store.data.dataExports[export.ID]; ok {
+		return duplicateKeyError("data export", "id")
+	}
+	if isDataExportInProgress(export) {
+		for _, e := range r.store.data.dataExports {
+			if e.UserID == export.UserID && isDataExportInProgress(&e) {
+				return duplicateKeyError("data export", "user_id")
+			}
+		}
+	}
+
+	r.store.data.dataExports[export.ID] = *export
+	return nil
+}
+
+func (r *memoryDataExportRepository) Save(ctx context.Context, export *models.DataExport) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.dataExports[export.ID] = *export
+	return nil
+}
+
+func (r *memoryDataExportRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.DataExport, error) {
+	defer r.store.lock(ctx)()
+
+	export, ok := r.store.data.dataExports[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &export, nil
+}
+
+func (r *memoryDataExportRepository) ExistsInProgress(ctx context.Context, userID uuid.UUID) (bool, error) {
+	defer r.store.lock(ctx)()
+
+	for _, e := range r.store.data.dataExports {
+		if e.UserID == userID && isDataExportInProgress(&e) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// FindPending needs no locking of its own: the transaction it runs in holds
+// the store's lock.
+func (r *memoryDataExportRepository) FindPending(ctx context.Context, limit int) ([]models.DataExport, error) {
+	defer r.store.lock(ctx)()
+
+	var exports []models.DataExport
+	for _, e := range r.store.data.dataExports {
+		if e.Status == models.DataExportPending {
+			exports = append(exports, e)
+		}
+	}
+	sortOldestFirst(exports, func(e *models.DataExport) (time.Time, uuid.UUID) { return e.CreatedAt, e.ID })
+
+	if len(exports) > limit {
+		exports = exports[:limit]
+	}
+
+	return exports, nil
+}
+
+func (r *memoryDataExportRepository) MarkProcessing(ctx context.Context, ids []uuid.UUID, startedAt time.Time) error {
+	defer r.store.lock(ctx)()
+
+	for _, id := range ids {
+		if e, ok := r.store.data.dataExports[id]; ok {
+			e.Status = models.DataExportProcessing
+			e.StartedAt = &startedAt
+			r.store.data.dataExports[id] = e
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryDataExportRepository) ResetStale(ctx context.Context, startedBefore time.Time) (int, error) {
+	defer r.store.lock(ctx)()
+
+	reset := 0
+	for id, e := range r.store.data.dataExports {
+		if e.Status == models.DataExportProcessing && e.StartedAt != nil && e.StartedAt.Before(startedBefore) {
+			e.Status = models.DataExportPending
+			e.StartedAt = nil
+			r.store.data.dataExports[id] = e
+			reset++
+		}
+	}
+
+	return reset, nil
+}
+
+func (r *memoryDataExportRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	defer r.store.lock(ctx)()
+
+	return deleteWhere(r.store.data.dataExports, func(e models.DataExport) bool {
+		return e.ExpiresAt != nil && e.ExpiresAt.Before(before)
+	}), nil
+}
+
+func isDataExportInProgress(e *models.DataExport) bool {
+	return e.Status == models.DataExportPending || e.Status == models.DataExportProcessing
+}
+
+type memorySAMLAssertionRepository struct {
+	store *MemoryStore
+}
+
+func (r *memorySAMLAssertionRepository) Create(ctx context.Context, assertion *models.SAMLAssertion) error {
+	defer r.store.lock(ctx)()
+
+	key := samlAssertionKey{identityProvider: assertion.IdentityProvider, assertionID: assertion.AssertionID}
+	if _, ok := r.store.data.samlAssertions[key]; ok {
+		return duplicateKeyError("SAML assertion", "assertion_id")
+	}
+
+	r.store.data.samlAssertions[key] = *assertion
+	return nil
+}
+
+func (r *memorySAMLAssertionRepository) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	defer r.store.lock(ctx)()
+
+	return deleteWhere(r.store.data.samlAssertions, func(a models.SAMLAssertion) bool {
+		return a.ExpiresAt.Before(before)
+	}), nil
+}
+
+type memoryWebhookEndpointRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryWebhookEndpointRepository) Create(ctx context.Context, endpoint *models.WebhookEndpoint) error {
+	defer r.store.lock(ctx)()
+
+	if _, ok := r.store.data.webhookEndpoints[endpoint.ID]; ok {
+		return duplicateKeyError("webhook endpoint", "id")
+	}
+
+	r.store.data.webhookEndpoints[endpoint.ID] = *endpoint
+	return nil
+}
+
+func (r *memoryWebhookEndpointRepository) Save(ctx context.Context, endpoint *models.WebhookEndpoint) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.webhookEndpoints[endpoint.ID] = *endpoint
+	return nil
+}
+
+func (r *memoryWebhookEndpointRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.WebhookEndpoint, error) {
+	defer r.store.lock(ctx)()
+
+	endpoint, ok := r.store.data.webhookEndpoints[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &endpoint, nil
+}
+
+func (r *memoryWebhookEndpointRepository) FindAll(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	return r.find(ctx, func(e *models.WebhookEndpoint) bool { return true })
+}
+
+func (r *memoryWebhookEndpointRepository) FindActive(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	return r.find(ctx, func(e *models.WebhookEndpoint) bool { return e.Active })
+}
+
+func (r *memoryWebhookEndpointRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	defer r.store.lock(ctx)()
+
+	d := r.store.data
+	deliveries := map[uuid.UUID]bool{}
+	for deliveryID, delivery := range d.webhookDeliveries {
+		if delivery.EndpointID == id {
+			deliveries[deliveryID] = true
+			delete(d.webhookDeliveries, deliveryID)
+		}
+	}
+	d.webhookAttempts = filterOut(d.webhookAttempts, func(a models.WebhookDeliveryAttempt) bool { return deliveries[a.DeliveryID] })
+	delete(d.webhookEndpoints, id)
+
+	return nil
+}
+
+func (r *memoryWebhookEndpointRepository) find(ctx context.Context, filter func(e *models.WebhookEndpoint) bool) ([]models.WebhookEndpoint, error) {
+	defer r.store.lock(ctx)()
+
+	var endpoints []models.WebhookEndpoint
+	for _, e := range r.store.data.webhookEndpoints {
+		if filter(&e) {
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	sortOldestFirst(endpoints, func(e *models.WebhookEndpoint) (time.Time, uuid.UUID) { return e.CreatedAt, e.ID })
+	return endpoints, nil
+}
+
+type memoryWebhookDeliveryRepository struct {
+	store *MemoryStore
+}
+
+func (r *memoryWebhookDeliveryRepository) Enqueue(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	defer r.store.lock(ctx)()
+
+	queued := map[[2]uuid.UUID]bool{}
+	for _, d := range r.store.data.webhookDeliveries {
+		queued[[2]uuid.UUID{d.EndpointID, d.EventID}] = true
+	}
+
+	for _, d := range deliveries {
+		key := [2]uuid.UUID{d.EndpointID, d.EventID}
+		if queued[key] {
+			continue
+		}
+
+		queued[key] = true
+		r.store.data.webhookDeliveries[d.ID] = d
+	}
+
+	return nil
+}
+
+func (r *memoryWebhookDeliveryRepository) Save(ctx context.Context, delivery *models.WebhookDelivery) error {
+	defer r.store.lock(ctx)()
+
+	stored, ok := r.store.data.webhookDeliveries[delivery.ID]
+	if !ok {
+		return nil
+	}
+
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.NextAttemptAt = delivery.NextAttemptAt
+	stored.LastStatusCode = delivery.LastStatusCode
+	stored.LastError = delivery.LastError
+	stored.DeliveredAt = delivery.DeliveredAt
+	r.store.data.webhookDeliveries[delivery.ID] = stored
+	return nil
+}
+
+func (r *memoryWebhookDeliveryRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	defer r.store.lock(ctx)()
+
+	delivery, ok := r.store.data.webhookDeliveries[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return &delivery, nil
+}
+
+func (r *memoryWebhookDeliveryRepository) FindByEndpoint(ctx context.Context, endpointID uuid.UUID, status string, offset int, limit int) ([]models.WebhookDelivery, int64, error) {
+	defer r.store.lock(ctx)()
+
+	var deliveries []models.WebhookDelivery
+	for _, d := range r.store.data.webhookDeliveries {
+		if d.EndpointID == endpointID && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	sortMostRecentFirst(deliveries, func(d *models.WebhookDelivery) (time.Time, uuid.UUID) { return d.CreatedAt, d.ID })
+	return page(deliveries, offset, limit), int64(len(deliveries)), nil
+}
+
+// FindDue needs no row lock: the transaction it is called in holds the
+// store's lock until it ends.
+func (r *memoryWebhookDeliveryRepository) FindDue(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	defer r.store.lock(ctx)()
+
+	var deliveries []models.WebhookDelivery
+	for _, d := range r.store.data.webhookDeliveries {
+		waiting := d.Status == models.WebhookDeliveryPending || d.Status == models.WebhookDeliveryRetrying
+		if waiting && !d.NextAttemptAt.After(now) {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt) })
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries, nil
+}
+
+func (r *memoryWebhookDeliveryRepository) Reschedule(ctx context.Context, ids []uuid.UUID, nextAttemptAt time.Time) error {
+	defer r.store.lock(ctx)()
+
+	for _, id := range ids {
+		if d, ok := r.store.data.webhookDeliveries[id]; ok {
+			d.NextAttemptAt = nextAttemptAt
+			r.store.data.webhookDeliveries[id] = d
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryWebhookDeliveryRepository) CreateAttempt(ctx context.Context, attempt *models.WebhookDeliveryAttempt) error {
+	defer r.store.lock(ctx)()
+
+	r.store.data.webhookAttempts = append(r.store.data.webhookAttempts, *attempt)
+	return nil
+}
+
+func (r *memoryWebhookDeliveryRepository) FindAttempts(ctx context.Context, deliveryID uuid.UUID) ([]models.WebhookDeliveryAttempt, error) {
+	defer r.store.lock(ctx)()
+
+	var attempts []models.WebhookDeliveryAttempt
+	for _, a := range r.store.data.webhookAttempts {
+		if a.DeliveryID == deliveryID {
+			attempts = append(attempts, a)
+		}
+	}
+
+	sort.SliceStable(attempts, func(i, j int) bool { return attempts[i].CreatedAt.Before(attempts[j].CreatedAt) })
+	return attempts, nil
+}
+
+// sortOldestFirst sorts rows by creation time and ID, like the lists that
+// paginate with a models.ListPosition.
+func sortOldestFirst[T any](rows []T, position func(row *T) (time.Time, uuid.UUID)) {
+	sort.Slice(rows, func(i, j int) bool { return rowBefore(&rows[i], &rows[j], position) })
+}
+
+func sortMostRecentFirst[T any](rows []T, position func(row *T) (time.Time, uuid.UUID)) {
+	sort.Slice(rows, func(i, j int) bool { return rowBefore(&rows[j], &rows[i], position) })
+}
+
+func rowBefore[T any](a *T, b *T, position func(row *T) (time.Time, uuid.UUID)) bool {
+	aCreatedAt, aID := position(a)
+	bCreatedAt, bID := position(b)
+	return positionBefore(aCreatedAt, aID, &models.ListPosition{CreatedAt: bCreatedAt, ID: bID})
+}
+
+// page returns the rows of the page starting at offset.
+func page[T any](rows []T, offset int, limit int) []T {
+	if offset >= len(rows) {
+		return nil
+	}
+
+	rows = rows[offset:]
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	return rows
+}
+
+// deleteWhere deletes the values matching the filter and returns how many
+// were deleted.
+func deleteWhere[K comparable, V any](m map[K]V, filter func(v V) bool) int {
+	deleted := 0
+	for k, v := range m {
+		if filter(v) {
+			delete(m, k)
+			deleted++
+		}
+	}
+
+	return deleted
+}
+
+// filterOut returns a copy of rows without those matching the filter, so
+// that snapshots sharing the original array are left as they are.
+func filterOut[T any](rows []T, filter func(row T) bool) []T {
+	var kept []T
+	for _, row := range rows {
+		if !filter(row) {
+			kept = append(kept, row)
+		}
+	}
+
+	return kept
+}
+
+func found(err error) (bool, error) {
+	if utils.IsRecordNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
+)
+
+const (
+	PostgresStorage = "postgres"
+	MemoryStorage   = "memory"
+)
+
+// NewStore sets up the storage selected by cfg.Storage. With Postgres it
+// also returns the connection, for migrations, metrics and leader election.
+// The connection is nil with in-memory storage.
+func NewStore(cfg *config.Config) (models.Store, *gorm.DB, error) {
+	switch cfg.Storage {
+	case PostgresStorage:
+		db, err := config.OpenDB(&cfg.DB)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Query variables are left out of the spans, as they include tokens
+		// and password hashes.
+		if err := db.Use(tracing.NewPlugin(tracing.WithoutQueryVariables(), tracing.WithoutMetrics())); err != nil {
+			return nil, nil, err
+		}
+
+		return models.NewPostgresStore(db), db, nil
+	case MemoryStorage:
+		return NewMemoryStore(), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
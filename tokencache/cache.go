@@ -0,0 +1,252 @@
+// Package tokencache caches the tokens found valid, so that authenticating a
+// request does not need a database lookup.
+//
+// Each replica keeps recently used tokens in memory for a short while. A
+// Redis-compatible server, if configured, is shared by the replicas: valid
+// tokens are cached there for longer, and revocations are published to a
+// list every replica polls to evict the revoked tokens from memory.
+package tokencache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/metrics"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+const redisTimeout = time.Second
+
+// Cache implements models.TokenCache.
+type Cache struct {
+	cfg   *config.TokenCacheConfig
+	local *lru
+	// shared is nil without a shared cache.
+	shared *redis.Client
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New builds the cache described by cfg and, with a shared cache, starts
+// polling revocations until Close.
+func New(cfg *config.TokenCacheConfig) (*Cache, error) {
+	c := &Cache{cfg: cfg, local: newLRU(cfg.Size)}
+	if cfg.RedisURL == "" {
+		return c, nil
+	}
+
+	options, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token cache redis URL: %w", err)
+	}
+	c.shared = redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := c.shared.Ping(ctx).Err(); err != nil {
+		c.shared.Close()
+		return nil, fmt.Errorf("can not connect to the token cache: %w", err)
+	}
+
+	ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.pollRevocations(ctx)
+	}()
+
+	return c, nil
+}
+
+// IsValid tells whether the token is cached as valid. Failures of the
+// shared cache count as misses.
+func (c *Cache) IsValid(ctx context.Context, tokenString string) bool {
+	key := cacheKey(tokenString)
+	now := time.Now()
+
+	if c.local.get(key, now) {
+		metrics.TokenCacheLookups.WithLabelValues("local_hit").Inc()
+		return true
+	}
+
+	if c.shared != nil {
+		ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+		defer cancel()
+
+		value, err := c.shared.Get(ctx, c.cfg.KeyPrefix+key).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			log.Printf("token cache lookup failed: %v", err)
+		}
+		if err == nil {
+			if expiresAt, err := strconv.ParseInt(value, 10, 64); err == nil {
+				c.addLocal(key, time.UnixMilli(expiresAt), now)
+				metrics.TokenCacheLookups.WithLabelValues("shared_hit").Inc()
+				return true
+			}
+		}
+	}
+
+	metrics.TokenCacheLookups.WithLabelValues("miss").Inc()
+	return false
+}
+
+// Add caches a token found valid until it expires. Failures of the shared
+// cache are logged.
+func (c *Cache) Add(ctx context.Context, token *models.Token) {
+	key := cacheKey(token.Token)
+	now := time.Now()
+	c.addLocal(key, token.ExpiresAt, now)
+
+	if c.shared == nil {
+		return
+	}
+
+	ttl := min(c.cfg.SharedTTL, token.ExpiresAt.Sub(now))
+	if ttl <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	value := strconv.FormatInt(token.ExpiresAt.UnixMilli(), 10)
+	if err := c.shared.Set(ctx, c.cfg.KeyPrefix+key, value, ttl).Err(); err != nil {
+		log.Printf("failed to cache token: %v", err)
+	}
+}
+
+// Revoke evicts the tokens from the cache of this replica and from the
+// shared cache, and publishes their revocation to the other replicas.
+func (c *Cache) Revoke(ctx context.Context, tokens ...*models.Token) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		keys = append(keys, cacheKey(token.Token))
+	}
+	c.local.remove(keys...)
+
+	if c.shared == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+
+	// Revocations are ordered by the clock of the shared cache, which all
+	// replicas poll against.
+	now, err := c.shared.Time(ctx).Result()
+	if err != nil {
+		return fmt.Errorf("can not publish token revocation: %w", err)
+	}
+
+	pipe := c.shared.TxPipeline()
+	revocations := make([]redis.Z, 0, len(keys))
+	for _, key := range keys {
+		pipe.Del(ctx, c.cfg.KeyPrefix+key)
+		revocations = append(revocations, redis.Z{Score: float64(now.UnixMilli()), Member: key})
+	}
+	pipe.ZAdd(ctx, c.revocationsKey(), revocations...)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("can not publish token revocation: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops polling revocations and disconnects from the shared cache.
+func (c *Cache) Close() error {
+	if c.shared == nil {
+		return nil
+	}
+
+	c.cancel()
+	c.wg.Wait()
+	return c.shared.Close()
+}
+
+func (c *Cache) addLocal(key string, expiresAt time.Time, now time.Time) {
+	c.local.add(key, minTime(expiresAt, now.Add(c.cfg.LocalTTL)))
+}
+
+// pollRevocations evicts the tokens revoked on other replicas from memory,
+// and trims the revocations no replica can still have in memory.
+func (c *Cache) pollRevocations(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.RevocationPollInterval)
+	defer ticker.Stop()
+
+	// Tokens cached in memory before the first poll were cached at most
+	// LocalTTL ago.
+	var since time.Time
+	retention := c.cfg.LocalTTL + c.cfg.RevocationPollInterval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now, err := c.shared.Time(ctx).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failed to poll token revocations: %v", err)
+			}
+			continue
+		}
+		if since.IsZero() {
+			since = now.Add(-retention)
+		}
+
+		// Read from one interval back, as revocations published while the
+		// previous poll ran may be ordered before it.
+		keys, err := c.shared.ZRangeByScore(ctx, c.revocationsKey(), &redis.ZRangeBy{
+			Min: strconv.FormatInt(since.Add(-c.cfg.RevocationPollInterval).UnixMilli(), 10),
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failed to poll token revocations: %v", err)
+			}
+			continue
+		}
+		c.local.remove(keys...)
+		since = now
+
+		trimBefore := strconv.FormatInt(now.Add(-retention).UnixMilli(), 10)
+		if err := c.shared.ZRemRangeByScore(ctx, c.revocationsKey(), "-inf", "("+trimBefore).Err(); err != nil {
+			log.Printf("failed to trim token revocations: %v", err)
+		}
+	}
+}
+
+func (c *Cache) revocationsKey() string {
+	return c.cfg.KeyPrefix + "revocations"
+}
+
+// cacheKey identifies a token without keeping it, so that tokens can not be
+// read from the cache.
+func cacheKey(tokenString string) string {
+	sum := sha256.Sum256([]byte(tokenString))
+	return hex.EncodeToString(sum[:])
+}
+
+func minTime(a time.Time, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,109 @@
+package tokencache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/models"
+	"github.com/vantutran2k1/social-network-auth/tokencache"
+)
+
+func newTestConfig(redisURL string) *config.TokenCacheConfig {
+	return &config.TokenCacheConfig{
+		Size:                   10,
+		LocalTTL:               time.Minute,
+		RedisURL:               redisURL,
+		SharedTTL:              time.Minute,
+		KeyPrefix:              "test:tokens:",
+		RevocationPollInterval: 10 * time.Millisecond,
+	}
+}
+
+func newTestCache(t *testing.T, cfg *config.TokenCacheConfig) *tokencache.Cache {
+	t.Helper()
+	c, err := tokencache.New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func newTestToken(value string) *models.Token {
+	return &models.Token{Token: value, ExpiresAt: time.Now().Add(time.Hour)}
+}
+
+func TestCacheWithoutSharedCache(t *testing.T) {
+	c := newTestCache(t, newTestConfig(""))
+	ctx := context.Background()
+	token := newTestToken("token")
+
+	if c.IsValid(ctx, token.Token) {
+		t.Fatal("IsValid() = true before Add()")
+	}
+	c.Add(ctx, token)
+	if !c.IsValid(ctx, token.Token) {
+		t.Fatal("IsValid() = false after Add()")
+	}
+	if err := c.Revoke(ctx, token); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if c.IsValid(ctx, token.Token) {
+		t.Error("IsValid() = true after Revoke()")
+	}
+}
+
+func TestCacheDoesNotKeepExpiredTokens(t *testing.T) {
+	c := newTestCache(t, newTestConfig(""))
+	ctx := context.Background()
+	token := &models.Token{Token: "token", ExpiresAt: time.Now().Add(-time.Second)}
+
+	c.Add(ctx, token)
+	if c.IsValid(ctx, token.Token) {
+		t.Error("IsValid() = true for an expired token")
+	}
+}
+
+func TestCacheSharesTokensAndRevocationsBetweenReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	cfg := newTestConfig("redis://" + server.Addr())
+	a := newTestCache(t, cfg)
+	b := newTestCache(t, cfg)
+	ctx := context.Background()
+	token := newTestToken("token")
+
+	a.Add(ctx, token)
+	if !b.IsValid(ctx, token.Token) {
+		t.Fatal("IsValid() on another replica = false, want a shared hit")
+	}
+
+	// b now holds the token in memory, so only the revocation it polls
+	// can evict it.
+	if err := a.Revoke(ctx, token); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if a.IsValid(ctx, token.Token) {
+		t.Error("IsValid() = true on the revoking replica")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for b.IsValid(ctx, token.Token) {
+		if time.Now().After(deadline) {
+			t.Fatal("the other replica still holds the revoked token")
+		}
+		time.Sleep(cfg.RevocationPollInterval)
+	}
+}
+
+func TestNewFailsWithoutSharedCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	addr := server.Addr()
+	server.Close()
+
+	if _, err := tokencache.New(newTestConfig("redis://" + addr)); err == nil {
+		t.Error("New() error = nil for an unreachable shared cache")
+	}
+}
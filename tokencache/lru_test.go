@@ -0,0 +1,51 @@
+package tokencache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUExpiresKeys(t *testing.T) {
+	c := newLRU(2)
+	now := time.Now()
+	c.add("a", now.Add(time.Minute))
+
+	if !c.get("a", now) {
+		t.Error("get() = false before the key expired")
+	}
+	if c.get("a", now.Add(time.Minute)) {
+		t.Error("get() = true once the key expired")
+	}
+	if _, ok := c.items["a"]; ok {
+		t.Error("get() kept the expired key")
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRU(2)
+	now := time.Now()
+	expiresAt := now.Add(time.Minute)
+	c.add("a", expiresAt)
+	c.add("b", expiresAt)
+	// Using a makes b the least recently used.
+	c.get("a", now)
+	c.add("c", expiresAt)
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if got := c.get(key, now); got != want {
+			t.Errorf("get(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestLRURemove(t *testing.T) {
+	c := newLRU(2)
+	now := time.Now()
+	c.add("a", now.Add(time.Minute))
+	c.add("b", now.Add(time.Minute))
+	c.remove("a", "missing")
+
+	if c.get("a", now) || !c.get("b", now) {
+		t.Error("remove() did not evict exactly the given keys")
+	}
+}
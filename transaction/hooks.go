@@ -0,0 +1,65 @@
+package transaction
+
+import "context"
+
+type hooksKey struct{}
+
+// CommitHooks are the functions registered with AfterCommit during a
+// transaction. Stores keep them for the transactions they run: the hooks are
+// run once the outermost transaction commits, and the hooks registered in a
+// nested transaction are dropped when it is rolled back.
+type CommitHooks struct {
+	fns []func()
+}
+
+// WithCommitHooks returns a context carrying the hooks of a new outermost
+// transaction.
+func WithCommitHooks(ctx context.Context) (context.Context, *CommitHooks) {
+	hooks := &CommitHooks{}
+	return context.WithValue(ctx, hooksKey{}, hooks), hooks
+}
+
+// CommitHooksFrom returns the hooks of the transaction ctx carries, or nil if
+// it carries none.
+func CommitHooksFrom(ctx context.Context) *CommitHooks {
+	if ctx == nil {
+		return nil
+	}
+
+	hooks, _ := ctx.Value(hooksKey{}).(*CommitHooks)
+	return hooks
+}
+
+// AfterCommit runs fn once the transaction ctx carries is committed, or right
+// away if ctx carries none. fn is not run if the transaction, or the nested
+// transaction it was registered in, is rolled back.
+//
+// Side effects that must only be seen once the data is, such as evicting a
+// cache, belong in fn.
+func AfterCommit(ctx context.Context, fn func()) {
+	hooks := CommitHooksFrom(ctx)
+	if hooks == nil {
+		fn()
+		return
+	}
+
+	hooks.fns = append(hooks.fns, fn)
+}
+
+// Savepoint marks the hooks registered so far, so that the ones registered
+// by a nested transaction can be dropped with RollbackTo.
+func (h *CommitHooks) Savepoint() int {
+	return len(h.fns)
+}
+
+// RollbackTo drops the hooks registered since savepoint.
+func (h *CommitHooks) RollbackTo(savepoint int) {
+	h.fns = h.fns[:savepoint]
+}
+
+// Run runs the hooks in the order they were registered.
+func (h *CommitHooks) Run() {
+	for _, fn := range h.fns {
+		fn()
+	}
+}
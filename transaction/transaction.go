@@ -1,35 +1,160 @@
 package transaction
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/utils"
 	"gorm.io/gorm"
 )
 
+// DefaultMaxRetries is how many times a transaction aborted by a
+// serialization failure or a deadlock is retried by default.
+const DefaultMaxRetries = 3
+
+const retryBaseDelay = 10 * time.Millisecond
+
+type txKey struct{}
+
+// txState is the transaction a context carries.
+type txState struct {
+	tx         *gorm.DB
+	savepoints int
+}
+
 type TransactionManager struct {
 	DB *gorm.DB
+	// MaxRetries is how many times a transaction aborted by a serialization
+	// failure or a deadlock is run again before its error is returned.
+	MaxRetries int
 }
 
-var TxManager *TransactionManager
+func NewTransactionManager(db *gorm.DB) *TransactionManager {
+	return &TransactionManager{
+		DB:         db,
+		MaxRetries: DefaultMaxRetries,
+	}
+}
+
+// Option configures the transactions started by WithTransaction.
+type Option func(opts *sql.TxOptions)
 
-func InitTransactionManager(db *gorm.DB) {
-	TxManager = &TransactionManager{
-		DB: db,
+// WithIsolation runs the transaction with the given isolation level instead
+// of the database's default.
+func WithIsolation(level sql.IsolationLevel) Option {
+	return func(opts *sql.TxOptions) {
+		opts.Isolation = level
 	}
 }
 
-func (t *TransactionManager) WithTransaction(fn func(tx *gorm.DB) error) error {
-	tx := t.DB.Begin()
+func ReadOnly() Option {
+	return func(opts *sql.TxOptions) {
+		opts.ReadOnly = true
+	}
+}
+
+// WithTransaction runs fn in a transaction, which the context given to fn
+// carries: DB returns it for that context and its descendants. The
+// transaction is committed if fn returns nil and rolled back otherwise.
+//
+// When ctx already carries a transaction, fn runs in it within a savepoint,
+// which is rolled back if fn fails, and opts are ignored. Otherwise fn is run
+// again when the transaction is aborted by a serialization failure or a
+// deadlock, up to MaxRetries times, so it must not have side effects outside
+// the database. Such side effects can be deferred until the commit with
+// AfterCommit.
+func (t *TransactionManager) WithTransaction(ctx context.Context, fn func(ctx context.Context) error, opts ...Option) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return withSavepoint(ctx, state, fn)
+	}
+
+	var txOptions sql.TxOptions
+	for _, opt := range opts {
+		opt(&txOptions)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := t.run(ctx, fn, &txOptions)
+		if err == nil || !utils.IsTransactionConflict(err) || attempt >= t.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(retryBaseDelay << attempt):
+		}
+	}
+}
+
+func (t *TransactionManager) run(ctx context.Context, fn func(ctx context.Context) error, opts *sql.TxOptions) error {
+	tx := t.DB.WithContext(ctx).Begin(opts)
 	if tx.Error != nil {
 		return tx.Error
 	}
 
-	if err := fn(tx); err != nil {
-		tx.Rollback()
+	state := &txState{}
+	txCtx, hooks := WithCommitHooks(context.WithValue(ctx, txKey{}, state))
+	state.tx = tx.WithContext(txCtx)
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err := fn(txCtx); err != nil {
 		return err
 	}
 
 	if err := tx.Commit().Error; err != nil {
 		return err
 	}
+	committed = true
+	hooks.Run()
+
+	return nil
+}
+
+func withSavepoint(ctx context.Context, state *txState, fn func(ctx context.Context) error) error {
+	state.savepoints++
+	name := fmt.Sprintf("sp%d", state.savepoints)
+
+	if err := state.tx.SavePoint(name).Error; err != nil {
+		return err
+	}
+
+	hooks := CommitHooksFrom(ctx)
+	savepoint := hooks.Savepoint()
+	if err := fn(ctx); err != nil {
+		hooks.RollbackTo(savepoint)
+		if rollbackErr := state.tx.RollbackTo(name).Error; rollbackErr != nil {
+			return rollbackErr
+		}
+
+		return err
+	}
 
 	return nil
 }
+
+// DB returns the transaction ctx carries, or db bound to ctx if it carries
+// none. Queries meant to join the caller's transaction must go through it.
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if ctx == nil {
+		return db
+	}
+
+	if state, ok := ctx.Value(txKey{}).(*txState); ok {
+		return state.tx
+	}
+
+	return db.WithContext(ctx)
+}
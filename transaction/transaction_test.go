@@ -0,0 +1,222 @@
+package transaction_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vantutran2k1/social-network-auth/transaction"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestManager(t *testing.T) (*transaction.TransactionManager, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqlDB.Close()
+	})
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return transaction.NewTransactionManager(db), mock
+}
+
+// exec runs the statement in the transaction ctx carries.
+func exec(ctx context.Context, m *transaction.TransactionManager, statement string) error {
+	return transaction.DB(ctx, m.DB).Exec(statement).Error
+}
+
+func TestWithTransactionCommitsThenRunsHooksInOrder(t *testing.T) {
+	m, mock := newTestManager(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SAVEPOINT sp1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	var ran []string
+	err := m.WithTransaction(context.Background(), func(ctx context.Context) error {
+		transaction.AfterCommit(ctx, func() { ran = append(ran, "outer") })
+		if err := exec(ctx, m, "UPDATE a"); err != nil {
+			return err
+		}
+
+		err := m.WithTransaction(ctx, func(ctx context.Context) error {
+			transaction.AfterCommit(ctx, func() { ran = append(ran, "nested") })
+			return exec(ctx, m, "UPDATE b")
+		})
+		if len(ran) != 0 {
+			t.Errorf("hooks ran before the commit: %v", ran)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction() error = %v", err)
+	}
+
+	if len(ran) != 2 || ran[0] != "outer" || ran[1] != "nested" {
+		t.Errorf("hooks ran = %v, want [outer nested]", ran)
+	}
+}
+
+func TestWithTransactionRollsBackNestedTransactionToSavepoint(t *testing.T) {
+	m, mock := newTestManager(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SAVEPOINT sp1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE b").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT sp1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("SAVEPOINT sp2").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE c").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	nestedErr := errors.New("nested failure")
+	var ran []string
+	err := m.WithTransaction(context.Background(), func(ctx context.Context) error {
+		if err := exec(ctx, m, "UPDATE a"); err != nil {
+			return err
+		}
+
+		err := m.WithTransaction(ctx, func(ctx context.Context) error {
+			transaction.AfterCommit(ctx, func() { ran = append(ran, "rolled back") })
+			if err := exec(ctx, m, "UPDATE b"); err != nil {
+				return err
+			}
+			return nestedErr
+		})
+		if !errors.Is(err, nestedErr) {
+			t.Errorf("nested WithTransaction() error = %v, want %v", err, nestedErr)
+		}
+
+		return m.WithTransaction(ctx, func(ctx context.Context) error {
+			transaction.AfterCommit(ctx, func() { ran = append(ran, "committed") })
+			return exec(ctx, m, "UPDATE c")
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction() error = %v", err)
+	}
+
+	if len(ran) != 1 || ran[0] != "committed" {
+		t.Errorf("hooks ran = %v, want [committed]", ran)
+	}
+}
+
+func TestWithTransactionRollsBackOnError(t *testing.T) {
+	m, mock := newTestManager(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE a").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	fnErr := errors.New("failure")
+	ran := false
+	err := m.WithTransaction(context.Background(), func(ctx context.Context) error {
+		transaction.AfterCommit(ctx, func() { ran = true })
+		if err := exec(ctx, m, "UPDATE a"); err != nil {
+			return err
+		}
+		return fnErr
+	})
+	if !errors.Is(err, fnErr) {
+		t.Errorf("WithTransaction() error = %v, want %v", err, fnErr)
+	}
+	if ran {
+		t.Error("hook of a rolled back transaction ran")
+	}
+}
+
+func TestWithTransactionRetriesConflicts(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+	}{
+		{name: "serialization failure", code: "40001"},
+		{name: "deadlock", code: "40P01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, mock := newTestManager(t)
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE a").WillReturnError(&pgconn.PgError{Code: tt.code})
+			mock.ExpectRollback()
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE a").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			attempts, hooks := 0, 0
+			err := m.WithTransaction(context.Background(), func(ctx context.Context) error {
+				attempts++
+				transaction.AfterCommit(ctx, func() { hooks++ })
+				return exec(ctx, m, "UPDATE a")
+			})
+			if err != nil {
+				t.Fatalf("WithTransaction() error = %v", err)
+			}
+			if attempts != 2 || hooks != 1 {
+				t.Errorf("attempts = %d, hooks run = %d, want 2 and 1", attempts, hooks)
+			}
+		})
+	}
+}
+
+func TestWithTransactionGivesUpAfterMaxRetries(t *testing.T) {
+	m, mock := newTestManager(t)
+	m.MaxRetries = 1
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE a").WillReturnError(&pgconn.PgError{Code: "40001"})
+		mock.ExpectRollback()
+	}
+
+	attempts := 0
+	err := m.WithTransaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return exec(ctx, m, "UPDATE a")
+	})
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "40001" {
+		t.Errorf("WithTransaction() error = %v, want the serialization failure", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+}
+
+func TestWithTransactionDoesNotRetryOtherErrors(t *testing.T) {
+	m, mock := newTestManager(t)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE a").WillReturnError(&pgconn.PgError{Code: "23505"})
+	mock.ExpectRollback()
+
+	attempts := 0
+	err := m.WithTransaction(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return exec(ctx, m, "UPDATE a")
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("WithTransaction() error = %v after %d attempts, want the error after 1", err, attempts)
+	}
+}
+
+func TestAfterCommitRunsRightAwayOutsideTransactions(t *testing.T) {
+	ran := false
+	transaction.AfterCommit(context.Background(), func() { ran = true })
+	if !ran {
+		t.Error("AfterCommit() did not run the hook")
+	}
+}
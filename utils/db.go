@@ -2,10 +2,46 @@ package utils
 
 import (
 	"errors"
+	"strings"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
+const (
+	uniqueViolationCode      = "23505"
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+)
+
+// ErrDuplicateKey is returned by stores that are not backed by Postgres when
+// a write would break a unique constraint.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// EscapeLike escapes the wildcards of a LIKE pattern, so that s matches
+// itself only.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
 func IsRecordNotFound(err error) bool {
 	return errors.Is(err, gorm.ErrRecordNotFound)
 }
+
+func IsUniqueViolation(err error) bool {
+	if errors.Is(err, ErrDuplicateKey) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+// IsTransactionConflict tells whether the transaction was aborted because
+// it conflicted with a concurrent one, and can succeed if run again.
+func IsTransactionConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == serializationFailureCode || pgErr.Code == deadlockDetectedCode)
+}
@@ -1,29 +1,52 @@
 package utils
 
 import (
-	"os"
-	"strconv"
+	"context"
 
+	"github.com/vantutran2k1/social-network-auth/config"
 	"github.com/vantutran2k1/social-network-auth/errors"
+	"github.com/vantutran2k1/social-network-auth/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/gomail.v2"
 )
 
-func SendEmail(to, subject, body string) *errors.ApiError {
-	m := gomail.NewMessage()
-	m.SetHeader("From", os.Getenv("SMTP_FROM_EMAIL"))
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", subject)
-	m.SetBody("text/html", body)
+// Mailer sends HTML emails to users.
+type Mailer interface {
+	SendEmail(ctx context.Context, to, subject, body string) *errors.ApiError
+}
 
-	smtpPort, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
-	if err != nil {
-		return errors.InternalServerError(err.Error())
-	}
-	d := gomail.NewDialer(os.Getenv("SMTP_HOST"), smtpPort, os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD"))
+type SMTPMailer struct {
+	Config *config.SMTPConfig
+}
+
+func NewSMTPMailer(cfg *config.SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{Config: cfg}
+}
+
+func (m *SMTPMailer) SendEmail(ctx context.Context, to, subject, body string) *errors.ApiError {
+	_, span := tracer.Start(ctx, "smtp.SendEmail", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("server.address", m.Config.Host),
+		attribute.Int("server.port", m.Config.Port),
+	))
+	defer span.End()
+
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", m.Config.FromEmail)
+	msg.SetHeader("To", to)
+	msg.SetHeader("Subject", subject)
+	msg.SetBody("text/html", body)
+
+	d := gomail.NewDialer(m.Config.Host, m.Config.Port, m.Config.User, m.Config.Password)
 
-	if err := d.DialAndSend(m); err != nil {
+	if err := d.DialAndSend(msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "sending failed")
+		metrics.EmailsSent.WithLabelValues("failure").Inc()
 		return errors.InternalServerError(err.Error())
 	}
+	metrics.EmailsSent.WithLabelValues("success").Inc()
 
 	return nil
 }
@@ -0,0 +1,134 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// CanonicalizeUsername returns the form usernames are compared in: trimmed,
+// NFKC-normalised and case-folded, so that "Alice" and "ａｌｉｃｅ" match.
+func CanonicalizeUsername(username string) string {
+	return canonicalize(username)
+}
+
+// CanonicalizeEmail returns the form emails are compared in. The local part
+// is folded too, as virtually all mail providers treat it case-insensitively.
+func CanonicalizeEmail(email string) string {
+	return canonicalize(email)
+}
+
+// UsernameSkeleton returns a string that is equal for usernames that look
+// alike, e.g. "paypal" written with a Cyrillic "а", or "rnicrosoft" and
+// "microsoft". It follows the skeleton algorithm of UTS #39, using a bundled
+// subset of the Unicode confusables data, and also ignores diacritics.
+func UsernameSkeleton(username string) string {
+	decomposed := norm.NFD.String(canonicalize(username))
+
+	var sb strings.Builder
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if prototype, ok := confusables[r]; ok {
+			sb.WriteString(prototype)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	skeleton := sb.String()
+	for _, s := range confusableSequences {
+		skeleton = strings.ReplaceAll(skeleton, s.sequence, s.prototype)
+	}
+
+	return norm.NFD.String(skeleton)
+}
+
+func canonicalize(s string) string {
+	s = norm.NFKC.String(strings.TrimSpace(s))
+	s = cases.Fold().String(s)
+	return norm.NFKC.String(s)
+}
+
+// confusables maps characters to the ASCII prototype they are commonly
+// confused with. Only lower case forms are needed, since skeletons are built
+// from case-folded usernames.
+var confusables = map[rune]string{
+	// Digits and punctuation
+	'0': "o",
+	'1': "l",
+	'|': "l",
+	'ı': "i",
+	'ǀ': "l",
+
+	// Cyrillic
+	'а': "a",
+	'в': "b",
+	'ԁ': "d",
+	'е': "e",
+	'һ': "h",
+	'і': "i",
+	'ј': "j",
+	'к': "k",
+	'ӏ': "l",
+	'м': "m",
+	'н': "h",
+	'о': "o",
+	'р': "p",
+	'ԛ': "q",
+	'г': "r",
+	'ѕ': "s",
+	'т': "t",
+	'ц': "u",
+	'ѵ': "v",
+	'ԝ': "w",
+	'х': "x",
+	'у': "y",
+	'ү': "y",
+	'з': "3",
+
+	// Greek
+	'α': "a",
+	'β': "b",
+	'ε': "e",
+	'η': "n",
+	'ι': "i",
+	'κ': "k",
+	'ν': "v",
+	'ο': "o",
+	'ρ': "p",
+	'τ': "t",
+	'υ': "u",
+	'χ': "x",
+	'γ': "y",
+	'ω': "w",
+
+	// Latin look-alikes
+	'ɑ': "a",
+	'ɡ': "g",
+	'ɩ': "i",
+	'ʟ': "l",
+	'ɴ': "n",
+	'ᴏ': "o",
+	'ʀ': "r",
+	'ꜱ': "s",
+	'ᴜ': "u",
+	'ᴠ': "v",
+	'ᴡ': "w",
+	'ʏ': "y",
+	'ᴢ': "z",
+}
+
+// confusableSequences are multi-character sequences that render like a
+// single character, applied after the per-character mapping.
+var confusableSequences = []struct {
+	sequence  string
+	prototype string
+}{
+	{sequence: "rn", prototype: "m"},
+	{sequence: "vv", prototype: "w"},
+	{sequence: "cl", prototype: "d"},
+}
@@ -1,15 +1,13 @@
 package utils
 
 import (
-	"os"
-
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 )
 
-var JwtKey = []byte(os.Getenv("JWT_KEY"))
-
 type Claims struct {
 	UserID uuid.UUID `json:"user_id"`
+	// ImpersonatorID is the admin acting as UserID, set on impersonation tokens only.
+	ImpersonatorID *uuid.UUID `json:"impersonator_id,omitempty"`
 	jwt.StandardClaims
 }
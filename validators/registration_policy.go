@@ -0,0 +1,210 @@
+package validators
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vantutran2k1/social-network-auth/config"
+	"github.com/vantutran2k1/social-network-auth/utils"
+)
+
+//go:embed data/disposable_email_domains.txt
+var disposableEmailDomainsFile string
+
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "sysadmin", "superuser",
+	"support", "help", "helpdesk", "info", "contact", "security", "abuse",
+	"postmaster", "hostmaster", "webmaster", "noreply", "no-reply",
+	"moderator", "mod", "staff", "team", "official", "billing", "legal",
+	"api", "www", "mail", "auth", "login", "register", "signup", "account",
+	"me", "null", "undefined", "anonymous",
+}
+
+// RegistrationPolicy holds the rules new usernames and emails must follow.
+type RegistrationPolicy struct {
+	ReservedUsernames      map[string]bool
+	BlockedWords           []string
+	UsernamePattern        *regexp.Regexp
+	UsernameMinLength      int
+	UsernameMaxLength      int
+	EmailDomainAllowlist   []string
+	EmailDomainDenylist    []string
+	BlockDisposableEmails  bool
+	DisposableEmailDomains map[string]bool
+	InviteOnly             bool
+}
+
+var disposableEmailDomains = parseDomainList(disposableEmailDomainsFile)
+
+// NewRegistrationPolicy builds the registration policy from cfg. The
+// configured reserved usernames are added to the built-in ones.
+func NewRegistrationPolicy(cfg *config.RegistrationConfig) (*RegistrationPolicy, error) {
+	pattern, err := regexp.Compile(cfg.UsernamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username pattern: %w", err)
+	}
+
+	return &RegistrationPolicy{
+		ReservedUsernames:      toSet(slices.Concat(defaultReservedUsernames, cfg.ReservedUsernames)),
+		BlockedWords:           canonicalizeList(cfg.BlockedWords),
+		UsernamePattern:        pattern,
+		UsernameMinLength:      cfg.UsernameMinLength,
+		UsernameMaxLength:      cfg.UsernameMaxLength,
+		EmailDomainAllowlist:   canonicalizeList(cfg.EmailDomainAllowlist),
+		EmailDomainDenylist:    canonicalizeList(cfg.EmailDomainDenylist),
+		BlockDisposableEmails:  cfg.BlockDisposableEmails,
+		DisposableEmailDomains: disposableEmailDomains,
+		InviteOnly:             cfg.InviteOnly,
+	}, nil
+}
+
+// ValidateRegistration checks a registration against the policy and returns
+// one error per rejected field. The invite code itself is checked when the
+// user is created.
+func (p *RegistrationPolicy) ValidateRegistration(username string, email string, inviteCode string) []ErrorMessage {
+	var errs []ErrorMessage
+
+	if msg := p.checkUsername(username); msg != "" {
+		errs = append(errs, ErrorMessage{Field: "username", Message: msg})
+	}
+
+	if msg := p.checkEmail(email); msg != "" {
+		errs = append(errs, ErrorMessage{Field: "email", Message: msg})
+	}
+
+	if p.InviteOnly && inviteCode == "" {
+		errs = append(errs, ErrorMessage{Field: "invite_code", Message: "Registration is currently by invitation only"})
+	}
+
+	return errs
+}
+
+// ValidateUsername checks a new username of an existing user.
+func (p *RegistrationPolicy) ValidateUsername(field string, username string) []ErrorMessage {
+	if msg := p.checkUsername(username); msg != "" {
+		return []ErrorMessage{{Field: field, Message: msg}}
+	}
+
+	return nil
+}
+
+// ValidateEmail checks a new email of an existing user.
+func (p *RegistrationPolicy) ValidateEmail(field string, email string) []ErrorMessage {
+	if msg := p.checkEmail(email); msg != "" {
+		return []ErrorMessage{{Field: field, Message: msg}}
+	}
+
+	return nil
+}
+
+func (p *RegistrationPolicy) checkUsername(username string) string {
+	length := utf8.RuneCountInString(username)
+	if length < p.UsernameMinLength || length > p.UsernameMaxLength {
+		return fmt.Sprintf("Should be between %d and %d characters long", p.UsernameMinLength, p.UsernameMaxLength)
+	}
+
+	if !p.UsernamePattern.MatchString(username) {
+		return "Contains characters that are not allowed"
+	}
+
+	canonical := utils.CanonicalizeUsername(username)
+	skeleton := utils.UsernameSkeleton(username)
+	for reserved := range p.ReservedUsernames {
+		if canonical == reserved || skeleton == utils.UsernameSkeleton(reserved) {
+			return "This username is reserved"
+		}
+	}
+
+	for _, word := range p.BlockedWords {
+		if strings.Contains(canonical, word) {
+			return "This username is not allowed"
+		}
+	}
+
+	return ""
+}
+
+func (p *RegistrationPolicy) checkEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "Should be a valid email address"
+	}
+	domain := utils.CanonicalizeEmail(email[at+1:])
+
+	if len(p.EmailDomainAllowlist) > 0 && !matchesAnyDomain(domain, p.EmailDomainAllowlist) {
+		return "Email domain is not allowed"
+	}
+
+	if matchesAnyDomain(domain, p.EmailDomainDenylist) {
+		return "Email domain is not allowed"
+	}
+
+	if p.BlockDisposableEmails && isListedDomain(domain, p.DisposableEmailDomains) {
+		return "Disposable email addresses are not allowed"
+	}
+
+	return ""
+}
+
+// matchesAnyDomain reports whether domain is one of domains or a subdomain of one.
+func matchesAnyDomain(domain string, domains []string) bool {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isListedDomain(domain string, domains map[string]bool) bool {
+	for {
+		if domains[domain] {
+			return true
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+func parseDomainList(content string) map[string]bool {
+	domains := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[strings.ToLower(line)] = true
+	}
+
+	return domains
+}
+
+func canonicalizeList(items []string) []string {
+	canonical := make([]string, 0, len(items))
+	for _, item := range items {
+		canonical = append(canonical, utils.CanonicalizeUsername(item))
+	}
+
+	return canonical
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[utils.CanonicalizeUsername(item)] = true
+	}
+
+	return set
+}
@@ -4,16 +4,23 @@ import (
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
 	"regexp"
+	"sync"
 	"time"
 )
 
+var registerOnce sync.Once
+
+// RegisterCustomValidators adds our validations to gin's validator, which is
+// shared by the whole process. Only the first call has an effect.
 func RegisterCustomValidators() {
-	v, ok := binding.Validator.Engine().(*validator.Validate)
-	if ok {
-		v.RegisterValidation("date", isValidDate)
-		v.RegisterValidation("beforeToday", isBeforeToday)
-		v.RegisterValidation("phoneNumber", isValidPhoneNumber)
-	}
+	registerOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if ok {
+			v.RegisterValidation("date", isValidDate)
+			v.RegisterValidation("beforeToday", isBeforeToday)
+			v.RegisterValidation("phoneNumber", isValidPhoneNumber)
+		}
+	})
 }
 
 func isValidDate(fl validator.FieldLevel) bool {
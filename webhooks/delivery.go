@@ -0,0 +1,142 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vantutran2k1/social-network-auth/events"
+	"github.com/vantutran2k1/social-network-auth/models"
+)
+
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventTypeHeader = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+
+	deliveryTimeout = 10 * time.Second
+	deliveryLease   = 5 * time.Minute
+	maxRetryDelay   = 6 * time.Hour
+)
+
+// Dispatcher sends due webhook deliveries.
+type Dispatcher struct {
+	Store       models.Store
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+}
+
+func NewDispatcher(store models.Store, maxAttempts int, baseDelay time.Duration) *Dispatcher {
+	return &Dispatcher{
+		Store:       store,
+		Client:      &http.Client{Timeout: deliveryTimeout},
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+	}
+}
+
+// DeliverDue sends up to batchSize due deliveries and returns how many were
+// attempted.
+func (d *Dispatcher) DeliverDue(ctx context.Context, batchSize int) (int, error) {
+	deliveries, err := models.ClaimDueWebhookDeliveries(ctx, d.Store, batchSize, deliveryLease)
+	if err != nil {
+		return 0, err
+	}
+
+	endpoints := map[string]*models.WebhookEndpoint{}
+	for i := range deliveries {
+		delivery := &deliveries[i]
+
+		key := delivery.EndpointID.String()
+		endpoint, ok := endpoints[key]
+		if !ok {
+			endpoint = &models.WebhookEndpoint{}
+			if err := endpoint.GetWebhookEndpoint(ctx, d.Store, delivery.EndpointID); err != nil {
+				return i, err
+			}
+			endpoints[key] = endpoint
+		}
+
+		if err := d.deliver(ctx, endpoint, delivery); err != nil {
+			return i, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) error {
+	// Deliveries queued before an endpoint was disabled are not sent.
+	if !endpoint.Active {
+		return delivery.RecordAttempt(ctx, d.Store, nil, fmt.Errorf("endpoint is disabled"), 0, nil)
+	}
+
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, endpoint, delivery)
+	duration := time.Since(start)
+
+	var retryAfter *time.Duration
+	if sendErr != nil && delivery.Attempts+1 < d.MaxAttempts {
+		delay := d.retryDelay(delivery.Attempts + 1)
+		retryAfter = &delay
+	}
+
+	return delivery.RecordAttempt(ctx, d.Store, statusCode, sendErr, duration, retryAfter)
+}
+
+func (d *Dispatcher) send(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) (*int, error) {
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "social-network-auth-webhooks/1")
+	req.Header.Set(EventTypeHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, delivery.ID.String())
+	req.Header.Set(SignatureHeader, Signature(endpoint.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	statusCode := resp.StatusCode
+	if statusCode < 200 || statusCode >= 300 {
+		return &statusCode, fmt.Errorf("endpoint responded with status %d", statusCode)
+	}
+
+	return &statusCode, nil
+}
+
+// retryDelay grows exponentially with the number of attempts made, with up
+// to 10% jitter so that retries to a recovering endpoint are spread out.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := time.Duration(float64(d.BaseDelay) * math.Pow(2, float64(attempts-1)))
+	if delay <= 0 || delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// Signature returns the value of the X-Webhook-Signature header for body
+// sent at timestamp: "t=<timestamp>,v1=<hex HMAC-SHA256 of timestamp.body>".
+// Receivers should reject old timestamps to prevent replays.
+func Signature(secret string, timestamp string, body []byte) string {
+	signed := append([]byte(timestamp+"."), body...)
+	return "t=" + timestamp + ",v1=" + events.Sign(secret, signed)
+}